@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func backupProject(s *session, args []string) error {
+	if err := s.parse(args, 0, "out"); err != nil {
+		return err
+	}
+	out, err := s.output()
+	if err != nil {
+		return err
+	}
+	manifest, err := s.client.Backup(out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*s.out)
+		return err
+	}
+	fmt.Fprintf(s.stderr, "redcap backup: wrote project %d (%s), %d files, to %s\n", manifest.ProjectID, manifest.ProjectTitle, len(manifest.Files), *s.out)
+	return nil
+}
+
+// restoreBackup checks an archive and, unless -verify is set, restores it
+// into a new project. The token must be a super API token; the new
+// project's token is written out, also when restoring fails part way.
+func restoreBackup(s *session, args []string) error {
+	verify := s.flags.Bool("verify", false, "only check the archive against its manifest and print the manifest")
+	if err := s.parseFlags(args, 1); err != nil {
+		return err
+	}
+
+	file, err := os.Open(s.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	archive, err := redcap.OpenBackup(file, info.Size())
+	if err != nil {
+		return err
+	}
+	if *verify {
+		return s.writeJSON(archive.Manifest)
+	}
+
+	if s.client, err = s.connect(); err != nil {
+		return err
+	}
+	token, err := archive.Restore(&s.client)
+	if token != "" {
+		if writeErr := s.write([]byte(token)); err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
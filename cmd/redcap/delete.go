@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+var deleteActions = map[string]action{
+	"records":         {usage: "[-arm N] [-instrument NAME] [-event EVENT] [-instance N] [-delete-logging] (-dry-run | -yes) RECORD...", summary: "delete records, or some of their data", run: deleteRecords},
+	"arms":            {usage: "ARM...", summary: "delete arms and their events", run: deleteItems((*redcap.RedCapClient).DeleteArms)},
+	"events":          {usage: "EVENT...", summary: "delete events", run: deleteItems((*redcap.RedCapClient).DeleteEvents)},
+	"dags":            {usage: "DAG...", summary: "delete data access groups", run: deleteItems((*redcap.RedCapClient).DeleteDags)},
+	"users":           {usage: "USER...", summary: "remove users from the project", run: deleteItems((*redcap.RedCapClient).DeleteUsers)},
+	"user-roles":      {usage: "ROLE...", summary: "delete user roles", run: deleteItems((*redcap.RedCapClient).DeleteUserRoles)},
+	"file":            {usage: "-record ID -field NAME [-event EVENT] [-instance N]", summary: "delete a file from a file upload field", run: deleteFile},
+	"repository-file": {usage: "-doc ID", summary: "delete a File Repository file", run: deleteRepositoryFile},
+}
+
+// deleteItems runs one of the deletes that take a list of names.
+func deleteItems(method func(*redcap.RedCapClient, []string) ([]byte, error)) func(*session, []string) error {
+	return func(s *session, args []string) error {
+		if err := s.parse(args, oneOrMore); err != nil {
+			return err
+		}
+		if err := checkNames(s.flags.Args()); err != nil {
+			return err
+		}
+		body, err := method(&s.client, s.flags.Args())
+		if err != nil {
+			return err
+		}
+		return s.write(body)
+	}
+}
+
+func deleteRecords(s *session, args []string) error {
+	options := redcap.DeleteRecordsOptions{}
+	s.flags.StringVar(&options.Arm, "arm", "", "only delete the records from this arm")
+	s.flags.StringVar(&options.Instrument, "instrument", "", "only delete the data of this instrument")
+	s.flags.StringVar(&options.Event, "event", "", "only delete the data of this event")
+	s.flags.IntVar(&options.RepeatInstance, "instance", 0, "only delete this repeat instance of the instrument")
+	s.flags.BoolVar(&options.DeleteLogging, "delete-logging", false, "also delete the records' logging")
+	s.flags.BoolVar(&options.DryRun, "dry-run", false, "list what would be deleted without deleting it")
+	s.flags.BoolVar(&options.Confirm, "yes", false, "delete without a dry run")
+	if err := s.parse(args, oneOrMore); err != nil {
+		return err
+	}
+	if err := checkNames(s.flags.Args()); err != nil {
+		return err
+	}
+	if options.DryRun == options.Confirm {
+		return usagef("give -dry-run to list what would be deleted, or -yes to delete it")
+	}
+
+	count, plan, err := s.client.DeleteRecords(s.flags.Args(), options)
+	if err != nil {
+		return err
+	}
+	if options.DryRun {
+		return s.writeJSON(plan)
+	}
+	return s.writeJSON(map[string]int{"count": count})
+}
+
+// checkNames refuses names that look like flags. Flags are only read
+// before the first name, so "delete records 12 -dry-run" would otherwise
+// delete record 12.
+func checkNames(names []string) error {
+	for _, name := range names {
+		if strings.HasPrefix(name, "-") {
+			return usagef("%s must come before the names to delete", name)
+		}
+	}
+	return nil
+}
+
+func deleteFile(s *session, args []string) error {
+	record, field, event, instance := fileFlags(s)
+	if err := s.parse(args, 0, "record", "field"); err != nil {
+		return err
+	}
+	body, err := s.client.DeleteFile(*record, *field, *event, *instance)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+func deleteRepositoryFile(s *session, args []string) error {
+	doc := s.flags.Int("doc", 0, "the document ID")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	if *doc == 0 {
+		return usagef("-doc is required")
+	}
+	return s.client.DeleteRepositoryFile(*doc)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+var exportActions = map[string]action{
+	"records":            {usage: "[-records IDS] [-fields NAMES] [-forms NAMES] [-events NAMES] [-labels] [-filter LOGIC] [-survey-fields] [-dags] [-begin TIME] [-end TIME]", summary: "export records", run: exportRecords},
+	"metadata":           {summary: "export the data dictionary", run: exportRaw((*redcap.RedCapClient).ExportMetadata, dataDictionary)},
+	"instruments":        {summary: "export the instruments", run: exportRaw((*redcap.RedCapClient).ExportInstruments, nil)},
+	"field-names":        {usage: "[-field NAME]", summary: "export the export field names", run: exportFieldNames},
+	"arms":               {summary: "export the arms", run: exportRaw((*redcap.RedCapClient).ExportArms, armList)},
+	"events":             {summary: "export the events", run: exportRaw((*redcap.RedCapClient).ExportEvents, eventList)},
+	"mappings":           {summary: "export the instrument-event mappings", run: exportRaw((*redcap.RedCapClient).ExportInstrumentEventMaps, mappingList)},
+	"dags":               {summary: "export the data access groups", run: exportRaw((*redcap.RedCapClient).ExportDags, dagList)},
+	"user-dag-maps":      {summary: "export the user-DAG assignments", run: exportRaw((*redcap.RedCapClient).ExportDagMaps, nil)},
+	"users":              {summary: "export the users", run: exportRaw((*redcap.RedCapClient).ExportUsers, userList)},
+	"user-roles":         {summary: "export the user roles", run: exportRaw((*redcap.RedCapClient).ExportUserRoles, nil)},
+	"project":            {summary: "export the project information", run: exportRaw((*redcap.RedCapClient).ExportProject, nil)},
+	"version":            {summary: "export the REDCap version", run: exportRaw((*redcap.RedCapClient).ExportRedcapVersion, nil)},
+	"project-xml":        {usage: "[-metadata-only] [-records IDS] [-fields NAMES] [-events NAMES] [-filter LOGIC] [-survey-fields] [-dags] [-files]", summary: "export the project as CDISC ODM XML", run: exportProjectXML},
+	"logging":            {usage: "[-type TYPE] [-user NAME] [-record ID] [-dag DAG] [-begin TIME] [-end TIME]", summary: "export the project logging", run: exportLogging},
+	"report":             {usage: "-id ID", summary: "export a report", run: exportReport},
+	"file":               {usage: "-record ID -field NAME [-event EVENT] [-instance N]", summary: "export a file from a file upload field", run: exportFile},
+	"pdf":                {usage: "[-record ID] [-event EVENT] [-instrument NAME] [-instance N] [-all] [-compact]", summary: "export instruments as a PDF", run: exportPDF},
+	"participants":       {usage: "-instrument NAME [-event EVENT]", summary: "export a survey participant list", run: exportParticipants},
+	"survey-link":        {usage: "-record ID -instrument NAME [-event EVENT]", summary: "export a survey link", run: exportSurvey((*redcap.RedCapClient).ExportSurveyLink)},
+	"survey-queue-link":  {usage: "-record ID -instrument NAME [-event EVENT]", summary: "export a survey queue link", run: exportSurvey((*redcap.RedCapClient).ExportSurveyQueueLink)},
+	"survey-return-code": {usage: "-record ID -instrument NAME [-event EVENT]", summary: "export a survey return code", run: exportSurvey((*redcap.RedCapClient).ExportSurveyReturnCode)},
+	"survey-links":       {usage: "-instrument NAME [-event EVENT] [-records IDS] [-concurrency N]", summary: "export links and return codes for many records", run: exportSurveyLinks},
+	"completion":         {usage: "[-records IDS]", summary: "export the survey completion matrix as CSV", run: exportCompletion},
+	"repository":         {usage: "[-folder ID]", summary: "list a File Repository folder", run: exportRepository},
+	"repository-file":    {usage: "-doc ID", summary: "export a File Repository file", run: exportRepositoryFile},
+	"repository-mirror":  {usage: "-dir DIR [-folder ID]", summary: "copy a File Repository folder to a directory", run: exportRepositoryMirror},
+	"stats":              {usage: "-package r|sas|spss|stata -dir DIR [-name NAME] [-records IDS] [-fields NAMES] [-forms NAMES] [-events NAMES]", summary: "export records with a statistical package import script", run: exportStats},
+}
+
+// exportRaw runs a method that takes no options and writes its response.
+// When the result is rendered with -output, the typed export is used
+// instead if there is one.
+func exportRaw(method func(*redcap.RedCapClient) ([]byte, error), typed func(*redcap.RedCapClient) (interface{}, error)) func(*session, []string) error {
+	return func(s *session, args []string) error {
+		if err := s.parse(args, 0); err != nil {
+			return err
+		}
+		if typed != nil && s.rendering() {
+			result, err := typed(&s.client)
+			if err != nil {
+				return err
+			}
+			return s.writeJSON(result)
+		}
+		body, err := method(&s.client)
+		if err != nil {
+			return err
+		}
+		return s.write(body)
+	}
+}
+
+// The typed exports used to render results.
+
+func dataDictionary(c *redcap.RedCapClient) (interface{}, error) {
+	return c.ExportDataDictionary()
+}
+
+func armList(c *redcap.RedCapClient) (interface{}, error) {
+	return c.ExportArmDefinitions()
+}
+
+func eventList(c *redcap.RedCapClient) (interface{}, error) {
+	return c.ExportEventDefinitions()
+}
+
+func mappingList(c *redcap.RedCapClient) (interface{}, error) {
+	return c.ExportFormEventMappings()
+}
+
+func dagList(c *redcap.RedCapClient) (interface{}, error) {
+	return c.ExportDataAccessGroups()
+}
+
+func userList(c *redcap.RedCapClient) (interface{}, error) {
+	return c.ExportUserList()
+}
+
+// recordFlags defines the flags shared by commands that select records.
+func recordFlags(s *session) *redcap.RecordsOptions {
+	options := &redcap.RecordsOptions{}
+	s.flags.Func("records", "comma separated record names", func(value string) error {
+		options.Records = list(value)
+		return nil
+	})
+	s.flags.Func("fields", "comma separated field names", func(value string) error {
+		options.Fields = list(value)
+		return nil
+	})
+	s.flags.Func("forms", "comma separated instrument names", func(value string) error {
+		options.Forms = list(value)
+		return nil
+	})
+	s.flags.Func("events", "comma separated unique event names", func(value string) error {
+		options.Events = list(value)
+		return nil
+	})
+	return options
+}
+
+func exportRecords(s *session, args []string) error {
+	options := recordFlags(s)
+	labels := s.flags.Bool("labels", false, "export choice labels instead of raw codes")
+	s.flags.StringVar(&options.FilterLogic, "filter", "", "REDCap filter logic")
+	s.flags.BoolVar(&options.ExportSurveyFields, "survey-fields", false, "add survey identifier and timestamp columns")
+	s.flags.BoolVar(&options.ExportDataAccessGroups, "dags", false, "add the data access group column")
+	for _, t := range []struct {
+		name  string
+		usage string
+		time  *time.Time
+	}{
+		{"begin", "only records created or modified at or after this time, as YYYY-MM-DD HH:MM:SS", &options.DateRangeBegin},
+		{"end", "only records created or modified before this time, as YYYY-MM-DD HH:MM:SS", &options.DateRangeEnd},
+	} {
+		t := t
+		s.flags.Func(t.name, t.usage, func(value string) error {
+			parsed, err := time.Parse(redcap.DateRangeFormat, value)
+			if err != nil {
+				return errors.New("expected YYYY-MM-DD HH:MM:SS")
+			}
+			*t.time = parsed
+			return nil
+		})
+	}
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	if *labels {
+		options.RawOrLabel = "label"
+	}
+	if s.rendering() {
+		header, rows, err := s.client.ExportRecordRows(*options)
+		if err != nil {
+			return err
+		}
+		r := &results{columns: header}
+		for _, row := range rows {
+			values := make(map[string]interface{}, len(row))
+			for column, value := range row {
+				values[column] = value
+			}
+			r.rows = append(r.rows, values)
+		}
+		return s.render(r)
+	}
+	body, err := s.client.ExportRecords(*options)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+func exportFieldNames(s *session, args []string) error {
+	field := s.flags.String("field", "", "only this field")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	body, err := s.client.ExportFieldNames(*field)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+func exportProjectXML(s *session, args []string) error {
+	records := recordFlags(s)
+	options := redcap.ProjectXMLOptions{}
+	s.flags.BoolVar(&options.ReturnMetadataOnly, "metadata-only", false, "leave out the records")
+	s.flags.StringVar(&options.FilterLogic, "filter", "", "REDCap filter logic")
+	s.flags.BoolVar(&options.ExportSurveyFields, "survey-fields", false, "add survey fields")
+	s.flags.BoolVar(&options.ExportDataAccessGroups, "dags", false, "add data access groups")
+	s.flags.BoolVar(&options.ExportFiles, "files", false, "embed uploaded files")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	options.Records, options.Fields, options.Events = records.Records, records.Fields, records.Events
+	return s.writeTo(func(w io.Writer) error {
+		_, err := s.client.ExportProjectXML(w, options)
+		return err
+	})
+}
+
+func exportLogging(s *session, args []string) error {
+	options := redcap.LoggingOptions{}
+	logType := s.flags.String("type", "", "only entries of this log type, such as record or record_delete")
+	s.flags.StringVar(&options.User, "user", "", "only entries by this user")
+	s.flags.StringVar(&options.Record, "record", "", "only entries for this record")
+	s.flags.StringVar(&options.Dag, "dag", "", "only entries for this data access group")
+	begin := s.flags.String("begin", "", "only entries at or after this time, as YYYY-MM-DD HH:MM")
+	end := s.flags.String("end", "", "only entries at or before this time, as YYYY-MM-DD HH:MM")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	options.LogType = redcap.LogType(*logType)
+	for _, t := range []struct {
+		value string
+		time  *time.Time
+	}{{*begin, &options.BeginTime}, {*end, &options.EndTime}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(redcap.LoggingTimeFormat, t.value)
+		if err != nil {
+			return usagef("invalid time %q, expected YYYY-MM-DD HH:MM", t.value)
+		}
+		*t.time = parsed
+	}
+	entries, err := s.client.ExportLogging(options)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(entries)
+}
+
+func exportReport(s *session, args []string) error {
+	id := s.flags.String("id", "", "the report ID")
+	if err := s.parse(args, 0, "id"); err != nil {
+		return err
+	}
+	body, err := s.client.ExportReports(*id)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+// fileFlags defines the flags that locate a file upload field value.
+func fileFlags(s *session) (record *string, field *string, event *string, instance *int) {
+	record = s.flags.String("record", "", "the record")
+	field = s.flags.String("field", "", "the file upload field")
+	event = s.flags.String("event", "", "the unique event name, for longitudinal projects")
+	instance = s.flags.Int("instance", 0, "the repeat instance")
+	return record, field, event, instance
+}
+
+func exportFile(s *session, args []string) error {
+	record, field, event, instance := fileFlags(s)
+	if err := s.parse(args, 0, "record", "field"); err != nil {
+		return err
+	}
+	body, err := s.client.ExportFile(*record, *field, *event, *instance)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+func exportPDF(s *session, args []string) error {
+	options := redcap.PDFOptions{}
+	s.flags.StringVar(&options.Record, "record", "", "fill in the data of this record")
+	s.flags.StringVar(&options.Event, "event", "", "only this event")
+	s.flags.StringVar(&options.Instrument, "instrument", "", "only this instrument")
+	s.flags.IntVar(&options.RepeatInstance, "instance", 0, "the repeat instance")
+	s.flags.BoolVar(&options.AllRecords, "all", false, "fill in the data of every record")
+	s.flags.BoolVar(&options.CompactDisplay, "compact", false, "leave out empty fields")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	return s.writeTo(func(w io.Writer) error {
+		_, err := s.client.ExportInstrumentPDF(w, options)
+		return err
+	})
+}
+
+func exportParticipants(s *session, args []string) error {
+	instrument := s.flags.String("instrument", "", "the survey instrument")
+	event := s.flags.String("event", "", "the unique event name, for longitudinal projects")
+	if err := s.parse(args, 0, "instrument"); err != nil {
+		return err
+	}
+	if s.rendering() {
+		participants, err := s.client.ExportSurveyParticipantList(*instrument, *event)
+		if err != nil {
+			return err
+		}
+		return s.writeJSON(participants)
+	}
+	body, err := s.client.ExportSurveyParticipants(*instrument, *event)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+// exportSurvey runs one of the survey link and return code exports.
+func exportSurvey(method func(*redcap.RedCapClient, string, string, string) ([]byte, error)) func(*session, []string) error {
+	return func(s *session, args []string) error {
+		record := s.flags.String("record", "", "the record")
+		instrument := s.flags.String("instrument", "", "the survey instrument")
+		event := s.flags.String("event", "", "the unique event name, for longitudinal projects")
+		if err := s.parse(args, 0, "record", "instrument"); err != nil {
+			return err
+		}
+		body, err := method(&s.client, *record, *instrument, *event)
+		if err != nil {
+			return err
+		}
+		return s.write(body)
+	}
+}
+
+func exportSurveyLinks(s *session, args []string) error {
+	instrument := s.flags.String("instrument", "", "the survey instrument")
+	event := s.flags.String("event", "", "the unique event name, for longitudinal projects")
+	records := s.flags.String("records", "", "comma separated record names (default every participant with a record)")
+	concurrency := s.flags.Int("concurrency", 4, "how many records to look up at once")
+	if err := s.parse(args, 0, "instrument"); err != nil {
+		return err
+	}
+	ids := list(*records)
+	if ids == nil {
+		participants, err := s.client.ExportSurveyParticipantList(*instrument, *event)
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		for _, participant := range participants {
+			if participant.Record != "" && !seen[participant.Record] {
+				seen[participant.Record] = true
+				ids = append(ids, participant.Record)
+			}
+		}
+	}
+	links, err := s.client.SurveyLinksReport(ids, *instrument, *event, *concurrency)
+	if err != nil {
+		return err
+	}
+	type row struct {
+		redcap.SurveyLinks
+		Error string `json:",omitempty"`
+	}
+	rows := make([]row, len(links))
+	for i, link := range links {
+		rows[i].SurveyLinks = link
+		if link.Error != nil {
+			rows[i].Error = link.Error.Error()
+		}
+	}
+	return s.writeJSON(rows)
+}
+
+func exportCompletion(s *session, args []string) error {
+	records := s.flags.String("records", "", "comma separated record names (default all)")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	matrix, err := s.client.SurveyCompletion(list(*records))
+	if err != nil {
+		return err
+	}
+	return s.writeTo(matrix.WriteCSV)
+}
+
+func exportRepository(s *session, args []string) error {
+	folder := s.flags.Int("folder", 0, "the folder to list (default the top level)")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	items, err := s.client.ListRepository(*folder)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(items)
+}
+
+func exportRepositoryFile(s *session, args []string) error {
+	doc := s.flags.Int("doc", 0, "the document ID")
+	if err := s.parse(args, 0); err != nil {
+		return err
+	}
+	if *doc == 0 {
+		return usagef("-doc is required")
+	}
+	return s.writeTo(func(w io.Writer) error {
+		_, err := s.client.ExportRepositoryFile(*doc, w)
+		return err
+	})
+}
+
+func exportRepositoryMirror(s *session, args []string) error {
+	dir := s.flags.String("dir", "", "the local directory")
+	folder := s.flags.Int("folder", 0, "the folder to copy (default the whole repository)")
+	if err := s.parse(args, 0, "dir"); err != nil {
+		return err
+	}
+	return s.client.MirrorRepository(*folder, *dir)
+}
+
+func exportStats(s *session, args []string) error {
+	options := recordFlags(s)
+	pkg := s.flags.String("package", "", "r, sas, spss or stata")
+	dir := s.flags.String("dir", "", "the directory to write the CSV and script to")
+	name := s.flags.String("name", "export", "the base name of the files")
+	if err := s.parse(args, 0, "package", "dir"); err != nil {
+		return err
+	}
+	switch statPackage := redcap.StatPackage(*pkg); statPackage {
+	case redcap.R, redcap.SAS, redcap.SPSS, redcap.Stata:
+		return s.client.ExportForStatPackage(*dir, *name, statPackage, *options)
+	}
+	return usagef("unknown package %q", *pkg)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+var importActions = map[string]action{
+	"records":           {usage: "[-overwrite] FILE", summary: "import records from a JSON or CSV file", run: importRecords},
+	"metadata":          {usage: "FILE", summary: "import a data dictionary from a JSON or CSV file", run: importMetadata},
+	"arms":              {usage: "[-override] FILE", summary: "import arms", run: importContent("arm", true)},
+	"events":            {usage: "[-override] FILE", summary: "import events", run: importContent("event", true)},
+	"mappings":          {usage: "FILE", summary: "import instrument-event mappings", run: importContent("formEventMapping", false)},
+	"dags":              {usage: "FILE", summary: "import data access groups", run: importContent("dag", false)},
+	"user-dag-maps":     {usage: "FILE", summary: "import user-DAG assignments", run: importContent("userDagMapping", false)},
+	"users":             {usage: "FILE", summary: "import users", run: importContent("user", false)},
+	"user-roles":        {usage: "FILE", summary: "import user roles", run: importContent("userRole", false)},
+	"file":              {usage: "-record ID -field NAME [-event EVENT] [-instance N] FILE", summary: "upload a file to a file upload field", run: importFile},
+	"repository-file":   {usage: "[-folder ID] FILE", summary: "upload a file to the File Repository", run: importRepositoryFile},
+	"repository-folder": {usage: "-name NAME [-parent ID] [-dag ID] [-role ID]", summary: "create a File Repository folder", run: importRepositoryFolder},
+	"project-settings":  {usage: "FILE", summary: "update project settings from a JSON file", run: importProjectSettings},
+	"project":           {usage: "-title TITLE [-purpose N] [-purpose-other TEXT] [-notes TEXT] [-longitudinal] [-surveys] [-autonumbering] [ODM-FILE]", summary: "create a project with a super API token", run: importProject},
+}
+
+func importRecords(s *session, args []string) error {
+	overwrite := s.flags.Bool("overwrite", false, "let blank values erase existing data")
+	if err := s.parse(args, 1); err != nil {
+		return err
+	}
+	data, format, err := s.input()
+	if err != nil {
+		return err
+	}
+	records, err := readRecords(data, format)
+	if err != nil {
+		return err
+	}
+	body, err := s.client.ImportRecords(records, *overwrite)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+// readRecords parses flat records from a JSON array or a CSV table.
+func readRecords(data []byte, format redcap.ResponseFormat) ([]redcap.Record, error) {
+	switch format {
+	case redcap.JSON:
+		var records []redcap.Record
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("reading records: %w", err)
+		}
+		return records, nil
+	case redcap.CSV:
+		lines, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("reading records: %w", err)
+		}
+		if len(lines) == 0 {
+			return nil, nil
+		}
+		records := make([]redcap.Record, 0, len(lines)-1)
+		for _, line := range lines[1:] {
+			record := make(redcap.Record, len(line))
+			for i, value := range line {
+				record[lines[0][i]] = value
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	}
+	return nil, usagef("records can only be imported from JSON or CSV")
+}
+
+func importMetadata(s *session, args []string) error {
+	if err := s.parse(args, 1); err != nil {
+		return err
+	}
+	data, format, err := s.input()
+	if err != nil {
+		return err
+	}
+	var body []byte
+	switch format {
+	case redcap.JSON:
+		var dictionary redcap.DataDictionary
+		if err := json.Unmarshal(data, &dictionary); err != nil {
+			return fmt.Errorf("reading data dictionary: %w", err)
+		}
+		body, err = s.client.ImportMetadata(dictionary)
+	case redcap.CSV:
+		body, err = s.client.ImportMetadataCSV(bytes.NewReader(data))
+	default:
+		return usagef("metadata can only be imported from JSON or CSV")
+	}
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+// importContent imports rows of a project structure as they are read.
+func importContent(content string, override bool) func(*session, []string) error {
+	return func(s *session, args []string) error {
+		replace := new(bool)
+		if override {
+			replace = s.flags.Bool("override", false, "delete the existing "+content+"s first")
+		}
+		if err := s.parse(args, 1); err != nil {
+			return err
+		}
+		data, format, err := s.input()
+		if err != nil {
+			return err
+		}
+		body, err := s.client.ImportContent(content, format, data, *replace)
+		if err != nil {
+			return err
+		}
+		return s.write(body)
+	}
+}
+
+func importFile(s *session, args []string) error {
+	record, field, event, instance := fileFlags(s)
+	if err := s.parse(args, 1, "record", "field"); err != nil {
+		return err
+	}
+	file, err := os.Open(s.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	body, err := s.client.ImportFile(*record, *field, *event, *instance, filepath.Base(file.Name()), file)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+func importRepositoryFile(s *session, args []string) error {
+	folder := s.flags.Int("folder", 0, "the folder to upload into (default the top level)")
+	if err := s.parse(args, 1); err != nil {
+		return err
+	}
+	file, err := os.Open(s.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return s.client.ImportRepositoryFile(*folder, filepath.Base(file.Name()), file)
+}
+
+func importRepositoryFolder(s *session, args []string) error {
+	name := s.flags.String("name", "", "the folder name")
+	options := redcap.RepositoryFolderOptions{}
+	s.flags.IntVar(&options.ParentID, "parent", 0, "the parent folder (default the top level)")
+	s.flags.IntVar(&options.DagID, "dag", 0, "only let this data access group see the folder")
+	s.flags.IntVar(&options.RoleID, "role", 0, "only let this user role see the folder")
+	if err := s.parse(args, 0, "name"); err != nil {
+		return err
+	}
+	id, err := s.client.CreateRepositoryFolder(*name, options)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(map[string]int{"folder_id": id})
+}
+
+func importProjectSettings(s *session, args []string) error {
+	if err := s.parse(args, 1); err != nil {
+		return err
+	}
+	data, _, err := s.input()
+	if err != nil {
+		return err
+	}
+	original, err := s.client.ExportProjectInfo()
+	if err != nil {
+		return err
+	}
+	updated := original
+	if err := json.Unmarshal(data, &updated); err != nil {
+		return fmt.Errorf("reading project settings: %w", err)
+	}
+	count, err := s.client.ImportProjectSettings(original, updated)
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(map[string]int{"count": count})
+}
+
+func importProject(s *session, args []string) error {
+	project := redcap.NewProject{}
+	s.flags.StringVar(&project.ProjectTitle, "title", "", "the project title")
+	purpose := s.flags.Int("purpose", int(redcap.PurposePractice), "0 practice, 1 other, 2 research, 3 quality improvement, 4 operational support")
+	s.flags.StringVar(&project.PurposeOther, "purpose-other", "", "the other purpose, or the research category codes")
+	s.flags.StringVar(&project.ProjectNotes, "notes", "", "the project notes")
+	s.flags.BoolVar(&project.IsLongitudinal, "longitudinal", false, "make the project longitudinal")
+	s.flags.BoolVar(&project.SurveysEnabled, "surveys", false, "enable surveys")
+	s.flags.BoolVar(&project.RecordAutonumberingEnabled, "autonumbering", false, "number new records automatically")
+	if err := s.parse(args, zeroOrOne, "title"); err != nil {
+		return err
+	}
+	project.Purpose = redcap.ProjectPurpose(*purpose)
+
+	var token string
+	var err error
+	if s.flags.NArg() == 0 {
+		token, err = s.client.ImportProject(project, nil)
+	} else {
+		var file *os.File
+		if file, err = os.Open(s.flags.Arg(0)); err != nil {
+			return err
+		}
+		defer file.Close()
+		token, err = s.client.ImportProject(project, file)
+	}
+	if err != nil {
+		return err
+	}
+	return s.write([]byte(token))
+}
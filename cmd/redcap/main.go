@@ -0,0 +1,253 @@
+// Command redcap exports, imports and deletes REDCap project data from the
+// command line, backs up and restores whole projects and keeps SQLite
+// mirrors of them.
+//
+// Usage:
+//
+//	redcap <command> [target] [flags] [arguments]
+//
+// The API URL, token and response format come from a connection profile
+// chosen with -profile or REDCAP_PROFILE, defined in a JSON config file (see
+// redcap.Config). The REDCAP_URL and REDCAP_TOKEN environment variables and
+// the -url and -token flags override the profile; -token is best avoided as
+// it shows up in shell history and process listings. Results are written to
+// standard output, or to the file named by -out.
+//
+// Exit codes:
+//
+//	0  success
+//	1  the request could not be made, or the result not written
+//	2  the command line is invalid
+//	3  REDCap rejected the request
+//	4  the token is missing rights for the request
+//	5  REDCap failed with a server error
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+const (
+	exitOK        = 0
+	exitFailure   = 1
+	exitUsage     = 2
+	exitRejected  = 3
+	exitForbidden = 4
+	exitServer    = 5
+)
+
+// usageError is a mistake on the command line.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// action is a single command, such as "export records".
+type action struct {
+	usage   string
+	summary string
+	run     func(s *session, args []string) error
+}
+
+// commands holds the actions of each command. Commands with a single action
+// use the empty target.
+var commands = map[string]map[string]action{
+	"export": exportActions,
+	"import": importActions,
+	"delete": deleteActions,
+	"rename": {"": {
+		usage:   "-record NAME -new NAME [-arm N]",
+		summary: "rename a record",
+		run:     renameRecord,
+	}},
+	"backup": {"": {
+		usage:   "-out FILE",
+		summary: "save the whole project to a checksummed zip archive",
+		run:     backupProject,
+	}},
+	"restore": {"": {
+		usage:   "[-verify] ARCHIVE",
+		summary: "create a project from a backup; needs a super API token",
+		run:     restoreBackup,
+	}},
+	"mirror": {"": {
+		usage:   "-db FILE [-full] [-timezone NAME]",
+		summary: "bring a SQLite copy of the project up to date, one table per instrument",
+		run:     mirrorProject,
+	}},
+	"profiles": {"": {
+		summary: "list the connection profiles of the config file",
+		run:     listProfiles,
+	}},
+	"switch-dag": {"": {
+		usage:   "DAG",
+		summary: "move the API user into a data access group",
+		run:     switchDAG,
+	}},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes a command line and returns the exit code.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	actions, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "redcap: unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return exitUsage
+	}
+	name, rest := args[0], args[1:]
+	act, ok := actions[""]
+	if !ok {
+		if len(rest) == 0 {
+			fmt.Fprintf(stderr, "redcap: %s needs a target\n\n", name)
+			printUsage(stderr)
+			return exitUsage
+		}
+		if act, ok = actions[rest[0]]; !ok {
+			fmt.Fprintf(stderr, "redcap: unknown %s target %q\n\n", name, rest[0])
+			printUsage(stderr)
+			return exitUsage
+		}
+		name, rest = name+" "+rest[0], rest[1:]
+	}
+
+	s := newSession(name, act.usage, stdin, stdout, stderr)
+	err := act.run(s, rest)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintf(stderr, "redcap %s: %s\n", name, err)
+	}
+	return exitCode(err)
+}
+
+// exitCode maps an error to the exit code documented for the command.
+func exitCode(err error) int {
+	var usage *usageError
+	var apiErr *redcap.APIError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.As(err, &apiErr):
+		switch {
+		case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+			return exitForbidden
+		case apiErr.StatusCode >= 500:
+			return exitServer
+		}
+		return exitRejected
+	}
+	return exitFailure
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: redcap <command> [target] [flags] [arguments]")
+	fmt.Fprintln(w, "\nEvery command takes -profile, -config, -url, -token, -format (json, csv or xml) and -out.")
+	fmt.Fprintln(w, "Run a command with -h to list its flags.")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "\n%s:\n", name)
+		targets := make([]string, 0, len(commands[name]))
+		for target := range commands[name] {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+		for _, target := range targets {
+			act := commands[name][target]
+			line := name
+			if target != "" {
+				line += " " + target
+			}
+			fmt.Fprintf(w, "  %-32s %s\n", line, act.summary)
+		}
+	}
+	fmt.Fprintln(w, "\nExit codes: 0 ok, 1 failure, 2 usage, 3 rejected by REDCap, 4 forbidden, 5 REDCap server error.")
+}
+
+func renameRecord(s *session, args []string) error {
+	record := s.flags.String("record", "", "the record to rename")
+	newName := s.flags.String("new", "", "the new record name")
+	arm := s.flags.String("arm", "", "the arm to rename the record in")
+	if err := s.parse(args, 0, "record", "new"); err != nil {
+		return err
+	}
+	body, err := s.client.RenameRecord(*record, *arm, *newName)
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
+
+func listProfiles(s *session, args []string) error {
+	if err := s.flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{message: err.Error()}
+	}
+	config, err := s.loadConfig(true)
+	if err != nil {
+		return err
+	}
+	out, err := s.output()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tURL\tFORMAT\tTOKEN")
+	for _, name := range config.Names() {
+		profile := config.Profiles[name]
+		if name == config.Default {
+			name += " (default)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, profile.URL, profile.Format, profile.TokenSource())
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func switchDAG(s *session, args []string) error {
+	if err := s.parse(args, 1); err != nil {
+		return err
+	}
+	body, err := s.client.SwitchDag(s.flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return s.write(body)
+}
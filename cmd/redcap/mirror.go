@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/mirror"
+)
+
+// mirrorProject brings a SQLite mirror of the project up to date, creating
+// it on first use, and writes what the sync changed.
+func mirrorProject(s *session, args []string) error {
+	path := s.flags.String("db", "", "the SQLite database file of the mirror")
+	full := s.flags.Bool("full", false, "reload every record instead of only those changed since the last run")
+	timezone := s.flags.String("timezone", "", "time zone of the REDCap server, such as America/Chicago (default local)")
+	if err := s.parse(args, 0, "db"); err != nil {
+		return err
+	}
+	location := time.Local
+	if *timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(*timezone); err != nil {
+			return usagef("%s", err)
+		}
+	}
+
+	m, err := mirror.OpenProject(*path, &s.client)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+	syncer := m.Syncer(&s.client)
+	syncer.Location = location
+	if *full {
+		if err := syncer.Store.Save(redcap.SyncState{}); err != nil {
+			return err
+		}
+	}
+	result, err := syncer.Sync()
+	if err != nil {
+		return err
+	}
+	return s.writeJSON(result)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Output modes for -output. Raw writes REDCap's response untouched; the
+// others render it as rows.
+const (
+	outputRaw   = "raw"
+	outputTable = "table"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+	outputYAML  = "yaml"
+)
+
+// results is a list of objects rendered as rows. Columns keep the order of
+// the first object's fields, with fields first seen later appended.
+type results struct {
+	columns []string
+	rows    []map[string]interface{}
+}
+
+// newResults reads a JSON array of objects, keeping key order and numbers
+// as they were written.
+func newResults(content []byte) (*results, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("the result is not a list and can only be written with -output raw")
+	}
+
+	r := &results{}
+	seen := make(map[string]bool)
+	for decoder.More() {
+		if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+			return nil, fmt.Errorf("the result is not a list of objects and can only be written with -output raw")
+		}
+		row := make(map[string]interface{})
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			var value interface{}
+			if err := decoder.Decode(&value); err != nil {
+				return nil, err
+			}
+			name := key.(string)
+			row[name] = value
+			if !seen[name] {
+				seen[name] = true
+				r.columns = append(r.columns, name)
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		r.rows = append(r.rows, row)
+	}
+	return r, nil
+}
+
+// resultsOf renders typed values through their JSON form. A single object
+// becomes a single row.
+func resultsOf(v interface{}) (*results, error) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case bytes.Equal(content, []byte("null")):
+		content = []byte("[]")
+	case bytes.HasPrefix(content, []byte("{")):
+		content = append(append([]byte("["), content...), ']')
+	}
+	return newResults(content)
+}
+
+// selectColumns keeps only the named columns, in the order given.
+func (r *results) selectColumns(names []string) error {
+	for _, name := range names {
+		if !contains(r.columns, name) && len(r.rows) > 0 {
+			return usagef("unknown column %q; the columns are %s", name, strings.Join(r.columns, ", "))
+		}
+	}
+	r.columns = names
+	return nil
+}
+
+// sortBy orders the rows by a column, descending when it starts with "-".
+// Numbers compare as numbers; rows missing the column go last.
+func (r *results) sortBy(spec string) error {
+	column, descending := strings.TrimPrefix(spec, "-"), strings.HasPrefix(spec, "-")
+	if !contains(r.columns, column) && len(r.rows) > 0 {
+		return usagef("cannot sort by unknown column %q", column)
+	}
+	sort.SliceStable(r.rows, func(i, j int) bool {
+		a, b := cell(r.rows[i][column]), cell(r.rows[j][column])
+		if a == "" || b == "" {
+			return a != "" && b == ""
+		}
+		if less, ok := compareNumbers(a, b); ok {
+			if descending {
+				return less > 0
+			}
+			return less < 0
+		}
+		if descending {
+			return a > b
+		}
+		return a < b
+	})
+	return nil
+}
+
+func compareNumbers(a string, b string) (int, bool) {
+	x, errX := strconv.ParseFloat(a, 64)
+	y, errY := strconv.ParseFloat(b, 64)
+	if errX != nil || errY != nil {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
+
+// write renders the rows in an output mode.
+func (r *results) write(w io.Writer, mode string) error {
+	switch mode {
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(r.columns, "\t")))
+		for _, row := range r.rows {
+			fmt.Fprintln(tw, strings.Join(r.cells(row), "\t"))
+		}
+		return tw.Flush()
+	case outputCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(r.columns)
+		for _, row := range r.rows {
+			writer.Write(r.cells(row))
+		}
+		writer.Flush()
+		return writer.Error()
+	case outputJSON:
+		fmt.Fprint(w, "[")
+		for i, row := range r.rows {
+			if i > 0 {
+				fmt.Fprint(w, ",")
+			}
+			fmt.Fprint(w, "\n  ")
+			if err := r.writeObject(w, row); err != nil {
+				return err
+			}
+		}
+		if len(r.rows) > 0 {
+			fmt.Fprint(w, "\n")
+		}
+		_, err := fmt.Fprintln(w, "]")
+		return err
+	case outputJSONL:
+		for _, row := range r.rows {
+			if err := r.writeObject(w, row); err != nil {
+				return err
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	case outputYAML:
+		if len(r.rows) == 0 {
+			_, err := fmt.Fprintln(w, "[]")
+			return err
+		}
+		for _, row := range r.rows {
+			for i, column := range r.columns {
+				prefix := "  "
+				if i == 0 {
+					prefix = "- "
+				}
+				fmt.Fprintf(w, "%s%s: %s\n", prefix, yamlString(column), yamlValue(row[column]))
+			}
+		}
+		return nil
+	}
+	return usagef("unknown output %q", mode)
+}
+
+// writeObject writes one row as a JSON object with the selected columns in
+// order.
+func (r *results) writeObject(w io.Writer, row map[string]interface{}) error {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, column := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(row[column])
+		if err != nil {
+			return err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func (r *results) cells(row map[string]interface{}) []string {
+	cells := make([]string, len(r.columns))
+	for i, column := range r.columns {
+		cells[i] = cell(row[column])
+	}
+	return cells
+}
+
+// cell formats a value for a table or CSV cell. Nested values are written
+// as JSON.
+func cell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	content, _ := json.Marshal(value)
+	return string(content)
+}
+
+// plainYAML matches strings YAML reads back unchanged without quotes.
+var plainYAML = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_ ./@()-]*$`)
+
+func yamlString(s string) string {
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "y", "n":
+		content, _ := json.Marshal(s)
+		return string(content)
+	}
+	if plainYAML.MatchString(s) && !strings.HasSuffix(s, " ") {
+		return s
+	}
+	content, _ := json.Marshal(s)
+	return string(content)
+}
+
+// yamlValue writes a scalar in YAML; lists and objects use flow style, for
+// which JSON is valid YAML.
+func yamlValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	content, _ := json.Marshal(value)
+	return string(content)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
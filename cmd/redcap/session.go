@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// session is the state of one command: its flags, the client built from
+// them and where input and output go.
+type session struct {
+	name    string
+	flags   *flag.FlagSet
+	config  *string
+	profile *string
+	url     *string
+	token   *string
+	format  *string
+	out     *string
+	mode    *string
+	columns *string
+	sort    *string
+
+	client redcap.RedCapClient
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// newSession defines the flags every command shares.
+func newSession(name string, usage string, stdin io.Reader, stdout io.Writer, stderr io.Writer) *session {
+	s := &session{name: name, flags: flag.NewFlagSet(name, flag.ContinueOnError), stdin: stdin, stdout: stdout, stderr: stderr}
+	s.flags.SetOutput(stderr)
+	s.flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: redcap %s %s\n", name, usage)
+		s.flags.PrintDefaults()
+	}
+	s.config = s.flags.String("config", "", "config file with connection profiles (default $REDCAP_CONFIG or the user config directory)")
+	s.profile = s.flags.String("profile", "", "connection profile to use (default $REDCAP_PROFILE or the config's default)")
+	s.url = s.flags.String("url", "", "URL of the REDCap API (default $REDCAP_URL or the profile's)")
+	s.token = s.flags.String("token", "", "API token; visible to other users, prefer a profile (default $REDCAP_TOKEN or the profile's)")
+	s.format = s.flags.String("format", "", "response format: json, csv or xml (default the profile's or json)")
+	s.out = s.flags.String("out", "", "write the result to this file instead of standard output")
+	s.mode = s.flags.String("output", "", "how to write results: raw, table, json, jsonl, csv or yaml (default raw, or table with -columns or -sort)")
+	s.columns = s.flags.String("columns", "", "comma separated columns to write, in order")
+	s.sort = s.flags.String("sort", "", "column to sort rows by; prefix with - for descending")
+	return s
+}
+
+// Argument counts for parse besides an exact number.
+const (
+	oneOrMore = -1
+	zeroOrOne = -2
+)
+
+// parse reads the command line, checks that the required flags are set and
+// that nargs arguments follow them, and builds the client.
+func (s *session) parse(args []string, nargs int, required ...string) error {
+	if err := s.parseFlags(args, nargs, required...); err != nil {
+		return err
+	}
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	s.client = client
+	return nil
+}
+
+// parseFlags reads and checks the command line like parse without building
+// the client, for commands that only sometimes talk to REDCap.
+func (s *session) parseFlags(args []string, nargs int, required ...string) error {
+	if err := s.flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return &usageError{message: err.Error()}
+	}
+	for _, name := range required {
+		if s.flags.Lookup(name).Value.String() == "" {
+			return usagef("-%s is required", name)
+		}
+	}
+	switch {
+	case nargs == oneOrMore && s.flags.NArg() == 0:
+		return usagef("expected at least one argument")
+	case nargs == zeroOrOne && s.flags.NArg() > 1:
+		return usagef("expected at most one argument, got %d", s.flags.NArg())
+	case nargs >= 0 && s.flags.NArg() != nargs:
+		return usagef("expected %d argument(s), got %d", nargs, s.flags.NArg())
+	}
+
+	if *s.mode == "" && (*s.columns != "" || *s.sort != "") {
+		*s.mode = outputTable
+	}
+	switch *s.mode {
+	case "", outputRaw:
+	case outputTable, outputJSON, outputJSONL, outputCSV, outputYAML:
+		if *s.format != "" && *s.format != string(redcap.JSON) {
+			return usagef("-output %s reads REDCap's JSON and cannot be combined with -format %s", *s.mode, *s.format)
+		}
+		*s.format = string(redcap.JSON)
+	default:
+		return usagef("unknown output %q", *s.mode)
+	}
+	return nil
+}
+
+// rendering reports whether results are rendered as rows rather than
+// written as REDCap sent them.
+func (s *session) rendering() bool {
+	return *s.mode != "" && *s.mode != outputRaw
+}
+
+// connect builds the client. The URL, token and format each come from
+// their flag, then the environment, then the connection profile.
+func (s *session) connect() (redcap.RedCapClient, error) {
+	client := redcap.RedCapClient{URL: *s.url, Token: *s.token, ResponseFormat: redcap.ResponseFormat(*s.format)}
+	if client.Token != "" {
+		fmt.Fprintln(s.stderr, "redcap: warning: -token is visible in process listings and shell history; use a profile or REDCAP_TOKEN")
+	}
+	if client.URL == "" {
+		client.URL = os.Getenv("REDCAP_URL")
+	}
+	if client.Token == "" {
+		client.Token = os.Getenv("REDCAP_TOKEN")
+	}
+
+	name := *s.profile
+	if name == "" {
+		name = os.Getenv("REDCAP_PROFILE")
+	}
+	if name != "" || client.URL == "" || client.Token == "" {
+		config, err := s.loadConfig(name != "")
+		if err != nil {
+			return client, err
+		}
+		if config != nil && (name != "" || config.Default != "") {
+			profile, err := config.Profile(name)
+			if err != nil {
+				return client, usagef("%s", err)
+			}
+			if client.URL == "" {
+				client.URL = profile.URL
+			}
+			if client.Token == "" {
+				if client.Token, err = profile.Token(); err != nil {
+					return client, err
+				}
+			}
+			if client.ResponseFormat == "" {
+				client.ResponseFormat = profile.Format
+			}
+		}
+	}
+
+	if client.URL == "" {
+		return client, usagef("no API URL: set -profile, -url or REDCAP_URL")
+	}
+	if client.Token == "" {
+		return client, usagef("no API token: set -profile or REDCAP_TOKEN")
+	}
+	switch client.ResponseFormat {
+	case "":
+		client.ResponseFormat = redcap.JSON
+	case redcap.JSON, redcap.CSV, redcap.XML:
+	default:
+		return client, usagef("unknown format %q", client.ResponseFormat)
+	}
+	return client, nil
+}
+
+// loadConfig reads the config file. A missing default config file is not
+// an error unless a profile is needed from it.
+func (s *session) loadConfig(required bool) (*redcap.Config, error) {
+	config, err := redcap.LoadConfig(*s.config)
+	if errors.Is(err, fs.ErrNotExist) && *s.config == "" && !required {
+		return nil, nil
+	}
+	return config, err
+}
+
+// output opens where the result goes. The caller closes it.
+func (s *session) output() (io.WriteCloser, error) {
+	if *s.out == "" {
+		return nopCloser{s.stdout}, nil
+	}
+	return os.Create(*s.out)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// write sends a raw REDCap response to the output, ending it with a newline
+// on a terminal-bound text response. With -output the response is rendered.
+func (s *session) write(body []byte) error {
+	if s.rendering() {
+		r, err := newResults(body)
+		if err != nil {
+			return err
+		}
+		return s.render(r)
+	}
+	out, err := s.output()
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(body); err != nil {
+		out.Close()
+		return err
+	}
+	if *s.out == "" && len(body) > 0 && body[len(body)-1] != '\n' && isText(body) {
+		fmt.Fprintln(out)
+	}
+	return out.Close()
+}
+
+// writeJSON sends a typed result to the output as indented JSON, or
+// rendered with -output.
+func (s *session) writeJSON(v interface{}) error {
+	if s.rendering() {
+		r, err := resultsOf(v)
+		if err != nil {
+			return err
+		}
+		return s.render(r)
+	}
+	out, err := s.output()
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// render selects and sorts the rows as asked and writes them in the
+// output mode.
+func (s *session) render(r *results) error {
+	if *s.columns != "" {
+		if err := r.selectColumns(list(*s.columns)); err != nil {
+			return err
+		}
+	}
+	if *s.sort != "" {
+		if err := r.sortBy(*s.sort); err != nil {
+			return err
+		}
+	}
+	out, err := s.output()
+	if err != nil {
+		return err
+	}
+	if err := r.write(out, *s.mode); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeTo streams a result such as a PDF to the output.
+func (s *session) writeTo(export func(io.Writer) error) error {
+	out, err := s.output()
+	if err != nil {
+		return err
+	}
+	if err := export(out); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// input reads the file named by the first argument, or standard input for
+// "-", and returns its content with the format its extension suggests.
+func (s *session) input() ([]byte, redcap.ResponseFormat, error) {
+	path := s.flags.Arg(0)
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(s.stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	format := s.client.ResponseFormat
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		format = redcap.CSV
+	case ".json":
+		format = redcap.JSON
+	case ".xml":
+		format = redcap.XML
+	}
+	return data, format, nil
+}
+
+// isText reports whether a response looks like text rather than a file.
+func isText(body []byte) bool {
+	for _, b := range body {
+		if b == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// list splits a comma separated flag value.
+func list(value string) []string {
+	if value == "" {
+		return nil
+	}
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		items[i] = strings.TrimSpace(item)
+	}
+	return items
+}
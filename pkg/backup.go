@@ -0,0 +1,622 @@
+package redcap
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// BackupFormatVersion is the version of the archive layout written by
+// Backup. OpenBackup refuses archives written by a later version.
+const BackupFormatVersion = 1
+
+// backupManifestName is the archive entry holding the manifest. Every other
+// entry is listed in it with its checksum.
+const backupManifestName = "manifest.json"
+
+// backupContents lists the parts of the project structure saved as JSON, by
+// archive entry and REDCap content name. Arms, events and their mappings
+// only exist in longitudinal projects.
+var backupContents = []struct {
+	name         string
+	content      string
+	longitudinal bool
+}{
+	{"metadata.json", "metadata", false},
+	{"arms.json", "arm", true},
+	{"events.json", "event", true},
+	{"form_event_mapping.json", "formEventMapping", true},
+	{"dags.json", "dag", false},
+	{"user_dag_mapping.json", "userDagMapping", false},
+	{"users.json", "user", false},
+	{"user_roles.json", "userRole", false},
+	{"user_role_mapping.json", "userRoleMapping", false},
+}
+
+// BackupManifest describes a backup archive and every file in it.
+type BackupManifest struct {
+	FormatVersion int          `json:"format_version"`
+	CreatedAt     string       `json:"created_at"`
+	REDCapVersion string       `json:"redcap_version"`
+	ProjectID     int          `json:"project_id"`
+	ProjectTitle  string       `json:"project_title"`
+	Files         []BackupFile `json:"files"`
+}
+
+// BackupFile is an entry of a backup archive with its size and SHA-256
+// checksum.
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupUpload is a file uploaded to a file upload field, saved in the
+// archive entry named by Entry.
+type BackupUpload struct {
+	Record         string `json:"record"`
+	Field          string `json:"field"`
+	Event          string `json:"event,omitempty"`
+	RepeatInstance int    `json:"repeat_instance,omitempty"`
+	Name           string `json:"name"`
+	Entry          string `json:"entry"`
+}
+
+// BackupRepositoryItem is a File Repository folder or file. Path separates
+// folders with "/"; files are saved in the archive entry named by Entry.
+type BackupRepositoryItem struct {
+	Path   string `json:"path"`
+	Folder bool   `json:"folder,omitempty"`
+	Entry  string `json:"entry,omitempty"`
+}
+
+// backupWriter adds entries to an archive and lists them in the manifest.
+type backupWriter struct {
+	zip      *zip.Writer
+	manifest *BackupManifest
+	modified time.Time
+}
+
+func (b *backupWriter) add(name string, content []byte) error {
+	w, err := b.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: b.modified})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(content); err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	sum := sha256.Sum256(content)
+	b.manifest.Files = append(b.manifest.Files, BackupFile{Name: name, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])})
+	return nil
+}
+
+func (b *backupWriter) addJSON(name string, v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return b.add(name, content)
+}
+
+/*
+	Backup writes the whole project to a zip archive: the project settings,
+	an ODM document with the metadata, repeating setup and records, the
+	arms, events, mappings, data access groups, users and roles as JSON,
+	every uploaded file and the File Repository. A manifest lists each entry
+	with its SHA-256 checksum. Survey settings other than those in the ODM
+	document, alerts and reports cannot be exported by the API and are not
+	included.
+	
+	Args:
+		w: The writer the archive is written to.
+	
+	Returns:
+		The manifest of the archive.
+*/
+func (r *RedCapClient) Backup(w io.Writer) (*BackupManifest, error) {
+	info, err := r.ExportProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+	version, err := r.ExportRedcapVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	manifest := &BackupManifest{
+		FormatVersion: BackupFormatVersion,
+		CreatedAt:     now.Format(time.RFC3339),
+		REDCapVersion: strings.TrimSpace(string(version)),
+		ProjectID:     info.ProjectID,
+		ProjectTitle:  info.ProjectTitle,
+	}
+	archive := &backupWriter{zip: zip.NewWriter(w), manifest: manifest, modified: now}
+
+	project, err := r.ExportContent("project", JSON)
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.add("project.json", project); err != nil {
+		return nil, err
+	}
+
+	var odm bytes.Buffer
+	if _, err := r.ExportProjectXML(&odm, ProjectXMLOptions{ExportSurveyFields: true, ExportDataAccessGroups: true}); err != nil {
+		return nil, err
+	}
+	if err := archive.add("project.xml", odm.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var dictionary DataDictionary
+	for _, c := range backupContents {
+		if c.longitudinal && !bool(info.IsLongitudinal) {
+			continue
+		}
+		content, err := r.ExportContent(c.content, JSON)
+		if err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", c.content, err)
+		}
+		if c.content == "metadata" {
+			if dictionary, err = ParseMetadata(content); err != nil {
+				return nil, err
+			}
+		}
+		if err := archive.add(c.name, content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.backupRecordDAGs(archive, dictionary); err != nil {
+		return nil, err
+	}
+	if err := r.backupUploads(archive, dictionary); err != nil {
+		return nil, err
+	}
+	var items []BackupRepositoryItem
+	if err := r.backupRepository(archive, 0, "", &items); err != nil {
+		return nil, err
+	}
+	if err := archive.addJSON("repository.json", items); err != nil {
+		return nil, err
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	entry, err := archive.zip.CreateHeader(&zip.FileHeader{Name: backupManifestName, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := entry.Write(content); err != nil {
+		return nil, err
+	}
+	if err := archive.zip.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// backupRecordDAGs saves the data access group of each record, which
+// restore applies once the groups exist in the new project.
+func (r *RedCapClient) backupRecordDAGs(archive *backupWriter, dictionary DataDictionary) error {
+	recordID := dictionary.RecordIDField()
+	formating := url.Values{
+		"token":                  {r.Token},
+		"content":                {"record"},
+		"format":                 {"csv"},
+		"type":                   {"flat"},
+		"exportDataAccessGroups": {"true"},
+		"returnFormat":           {"json"},
+	}
+	setArray(formating, "fields", []string{recordID})
+
+	_, rows, err := r.exportRecordsCSV(formating)
+	if err != nil {
+		return err
+	}
+	records := []Record{}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if row[DataAccessGroupColumn] == "" || row[RepeatInstrumentColumn] != "" || seen[row[recordID]] {
+			continue
+		}
+		seen[row[recordID]] = true
+		record := Record{recordID: row[recordID], DataAccessGroupColumn: row[DataAccessGroupColumn]}
+		if event := row[EventNameColumn]; event != "" {
+			record[EventNameColumn] = event
+		}
+		records = append(records, record)
+	}
+	return archive.addJSON("record_dags.json", records)
+}
+
+// backupUploads saves every file uploaded to a file upload field.
+func (r *RedCapClient) backupUploads(archive *backupWriter, dictionary DataDictionary) error {
+	files, err := r.recordFiles(dictionary, nil)
+	if err != nil {
+		return err
+	}
+	uploads := []BackupUpload{}
+	for i, file := range files {
+		content, err := r.ExportFile(file.Record, file.Field, file.Event, file.RepeatInstance)
+		if err != nil {
+			return fmt.Errorf("exporting the %s file of record %s: %w", file.Field, file.Record, err)
+		}
+		upload := BackupUpload{
+			Record:         file.Record,
+			Field:          file.Field,
+			Event:          file.Event,
+			RepeatInstance: file.RepeatInstance,
+			Name:           file.Value,
+			Entry:          fmt.Sprintf("files/%d", i+1),
+		}
+		if err := archive.add(upload.Entry, content); err != nil {
+			return err
+		}
+		uploads = append(uploads, upload)
+	}
+	return archive.addJSON("files.json", uploads)
+}
+
+// backupRepository saves a File Repository folder and everything below it.
+func (r *RedCapClient) backupRepository(archive *backupWriter, folderID int, parent string, items *[]BackupRepositoryItem) error {
+	listing, err := r.ListRepository(folderID)
+	if err != nil {
+		return err
+	}
+	for _, listed := range listing {
+		item := BackupRepositoryItem{Path: path.Join(parent, listed.Name)}
+		if listed.IsFolder() {
+			item.Folder = true
+			*items = append(*items, item)
+			if err := r.backupRepository(archive, listed.FolderID, item.Path, items); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var content bytes.Buffer
+		if _, err := r.ExportRepositoryFile(listed.DocID, &content); err != nil {
+			return fmt.Errorf("exporting repository file %s: %w", item.Path, err)
+		}
+		item.Entry = fmt.Sprintf("repository/%d", len(*items)+1)
+		if err := archive.add(item.Entry, content.Bytes()); err != nil {
+			return err
+		}
+		*items = append(*items, item)
+	}
+	return nil
+}
+
+// BackupArchive is a backup written by Backup, opened for restoring.
+type BackupArchive struct {
+	Manifest BackupManifest
+	entries  map[string]*zip.File
+}
+
+/*
+	OpenBackup opens a backup archive and verifies it against its manifest:
+	every listed entry must be present with the recorded size and SHA-256
+	checksum, and no other entries may be present.
+	
+	Args:
+		reader: The archive.
+		size: The size of the archive in bytes.
+	
+	Returns:
+		The verified archive.
+*/
+func OpenBackup(reader io.ReaderAt, size int64) (*BackupArchive, error) {
+	archive, err := zip.NewReader(reader, size)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup: %w", err)
+	}
+	b := &BackupArchive{entries: make(map[string]*zip.File)}
+	for _, entry := range archive.File {
+		b.entries[entry.Name] = entry
+	}
+
+	content, err := b.read(backupManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("not a REDCap backup: %w", err)
+	}
+	if err := json.Unmarshal(content, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("reading backup manifest: %w", err)
+	}
+	if b.Manifest.FormatVersion < 1 || b.Manifest.FormatVersion > BackupFormatVersion {
+		return nil, fmt.Errorf("backup format version %d is not supported; this version reads up to %d", b.Manifest.FormatVersion, BackupFormatVersion)
+	}
+
+	listed := map[string]bool{backupManifestName: true}
+	for _, file := range b.Manifest.Files {
+		listed[file.Name] = true
+		content, err := b.read(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(content)
+		if int64(len(content)) != file.Size || hex.EncodeToString(sum[:]) != file.SHA256 {
+			return nil, fmt.Errorf("backup entry %s does not match its checksum", file.Name)
+		}
+	}
+	for name := range b.entries {
+		if !listed[name] {
+			return nil, fmt.Errorf("backup entry %s is not listed in the manifest", name)
+		}
+	}
+	return b, nil
+}
+
+func (b *BackupArchive) read(name string) ([]byte, error) {
+	entry, ok := b.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%s is missing from the backup", name)
+	}
+	file, err := entry.Open()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	return content, nil
+}
+
+// readJSON decodes an entry. Entries missing from the backup, such as the
+// arms of a classic project, leave v unchanged.
+func (b *BackupArchive) readJSON(name string, v interface{}) error {
+	if _, ok := b.entries[name]; !ok {
+		return nil
+	}
+	content, err := b.read(name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
+
+/*
+	Restore creates a new project from the backup with ImportProject and
+	replays the project settings, data access groups, user roles, users,
+	record groups, uploaded files and File Repository into it. The client
+	must be using a super API token.
+	
+	Args:
+		super: A client with a super API token on the REDCap to restore to.
+	
+	Returns:
+		The API token of the new project. It is also returned when restoring
+		fails after the project was created, so it can be inspected or
+		deleted.
+*/
+func (b *BackupArchive) Restore(super *RedCapClient) (string, error) {
+	var info ProjectInfo
+	if err := b.readJSON("project.json", &info); err != nil {
+		return "", err
+	}
+	odm, err := b.read("project.xml")
+	if err != nil {
+		return "", err
+	}
+
+	token, err := super.ImportProject(NewProject{
+		ProjectTitle:               info.ProjectTitle,
+		Purpose:                    info.Purpose,
+		PurposeOther:               info.PurposeOther,
+		ProjectNotes:               info.ProjectNotes,
+		IsLongitudinal:             bool(info.IsLongitudinal),
+		SurveysEnabled:             bool(info.SurveysEnabled),
+		RecordAutonumberingEnabled: bool(info.RecordAutonumberingEnabled),
+	}, bytes.NewReader(odm))
+	if err != nil {
+		return "", err
+	}
+
+	project := *super
+	project.Token = token
+	project.ResponseFormat = JSON
+	if err := b.restoreTo(&project, info); err != nil {
+		return token, fmt.Errorf("restoring into the new project: %w", err)
+	}
+	return token, nil
+}
+
+func (b *BackupArchive) restoreTo(project *RedCapClient, info ProjectInfo) error {
+	current, err := project.ExportProjectInfo()
+	if err != nil {
+		return err
+	}
+	if _, err := project.ImportProjectSettings(current, info); err != nil {
+		return err
+	}
+
+	dags, err := b.restoreRows(project, "dags.json", "dag", "unique_group_name", "data_access_group_name", "data_access_group_id")
+	if err != nil {
+		return err
+	}
+	roles, err := b.restoreRows(project, "user_roles.json", "userRole", "unique_role_name", "role_label")
+	if err != nil {
+		return err
+	}
+
+	for _, step := range []struct {
+		name    string
+		content string
+		column  string
+		renamed map[string]string
+	}{
+		{"users.json", "user", "data_access_group", dags},
+		{"user_role_mapping.json", "userRoleMapping", "unique_role_name", roles},
+		{"user_dag_mapping.json", "userDagMapping", "redcap_data_access_group", dags},
+	} {
+		var rows []map[string]interface{}
+		if err := b.readJSON(step.name, &rows); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		for _, row := range rows {
+			if name, ok := row[step.column].(string); ok && step.renamed[name] != "" {
+				row[step.column] = step.renamed[name]
+			}
+		}
+		payload, err := json.Marshal(rows)
+		if err != nil {
+			return err
+		}
+		if _, err := project.ImportContent(step.content, JSON, payload, false); err != nil {
+			return fmt.Errorf("importing %s: %w", step.content, err)
+		}
+	}
+
+	var records []Record
+	if err := b.readJSON("record_dags.json", &records); err != nil {
+		return err
+	}
+	if len(records) > 0 {
+		for _, record := range records {
+			if name := dags[record[DataAccessGroupColumn]]; name != "" {
+				record[DataAccessGroupColumn] = name
+			}
+		}
+		if _, err := project.ImportRecords(records, false); err != nil {
+			return fmt.Errorf("assigning records to data access groups: %w", err)
+		}
+	}
+
+	if err := b.restoreUploads(project); err != nil {
+		return err
+	}
+	return b.restoreRepository(project)
+}
+
+// restoreRows imports the rows of an entry the new project does not have
+// yet, matched by label, leaving REDCap to name them. It returns the new
+// unique name of each backed up row by its old one.
+func (b *BackupArchive) restoreRows(project *RedCapClient, name string, content string, key string, label string, drop ...string) (map[string]string, error) {
+	renamed := make(map[string]string)
+	var rows []map[string]interface{}
+	if err := b.readJSON(name, &rows); err != nil || len(rows) == 0 {
+		return renamed, err
+	}
+
+	existing, err := exportRowsByLabel(project, content, key, label)
+	if err != nil {
+		return nil, err
+	}
+	var missing []map[string]interface{}
+	for _, row := range rows {
+		if _, ok := existing[fmt.Sprint(row[label])]; ok {
+			continue
+		}
+		added := make(map[string]interface{}, len(row))
+		for column, value := range row {
+			added[column] = value
+		}
+		added[key] = ""
+		for _, column := range drop {
+			delete(added, column)
+		}
+		missing = append(missing, added)
+	}
+	if len(missing) > 0 {
+		payload, err := json.Marshal(missing)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := project.ImportContent(content, JSON, payload, false); err != nil {
+			return nil, fmt.Errorf("importing %s: %w", content, err)
+		}
+		if existing, err = exportRowsByLabel(project, content, key, label); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, row := range rows {
+		renamed[fmt.Sprint(row[key])] = existing[fmt.Sprint(row[label])]
+	}
+	return renamed, nil
+}
+
+// exportRowsByLabel maps the label of each exported row to its unique name.
+func exportRowsByLabel(project *RedCapClient, content string, key string, label string) (map[string]string, error) {
+	body, err := project.ExportContent(content, JSON)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", content, err)
+	}
+	names := make(map[string]string, len(rows))
+	for _, row := range rows {
+		names[fmt.Sprint(row[label])] = fmt.Sprint(row[key])
+	}
+	return names, nil
+}
+
+func (b *BackupArchive) restoreUploads(project *RedCapClient) error {
+	var uploads []BackupUpload
+	if err := b.readJSON("files.json", &uploads); err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		content, err := b.read(upload.Entry)
+		if err != nil {
+			return err
+		}
+		if _, err := project.ImportFile(upload.Record, upload.Field, upload.Event, upload.RepeatInstance, upload.Name, bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("importing the %s file of record %s: %w", upload.Field, upload.Record, err)
+		}
+	}
+	return nil
+}
+
+func (b *BackupArchive) restoreRepository(project *RedCapClient) error {
+	var items []BackupRepositoryItem
+	if err := b.readJSON("repository.json", &items); err != nil {
+		return err
+	}
+	folders := map[string]int{".": 0}
+	for _, item := range items {
+		parent, ok := folders[path.Dir(item.Path)]
+		if !ok {
+			return fmt.Errorf("repository folder of %s is missing from the backup", item.Path)
+		}
+		if item.Folder {
+			id, err := project.CreateRepositoryFolder(path.Base(item.Path), RepositoryFolderOptions{ParentID: parent})
+			if err != nil {
+				return fmt.Errorf("creating repository folder %s: %w", item.Path, err)
+			}
+			folders[item.Path] = id
+			continue
+		}
+
+		content, err := b.read(item.Entry)
+		if err != nil {
+			return err
+		}
+		if err := project.ImportRepositoryFile(parent, path.Base(item.Path), bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("importing repository file %s: %w", item.Path, err)
+		}
+	}
+	return nil
+}
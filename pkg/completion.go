@@ -0,0 +1,245 @@
+package redcap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+type FormStatus string
+
+const (
+	StatusNotStarted FormStatus = "not_started"
+	StatusIncomplete FormStatus = "incomplete"
+	StatusUnverified FormStatus = "unverified"
+	StatusComplete   FormStatus = "complete"
+)
+
+// surveyNotCompleted is what REDCap exports as the survey timestamp of a
+// response that was started but not submitted.
+const surveyNotCompleted = "[not completed]"
+
+// InstrumentCompletion is the state of one instrument of one record in one
+// event and, for repeating instruments and events, one instance.
+type InstrumentCompletion struct {
+	Record          string
+	Event           string
+	Instrument      string
+	RepeatInstance  int
+	Status          FormStatus
+	IsSurvey        bool
+	SurveyTimestamp string
+	Partial         bool
+}
+
+// CompletionMatrix is the completion state of every record, event and
+// instrument of a project. Events is empty for classic projects.
+type CompletionMatrix struct {
+	Records     []string
+	Events      []string
+	Instruments []string
+	Cells       []InstrumentCompletion
+}
+
+/*
+	Lookup returns the cells of a record, event and instrument, one per
+	repeating instance.
+	
+	Args:
+		record: The record ID.
+		event: The unique event name, or "" for classic projects.
+		instrument: The instrument name.
+	
+	Returns:
+		The matching cells.
+*/
+func (m *CompletionMatrix) Lookup(record string, event string, instrument string) []InstrumentCompletion {
+	var cells []InstrumentCompletion
+	for _, cell := range m.Cells {
+		if cell.Record == record && cell.Event == event && cell.Instrument == instrument {
+			cells = append(cells, cell)
+		}
+	}
+	return cells
+}
+
+/*
+	WriteCSV writes the matrix as CSV, one row per cell.
+	
+	Args:
+		w: The writer the CSV is written to.
+	
+	Returns:
+		An error if writing failed.
+*/
+func (m *CompletionMatrix) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"record", "redcap_event_name", "instrument", "redcap_repeat_instance", "status", "is_survey", "survey_timestamp", "partial"})
+	for _, cell := range m.Cells {
+		instance := ""
+		if cell.RepeatInstance > 0 {
+			instance = strconv.Itoa(cell.RepeatInstance)
+		}
+		writer.Write([]string{
+			cell.Record,
+			cell.Event,
+			cell.Instrument,
+			instance,
+			string(cell.Status),
+			strconv.FormatBool(cell.IsSurvey),
+			cell.SurveyTimestamp,
+			strconv.FormatBool(cell.Partial),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formStatus(value string) FormStatus {
+	switch value {
+	case "0":
+		return StatusIncomplete
+	case "1":
+		return StatusUnverified
+	case "2":
+		return StatusComplete
+	}
+	return StatusNotStarted
+}
+
+/*
+	SurveyCompletion builds the completion matrix of a project from the
+	instrument status fields and survey timestamps of the records, the
+	instrument-event mappings and the survey participant lists.
+	
+	Args:
+		records: The records to include, or none for every record.
+	
+	Returns:
+		The completion matrix.
+*/
+func (r *RedCapClient) SurveyCompletion(records []string) (*CompletionMatrix, error) {
+	dictionary, err := r.ExportDataDictionary()
+	if err != nil {
+		return nil, err
+	}
+	if len(dictionary) == 0 {
+		return nil, fmt.Errorf("project has no fields")
+	}
+	info, err := r.ExportProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	matrix := &CompletionMatrix{}
+	seenForms := make(map[string]bool)
+	for _, field := range dictionary {
+		if !seenForms[field.FormName] {
+			seenForms[field.FormName] = true
+			matrix.Instruments = append(matrix.Instruments, field.FormName)
+		}
+	}
+
+	eventForms := map[string][]string{"": matrix.Instruments}
+	if info.IsLongitudinal {
+		mappings, err := r.ExportFormEventMappings()
+		if err != nil {
+			return nil, err
+		}
+		matrix.Events, eventForms = EventForms(mappings)
+	}
+
+	header, rows, err := r.ExportRecordRows(RecordsOptions{Records: records, ExportSurveyFields: true})
+	if err != nil {
+		return nil, err
+	}
+	recordField := dictionary[0].FieldName
+	if len(header) > 0 {
+		recordField = header[0]
+	}
+
+	surveys := make(map[string]bool)
+	for _, column := range header {
+		for _, instrument := range matrix.Instruments {
+			if column == instrument+"_timestamp" {
+				surveys[instrument] = true
+			}
+		}
+	}
+
+	// Forms that repeat within an event get their own rows, so the
+	// non-repeating row of that event only stands in for them when the
+	// record has no instances yet.
+	instances := make(map[[3]string]bool)
+	for _, row := range rows {
+		if instrument := row["redcap_repeat_instrument"]; instrument != "" {
+			instances[[3]string{row[recordField], row["redcap_event_name"], instrument}] = true
+		}
+	}
+
+	seenRecords := make(map[string]bool)
+	for _, row := range rows {
+		record, event := row[recordField], row["redcap_event_name"]
+		if !seenRecords[record] {
+			seenRecords[record] = true
+			matrix.Records = append(matrix.Records, record)
+		}
+		instance, _ := strconv.Atoi(row["redcap_repeat_instance"])
+
+		forms := eventForms[event]
+		if repeatInstrument := row["redcap_repeat_instrument"]; repeatInstrument != "" {
+			forms = []string{repeatInstrument}
+		}
+		for _, instrument := range forms {
+			if row["redcap_repeat_instrument"] == "" && instances[[3]string{record, event, instrument}] {
+				continue
+			}
+			cell := InstrumentCompletion{
+				Record:         record,
+				Event:          event,
+				Instrument:     instrument,
+				RepeatInstance: instance,
+				Status:         formStatus(row[instrument+"_complete"]),
+				IsSurvey:       surveys[instrument],
+			}
+			if cell.IsSurvey {
+				cell.SurveyTimestamp = row[instrument+"_timestamp"]
+				cell.Partial = cell.SurveyTimestamp == surveyNotCompleted
+				if cell.Partial {
+					cell.SurveyTimestamp = ""
+				}
+			}
+			matrix.Cells = append(matrix.Cells, cell)
+		}
+	}
+
+	for instrument := range surveys {
+		events := matrix.Events
+		if !info.IsLongitudinal {
+			events = []string{""}
+		}
+		for _, event := range events {
+			if !slices.Contains(eventForms[event], instrument) {
+				continue
+			}
+			participants, err := r.ExportSurveyParticipantList(instrument, event)
+			if err != nil {
+				return nil, err
+			}
+			for _, participant := range participants {
+				if participant.Record == "" || participant.ResponseStatus != ResponsePartial {
+					continue
+				}
+				for i, cell := range matrix.Cells {
+					if cell.Record == participant.Record && cell.Event == event && cell.Instrument == instrument && cell.Status != StatusComplete {
+						matrix.Cells[i].Partial = true
+					}
+				}
+			}
+		}
+	}
+
+	return matrix, nil
+}
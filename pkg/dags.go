@@ -0,0 +1,30 @@
+package redcap
+
+import (
+	"encoding/json"
+)
+
+// DataAccessGroup is a data access group of a project.
+type DataAccessGroup struct {
+	Name            string      `json:"data_access_group_name"`
+	UniqueGroupName string      `json:"unique_group_name"`
+	ID              json.Number `json:"data_access_group_id"`
+}
+
+/*
+	ExportDataAccessGroups exports the data access groups of a project as
+	typed values.
+	
+	Args:
+		None
+	
+	Returns:
+		The data access groups of the project.
+*/
+func (r *RedCapClient) ExportDataAccessGroups() ([]DataAccessGroup, error) {
+	var groups []DataAccessGroup
+	if err := r.exportJSON("dag", nil, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
@@ -0,0 +1,143 @@
+package redcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// FormEventMapping designates an instrument to an event of a longitudinal project.
+type FormEventMapping struct {
+	ArmNum          json.Number `json:"arm_num"`
+	UniqueEventName string      `json:"unique_event_name"`
+	Form            string      `json:"form"`
+}
+
+/*
+	ExportFormEventMappings exports the instrument-event mappings of a
+	longitudinal project as typed values.
+	
+	Args:
+		arms: The arm numbers to export, or none for every arm.
+	
+	Returns:
+		The instruments designated to each event.
+*/
+func (r *RedCapClient) ExportFormEventMappings(arms ...string) ([]FormEventMapping, error) {
+	var mappings []FormEventMapping
+	if err := r.exportJSON("formEventMapping", arms, &mappings); err != nil {
+		return nil, err
+	}
+	return mappings, nil
+}
+
+// exportJSON exports the given content of the chosen arms, or every arm when
+// none are given, and decodes the JSON response into v.
+func (r *RedCapClient) exportJSON(content string, arms []string, v interface{}) error {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {content},
+		"format":       {"json"},
+		"returnFormat": {"json"},
+	}
+	setArray(formating, "arms", arms)
+
+	data := strings.NewReader(formating.Encode())
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", content, err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("exporting %s: %w", content, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding %s: %w", content, err)
+	}
+	return nil
+}
+
+/*
+	EventForms groups the mappings by event.
+	
+	Args:
+		mappings: The mappings returned by ExportFormEventMappings.
+	
+	Returns:
+		The unique event names in mapping order and the instruments of each.
+*/
+func EventForms(mappings []FormEventMapping) ([]string, map[string][]string) {
+	var events []string
+	forms := make(map[string][]string)
+	for _, mapping := range mappings {
+		if _, ok := forms[mapping.UniqueEventName]; !ok {
+			events = append(events, mapping.UniqueEventName)
+		}
+		forms[mapping.UniqueEventName] = append(forms[mapping.UniqueEventName], mapping.Form)
+	}
+	return events, forms
+}
+
+// Event is an event of a longitudinal project.
+type Event struct {
+	EventName        string      `json:"event_name"`
+	ArmNum           json.Number `json:"arm_num"`
+	UniqueEventName  string      `json:"unique_event_name"`
+	CustomEventLabel string      `json:"custom_event_label"`
+	EventID          json.Number `json:"event_id"`
+}
+
+/*
+	ExportEventDefinitions exports the events of a longitudinal project as
+	typed values.
+	
+	Args:
+		arms: The arm numbers to export, or none for every arm.
+	
+	Returns:
+		The events of the project.
+*/
+func (r *RedCapClient) ExportEventDefinitions(arms ...string) ([]Event, error) {
+	var events []Event
+	if err := r.exportJSON("event", arms, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Arm is an arm of a longitudinal project.
+type Arm struct {
+	ArmNum json.Number `json:"arm_num"`
+	Name   string      `json:"name"`
+}
+
+/*
+	ExportArmDefinitions exports the arms of a longitudinal project as typed
+	values.
+	
+	Args:
+		arms: The arm numbers to export, or none for every arm.
+	
+	Returns:
+		The arms of the project.
+*/
+func (r *RedCapClient) ExportArmDefinitions(arms ...string) ([]Arm, error) {
+	var result []Arm
+	if err := r.exportJSON("arm", arms, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,356 @@
+package redcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RepositoryItem is a folder or file in the File Repository. Folders have a
+// FolderID and files a DocID.
+type RepositoryItem struct {
+	FolderID int
+	DocID    int
+	Name     string
+}
+
+func (i RepositoryItem) IsFolder() bool {
+	return i.FolderID != 0
+}
+
+func (i *RepositoryItem) UnmarshalJSON(data []byte) error {
+	var item struct {
+		FolderID json.Number `json:"folder_id"`
+		DocID    json.Number `json:"doc_id"`
+		Name     string      `json:"name"`
+	}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return err
+	}
+	i.Name = item.Name
+	if item.FolderID != "" {
+		folderID, err := strconv.Atoi(item.FolderID.String())
+		if err != nil {
+			return fmt.Errorf("invalid folder_id %q", item.FolderID)
+		}
+		i.FolderID = folderID
+	}
+	if item.DocID != "" {
+		docID, err := strconv.Atoi(item.DocID.String())
+		if err != nil {
+			return fmt.Errorf("invalid doc_id %q", item.DocID)
+		}
+		i.DocID = docID
+	}
+	return nil
+}
+
+// RepositoryFolderOptions places a new folder and limits who can see it.
+// Zero values create an unrestricted folder at the top level.
+type RepositoryFolderOptions struct {
+	ParentID int
+	DagID    int
+	RoleID   int
+}
+
+// repositoryRequest posts a File Repository request and returns the response
+// once REDCap has accepted it.
+func (r *RedCapClient) repositoryRequest(formating url.Values) (*http.Response, error) {
+	client := r.httpClient()
+
+	data := strings.NewReader(formating.Encode())
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		return nil, fmt.Errorf("file repository %s: %w", formating.Get("action"), err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("file repository %s: %w", formating.Get("action"), err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, newAPIError(resp)
+	}
+	return resp, nil
+}
+
+/*
+	CreateRepositoryFolder creates a folder in the File Repository.
+	
+	Args:
+		name: The name of the folder.
+		options: The parent folder and the DAG or role the folder is limited to.
+	
+	Returns:
+		The ID of the new folder.
+*/
+func (r *RedCapClient) CreateRepositoryFolder(name string, options RepositoryFolderOptions) (int, error) {
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"fileRepository"},
+		"action":       {"createFolder"},
+		"name":         {name},
+		"format":       {"json"},
+		"returnFormat": {"json"},
+	}
+	if options.ParentID > 0 {
+		formating.Set("folder_id", strconv.Itoa(options.ParentID))
+	}
+	if options.DagID > 0 {
+		formating.Set("dag_id", strconv.Itoa(options.DagID))
+	}
+	if options.RoleID > 0 {
+		formating.Set("role_id", strconv.Itoa(options.RoleID))
+	}
+
+	resp, err := r.repositoryRequest(formating)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var created []RepositoryItem
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return 0, fmt.Errorf("decoding new folder: %w", err)
+	}
+	if len(created) == 0 {
+		return 0, fmt.Errorf("REDCap did not return the new folder")
+	}
+	return created[0].FolderID, nil
+}
+
+/*
+	ListRepository lists the folders and files in a File Repository folder.
+	
+	Args:
+		folderID: The folder to list, or 0 for the top level.
+	
+	Returns:
+		The folders and files in the folder.
+*/
+func (r *RedCapClient) ListRepository(folderID int) ([]RepositoryItem, error) {
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"fileRepository"},
+		"action":       {"list"},
+		"format":       {"json"},
+		"returnFormat": {"json"},
+	}
+	if folderID > 0 {
+		formating.Set("folder_id", strconv.Itoa(folderID))
+	}
+
+	resp, err := r.repositoryRequest(formating)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var items []RepositoryItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, fmt.Errorf("decoding repository listing: %w", err)
+	}
+	return items, nil
+}
+
+/*
+	ExportRepositoryFile downloads a file from the File Repository and streams
+	it to the given writer.
+	
+	Args:
+		docID: The ID of the file.
+		w: The writer the file is copied to.
+	
+	Returns:
+		The name of the file, as sent by REDCap.
+*/
+func (r *RedCapClient) ExportRepositoryFile(docID int, w io.Writer) (string, error) {
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"fileRepository"},
+		"action":       {"export"},
+		"doc_id":       {strconv.Itoa(docID)},
+		"returnFormat": {"json"},
+	}
+
+	resp, err := r.repositoryRequest(formating)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return "", err
+	}
+
+	var name string
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		name = params["name"]
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); name == "" && err == nil {
+		name = params["filename"]
+	}
+	return name, nil
+}
+
+/*
+	ImportRepositoryFile uploads a file to the File Repository.
+	
+	Args:
+		folderID: The folder to upload to, or 0 for the top level.
+		filename: The name REDCap stores the file under.
+		file: The contents of the file.
+	
+	Returns:
+		An error if REDCap rejected the upload.
+*/
+func (r *RedCapClient) ImportRepositoryFile(folderID int, filename string, file io.Reader) error {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"fileRepository"},
+		"action":       {"import"},
+		"returnFormat": {"json"},
+	}
+	if folderID > 0 {
+		formating.Set("folder_id", strconv.Itoa(folderID))
+	}
+
+	data, contentType := multipartUpload(formating, filename, file)
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		data.Close()
+		return fmt.Errorf("uploading %s: %w", filename, err)
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", filename, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newAPIError(resp)
+	}
+	return nil
+}
+
+// multipartUpload streams a form with one file as a multipart request body,
+// so the file is never held in memory. A failure to read the file ends the
+// body with that error, which the request then returns. Closing the body
+// early stops the upload.
+func multipartUpload(fields url.Values, filename string, file io.Reader) (io.ReadCloser, string) {
+	body, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
+	contentType := writer.FormDataContentType()
+	go func() {
+		pipe.CloseWithError(writeMultipart(writer, fields, filename, file))
+	}()
+	return body, contentType
+}
+
+func writeMultipart(writer *multipart.Writer, fields url.Values, filename string, file io.Reader) error {
+	for key := range fields {
+		if err := writer.WriteField(key, fields.Get(key)); err != nil {
+			return err
+		}
+	}
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return fmt.Errorf("reading %s: %w", filename, err)
+	}
+	return writer.Close()
+}
+
+/*
+	DeleteRepositoryFile deletes a file from the File Repository.
+	
+	Args:
+		docID: The ID of the file.
+	
+	Returns:
+		An error if REDCap could not delete the file.
+*/
+func (r *RedCapClient) DeleteRepositoryFile(docID int) error {
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"fileRepository"},
+		"action":       {"delete"},
+		"doc_id":       {strconv.Itoa(docID)},
+		"returnFormat": {"json"},
+	}
+
+	resp, err := r.repositoryRequest(formating)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+/*
+	MirrorRepository copies a File Repository folder and everything below it
+	into a local directory, creating sub-directories for sub-folders.
+	Existing local files with the same names are overwritten.
+	
+	Args:
+		folderID: The folder to mirror, or 0 for the whole repository.
+		dir: The local directory to mirror into.
+	
+	Returns:
+		An error if a folder could not be listed or a file written.
+*/
+func (r *RedCapClient) MirrorRepository(folderID int, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	items, err := r.ListRepository(folderID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		name := filepath.Base(filepath.Clean("/" + item.Name))
+		if name == "/" || name == "." {
+			return fmt.Errorf("repository item %q has no usable name", item.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		if item.IsFolder() {
+			if err := r.MirrorRepository(item.FolderID, path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = r.ExportRepositoryFile(item.DocID, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("mirroring %s: %w", item.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package redcap
+
+import (
+	"net/url"
+	"strconv"
+)
+
+// FileRef locates a file uploaded to a file upload field of a record.
+type FileRef struct {
+	Record         string
+	Field          string
+	Form           string
+	Event          string
+	RepeatInstance int
+	// Value is what the record export holds for the field, normally the
+	// name of the uploaded file.
+	Value string
+}
+
+/*
+	RecordFiles lists every file uploaded to the file upload fields of a
+	record, across all of its events and repeating instances.
+	
+	Args:
+		record: The record ID.
+	
+	Returns:
+		A reference to each uploaded file, usable with ExportFile and DeleteFile.
+*/
+func (r *RedCapClient) RecordFiles(record string) ([]FileRef, error) {
+	dictionary, err := r.ExportDataDictionary()
+	if err != nil {
+		return nil, err
+	}
+	return r.recordFiles(dictionary, []string{record})
+}
+
+// recordFiles lists the uploaded files of the given records, or of every
+// record when records is empty.
+func (r *RedCapClient) recordFiles(dictionary DataDictionary, records []string) ([]FileRef, error) {
+	fileFields := dictionary.FieldsOfType("file")
+	if len(fileFields) == 0 {
+		return nil, nil
+	}
+
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"record"},
+		"format":       {"csv"},
+		"type":         {"flat"},
+		"returnFormat": {"json"},
+	}
+	setArray(formating, "records", records)
+	recordID := dictionary.RecordIDField()
+	fields := []string{recordID}
+	for _, field := range fileFields {
+		fields = append(fields, field.FieldName)
+	}
+	setArray(formating, "fields", fields)
+
+	_, rows, err := r.exportRecordsCSV(formating)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []FileRef
+	for _, row := range rows {
+		instance, _ := strconv.Atoi(row["redcap_repeat_instance"])
+		for _, field := range fileFields {
+			repeatInstrument := row["redcap_repeat_instrument"]
+			if repeatInstrument != "" && repeatInstrument != field.FormName {
+				continue
+			}
+			if row[field.FieldName] == "" {
+				continue
+			}
+			files = append(files, FileRef{
+				Record:         row[recordID],
+				Field:          field.FieldName,
+				Form:           field.FormName,
+				Event:          row["redcap_event_name"],
+				RepeatInstance: instance,
+				Value:          row[field.FieldName],
+			})
+		}
+	}
+	return files, nil
+}
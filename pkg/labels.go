@@ -0,0 +1,281 @@
+package redcap
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// LabelMode decides whether Translate replaces codes or keeps them.
+type LabelMode int
+
+const (
+	// ReplaceCodes replaces each code with its label.
+	ReplaceCodes LabelMode = iota
+	// AddLabelColumns keeps the codes and adds a "<column>_label" column
+	// after each column that has labels.
+	AddLabelColumns
+)
+
+var completeLabels = map[string]string{"0": "Incomplete", "1": "Unverified", "2": "Complete"}
+
+var checkboxLabels = map[string]string{"0": "Unchecked", "1": "Checked"}
+
+var booleanLabels = map[string]map[string]string{
+	"yesno":     {"0": "No", "1": "Yes"},
+	"truefalse": {"0": "False", "1": "True"},
+}
+
+// Labeler translates the raw codes of a flat export into labels, the way
+// REDCap does for rawOrLabel=label, without losing the codes.
+type Labeler struct {
+	Dictionary       DataDictionary
+	MissingDataCodes map[string]string
+	// Events maps unique event names to display labels.
+	Events map[string]string
+	// DataAccessGroups maps unique group names to display labels.
+	DataAccessGroups map[string]string
+
+	columns map[string]exportColumn
+	choices map[string]map[string]string
+}
+
+/*
+	NewLabeler builds a Labeler from a data dictionary and the project's
+	missing data codes.
+	
+	Args:
+		dictionary: The project's data dictionary.
+		missingDataCodes: The missing_data_codes project setting, such as
+			"NA, Not applicable | UNK, Unknown", or "".
+	
+	Returns:
+		The labeler, or an error if a choice list cannot be parsed.
+*/
+func NewLabeler(dictionary DataDictionary, missingDataCodes string) (*Labeler, error) {
+	l := &Labeler{
+		Dictionary:       dictionary,
+		MissingDataCodes: make(map[string]string),
+		Events:           make(map[string]string),
+		DataAccessGroups: make(map[string]string),
+		columns:          make(map[string]exportColumn),
+		choices:          make(map[string]map[string]string),
+	}
+
+	if missingDataCodes != "" {
+		codes, err := ParseChoices(missingDataCodes)
+		if err != nil {
+			return nil, fmt.Errorf("missing data codes: %w", err)
+		}
+		for _, code := range codes {
+			l.MissingDataCodes[code[0]] = code[1]
+		}
+	}
+
+	for _, column := range dictionary.exportColumns() {
+		l.columns[column.name] = column
+	}
+	for _, field := range dictionary {
+		switch field.FieldType {
+		case "radio", "dropdown", "checkbox":
+			choices, err := ParseChoices(field.SelectChoicesOrCalculations)
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.FieldName, err)
+			}
+			labels := make(map[string]string, len(choices))
+			for _, choice := range choices {
+				labels[choice[0]] = choice[1]
+			}
+			l.choices[field.FieldName] = labels
+		case "yesno", "truefalse":
+			l.choices[field.FieldName] = booleanLabels[field.FieldType]
+		}
+	}
+	return l, nil
+}
+
+/*
+	Label returns the label of a raw value in a column of a flat export.
+	Values without a label, such as free text, are returned as they are.
+	
+	Args:
+		column: The export column, such as "sex", "race___1" or
+			"redcap_event_name".
+		value: The raw value.
+	
+	Returns:
+		The label.
+*/
+func (l *Labeler) Label(column string, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	var labels map[string]string
+	switch column {
+	case EventNameColumn:
+		labels = l.Events
+	case DataAccessGroupColumn:
+		labels = l.DataAccessGroups
+	default:
+		c, ok := l.columns[column]
+		if !ok || column == l.Dictionary.RecordIDField() {
+			return value
+		}
+		if label, ok := l.MissingDataCodes[value]; ok && !c.checkbox && !c.complete {
+			return label
+		}
+		switch {
+		case c.complete:
+			labels = completeLabels
+		case c.checkbox:
+			labels = checkboxLabels
+		default:
+			labels = l.choices[c.field]
+		}
+	}
+
+	if label, ok := labels[value]; ok {
+		return label
+	}
+	return value
+}
+
+// hasLabels reports whether a column gets a label column in AddLabelColumns mode.
+func (l *Labeler) hasLabels(column string) bool {
+	switch column {
+	case EventNameColumn:
+		return len(l.Events) > 0
+	case DataAccessGroupColumn:
+		return len(l.DataAccessGroups) > 0
+	}
+	c, ok := l.columns[column]
+	if !ok || column == l.Dictionary.RecordIDField() {
+		return false
+	}
+	return c.complete || c.checkbox || l.choices[c.field] != nil || len(l.MissingDataCodes) > 0
+}
+
+/*
+	Translate labels the rows of a flat export. Columns the dictionary does
+	not know, such as survey timestamps, are left untouched.
+	
+	Args:
+		header: The columns of the export, as returned by ExportRecordRows.
+		rows: The rows of the export.
+		mode: Whether to replace codes or add label columns.
+	
+	Returns:
+		The new header and the labelled rows. The input rows are not changed.
+*/
+func (l *Labeler) Translate(header []string, rows []Record, mode LabelMode) ([]string, []Record) {
+	labelled := make([]Record, len(rows))
+	for i, row := range rows {
+		labelled[i] = make(Record, len(row))
+		for column, value := range row {
+			labelled[i][column] = value
+		}
+	}
+
+	if mode == ReplaceCodes {
+		for _, row := range labelled {
+			for _, column := range header {
+				if value, ok := row[column]; ok {
+					row[column] = l.Label(column, value)
+				}
+			}
+		}
+		return append([]string(nil), header...), labelled
+	}
+
+	var translated []string
+	for _, column := range header {
+		translated = append(translated, column)
+		if !l.hasLabels(column) {
+			continue
+		}
+		translated = append(translated, column+"_label")
+		for _, row := range labelled {
+			row[column+"_label"] = l.Label(column, row[column])
+		}
+	}
+	return translated, labelled
+}
+
+/*
+	ProjectLabeler builds a Labeler from the project's data dictionary,
+	missing data codes, events and data access groups. When the events span
+	more than one arm, their labels name the arm, as in
+	"Baseline (Arm 2: Drug B)".
+	
+	Args:
+		None
+	
+	Returns:
+		The labeler.
+*/
+func (r *RedCapClient) ProjectLabeler() (*Labeler, error) {
+	dictionary, err := r.ExportDataDictionary()
+	if err != nil {
+		return nil, err
+	}
+	info, err := r.ExportProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := NewLabeler(dictionary, info.MissingDataCodes)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsLongitudinal {
+		events, err := r.ExportEventDefinitions()
+		if err != nil {
+			return nil, err
+		}
+		if err := r.labelEvents(l, events); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, err := r.ExportDataAccessGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		l.DataAccessGroups[group.UniqueGroupName] = group.Name
+	}
+	return l, nil
+}
+
+// labelEvents adds the labels of events to l, naming the arm of each event
+// when there is more than one.
+func (r *RedCapClient) labelEvents(l *Labeler, events []Event) error {
+	arms := make(map[json.Number]bool)
+	for _, event := range events {
+		arms[event.ArmNum] = true
+	}
+	if len(arms) < 2 {
+		for _, event := range events {
+			l.Events[event.UniqueEventName] = event.EventName
+		}
+		return nil
+	}
+
+	definitions, err := r.ExportArmDefinitions()
+	if err != nil {
+		return err
+	}
+	names := make(map[json.Number]string, len(definitions))
+	for _, arm := range definitions {
+		names[arm.ArmNum] = arm.Name
+	}
+	for _, event := range events {
+		label := fmt.Sprintf("%s (Arm %s)", event.EventName, event.ArmNum)
+		if name := names[event.ArmNum]; name != "" {
+			label = fmt.Sprintf("%s (Arm %s: %s)", event.EventName, event.ArmNum, name)
+		}
+		l.Events[event.UniqueEventName] = label
+	}
+	return nil
+}
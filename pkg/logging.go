@@ -0,0 +1,69 @@
+package redcap
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoggingTimeFormat is the layout REDCap expects for beginTime and endTime and
+// uses for the timestamp of each log entry.
+const LoggingTimeFormat = "2006-01-02 15:04"
+
+type LogType string
+
+const (
+	LogExport       LogType = "export"
+	LogManage       LogType = "manage"
+	LogUser         LogType = "user"
+	LogRecord       LogType = "record"
+	LogRecordAdd    LogType = "record_add"
+	LogRecordEdit   LogType = "record_edit"
+	LogRecordDelete LogType = "record_delete"
+	LogLockRecord   LogType = "lock_record"
+	LogPageView     LogType = "page_view"
+)
+
+func (l LogType) valid() bool {
+	switch l {
+	case LogExport, LogManage, LogUser, LogRecord, LogRecordAdd, LogRecordEdit, LogRecordDelete, LogLockRecord, LogPageView:
+		return true
+	}
+	return false
+}
+
+// LoggingOptions filters the entries returned by ExportLogging. Zero values
+// are left out of the request.
+type LoggingOptions struct {
+	LogType   LogType
+	User      string
+	Record    string
+	Dag       string
+	BeginTime time.Time
+	EndTime   time.Time
+}
+
+// LogEntry is a single row of the project logging.
+type LogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Username  string `json:"username"`
+	Action    string `json:"action"`
+	Details   string `json:"details"`
+}
+
+/*
+	Time parses the timestamp of a log entry in the given location, which
+	should be the time zone of the REDCap server.
+	
+	Args:
+		location: The time zone of the REDCap server.
+	
+	Returns:
+		The time the entry was logged.
+*/
+func (e LogEntry) Time(location *time.Location) (time.Time, error) {
+	parsed, err := time.ParseInLocation(LoggingTimeFormat, e.Timestamp, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing log timestamp %q: %w", e.Timestamp, err)
+	}
+	return parsed, nil
+}
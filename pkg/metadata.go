@@ -0,0 +1,564 @@
+package redcap
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// MetadataField is a single row of a REDCap data dictionary, using the same
+// keys REDCap returns from content=metadata.
+type MetadataField struct {
+	FieldName                            string `json:"field_name"`
+	FormName                             string `json:"form_name"`
+	SectionHeader                        string `json:"section_header"`
+	FieldType                            string `json:"field_type"`
+	FieldLabel                           string `json:"field_label"`
+	SelectChoicesOrCalculations          string `json:"select_choices_or_calculations"`
+	FieldNote                            string `json:"field_note"`
+	TextValidationTypeOrShowSliderNumber string `json:"text_validation_type_or_show_slider_number"`
+	TextValidationMin                    string `json:"text_validation_min"`
+	TextValidationMax                    string `json:"text_validation_max"`
+	Identifier                           string `json:"identifier"`
+	BranchingLogic                       string `json:"branching_logic"`
+	RequiredField                        string `json:"required_field"`
+	CustomAlignment                      string `json:"custom_alignment"`
+	QuestionNumber                       string `json:"question_number"`
+	MatrixGroupName                      string `json:"matrix_group_name"`
+	MatrixRanking                        string `json:"matrix_ranking"`
+	FieldAnnotation                      string `json:"field_annotation"`
+}
+
+// DataDictionary is the ordered list of fields making up a project's metadata.
+type DataDictionary []MetadataField
+
+// DataDictionaryError lists every problem found by DataDictionary.Validate.
+type DataDictionaryError struct {
+	Problems []string
+}
+
+func (e *DataDictionaryError) Error() string {
+	return fmt.Sprintf("invalid data dictionary: %s", strings.Join(e.Problems, "; "))
+}
+
+// dataDictionaryColumns maps the headers of the CSV downloaded from the REDCap
+// Online Designer onto the metadata keys used by the API.
+var dataDictionaryColumns = map[string]string{
+	"Variable / Field Name": "field_name",
+	"Form Name":             "form_name",
+	"Section Header":        "section_header",
+	"Field Type":            "field_type",
+	"Field Label":           "field_label",
+	"Choices, Calculations, OR Slider Labels": "select_choices_or_calculations",
+	"Field Note": "field_note",
+	"Text Validation Type OR Show Slider Number": "text_validation_type_or_show_slider_number",
+	"Text Validation Min":                        "text_validation_min",
+	"Text Validation Max":                        "text_validation_max",
+	"Identifier?":                                "identifier",
+	"Branching Logic (Show field only if...)":    "branching_logic",
+	"Required Field?":                            "required_field",
+	"Custom Alignment":                           "custom_alignment",
+	"Question Number (surveys only)":             "question_number",
+	"Matrix Group Name":                          "matrix_group_name",
+	"Matrix Ranking?":                            "matrix_ranking",
+	"Field Annotation":                           "field_annotation",
+}
+
+var fieldTypes = map[string]bool{
+	"text":        true,
+	"notes":       true,
+	"dropdown":    true,
+	"radio":       true,
+	"checkbox":    true,
+	"calc":        true,
+	"file":        true,
+	"yesno":       true,
+	"truefalse":   true,
+	"slider":      true,
+	"descriptive": true,
+	"sql":         true,
+}
+
+var validationTypes = map[string]bool{
+	"date_dmy":                 true,
+	"date_mdy":                 true,
+	"date_ymd":                 true,
+	"datetime_dmy":             true,
+	"datetime_mdy":             true,
+	"datetime_ymd":             true,
+	"datetime_seconds_dmy":     true,
+	"datetime_seconds_mdy":     true,
+	"datetime_seconds_ymd":     true,
+	"email":                    true,
+	"integer":                  true,
+	"alpha_only":               true,
+	"mrn_10d":                  true,
+	"mrn_generic":              true,
+	"number":                   true,
+	"number_1dp":               true,
+	"number_1dp_comma_decimal": true,
+	"number_2dp":               true,
+	"number_2dp_comma_decimal": true,
+	"number_3dp":               true,
+	"number_3dp_comma_decimal": true,
+	"number_4dp":               true,
+	"number_4dp_comma_decimal": true,
+	"number_comma_decimal":     true,
+	"phone":                    true,
+	"phone_australia":          true,
+	"postalcode_australia":     true,
+	"postalcode_canada":        true,
+	"postalcode_french":        true,
+	"postalcode_germany":       true,
+	"ssn":                      true,
+	"time":                     true,
+	"time_hh_mm_ss":            true,
+	"time_mm_ss":               true,
+	"vmrn":                     true,
+	"zipcode":                  true,
+}
+
+var (
+	fieldNamePattern    = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	checkboxCodePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+)
+
+/*
+	ParseMetadata parses the JSON returned by ExportMetadata into a data dictionary.
+	
+	Args:
+		body: The JSON response from the REDCap API.
+	
+	Returns:
+		The parsed data dictionary.
+*/
+func ParseMetadata(body []byte) (DataDictionary, error) {
+	var dictionary DataDictionary
+	if err := json.Unmarshal(body, &dictionary); err != nil {
+		return nil, fmt.Errorf("parsing metadata: %w", err)
+	}
+	return dictionary, nil
+}
+
+/*
+	ParseDataDictionaryCSV parses a REDCap data dictionary CSV. Both the headers
+	used by the Online Designer download and the API metadata keys are accepted.
+	
+	Args:
+		reader: The CSV source.
+	
+	Returns:
+		The parsed data dictionary.
+*/
+func ParseDataDictionaryCSV(reader io.Reader) (DataDictionary, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading data dictionary headers: %w", err)
+	}
+
+	columns := make([]string, len(headers))
+	for i, header := range headers {
+		header = strings.TrimSpace(strings.TrimPrefix(header, "\ufeff"))
+		if key, ok := dataDictionaryColumns[header]; ok {
+			columns[i] = key
+		} else {
+			columns[i] = header
+		}
+	}
+
+	var dictionary DataDictionary
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading data dictionary: %w", err)
+		}
+
+		row := make(map[string]string, len(columns))
+		for i, value := range record {
+			if i < len(columns) {
+				row[columns[i]] = value
+			}
+		}
+		dictionary = append(dictionary, MetadataField{
+			FieldName:                            row["field_name"],
+			FormName:                             row["form_name"],
+			SectionHeader:                        row["section_header"],
+			FieldType:                            row["field_type"],
+			FieldLabel:                           row["field_label"],
+			SelectChoicesOrCalculations:          row["select_choices_or_calculations"],
+			FieldNote:                            row["field_note"],
+			TextValidationTypeOrShowSliderNumber: row["text_validation_type_or_show_slider_number"],
+			TextValidationMin:                    row["text_validation_min"],
+			TextValidationMax:                    row["text_validation_max"],
+			Identifier:                           row["identifier"],
+			BranchingLogic:                       row["branching_logic"],
+			RequiredField:                        row["required_field"],
+			CustomAlignment:                      row["custom_alignment"],
+			QuestionNumber:                       row["question_number"],
+			MatrixGroupName:                      row["matrix_group_name"],
+			MatrixRanking:                        row["matrix_ranking"],
+			FieldAnnotation:                      row["field_annotation"],
+		})
+	}
+	return dictionary, nil
+}
+
+/*
+	ParseChoices splits a "1, Yes | 2, No" choice string into codes and labels.
+	
+	Args:
+		choices: The select_choices_or_calculations value of a field.
+	
+	Returns:
+		The choice codes and labels in the order they were defined.
+*/
+func ParseChoices(choices string) ([][2]string, error) {
+	var parsed [][2]string
+	if strings.TrimSpace(choices) == "" {
+		return parsed, nil
+	}
+	for _, choice := range strings.Split(choices, "|") {
+		code, label, found := strings.Cut(choice, ",")
+		code, label = strings.TrimSpace(code), strings.TrimSpace(label)
+		if !found || code == "" {
+			return nil, fmt.Errorf("choice %q is not of the form \"code, label\"", strings.TrimSpace(choice))
+		}
+		parsed = append(parsed, [2]string{code, label})
+	}
+	return parsed, nil
+}
+
+/*
+	Validate runs the checks REDCap would apply on import locally, so an invalid
+	dictionary is rejected before it reaches the server.
+	
+	Args:
+		None
+	
+	Returns:
+		A *DataDictionaryError listing every problem, or nil.
+*/
+func (d DataDictionary) Validate() error {
+	var problems []string
+	if len(d) == 0 {
+		return &DataDictionaryError{Problems: []string{"data dictionary has no fields"}}
+	}
+	if d[0].FieldType != "text" {
+		problems = append(problems, fmt.Sprintf("first field %q must be the record ID text field, got type %q", d[0].FieldName, d[0].FieldType))
+	}
+	if d[0].BranchingLogic != "" {
+		problems = append(problems, fmt.Sprintf("record ID field %q cannot have branching logic", d[0].FieldName))
+	}
+
+	seen := make(map[string]bool, len(d))
+	for i, field := range d {
+		row := i + 1
+		if field.FieldName == "" {
+			problems = append(problems, fmt.Sprintf("row %d: missing field name", row))
+			continue
+		}
+		if seen[field.FieldName] {
+			problems = append(problems, fmt.Sprintf("row %d: duplicate field name %q", row, field.FieldName))
+		}
+		seen[field.FieldName] = true
+
+		if !fieldNamePattern.MatchString(field.FieldName) {
+			problems = append(problems, fmt.Sprintf("row %d: field name %q must be lowercase letters, numbers and underscores starting with a letter", row, field.FieldName))
+		}
+		if field.FormName == "" {
+			problems = append(problems, fmt.Sprintf("row %d: field %q has no form name", row, field.FieldName))
+		}
+		if !fieldTypes[field.FieldType] {
+			problems = append(problems, fmt.Sprintf("row %d: field %q has invalid field type %q", row, field.FieldName, field.FieldType))
+			continue
+		}
+
+		validation := field.TextValidationTypeOrShowSliderNumber
+		switch field.FieldType {
+		case "text":
+			if validation != "" && !validationTypes[validation] {
+				problems = append(problems, fmt.Sprintf("row %d: field %q has invalid validation type %q", row, field.FieldName, validation))
+			}
+		case "slider":
+			if validation != "" && validation != "number" {
+				problems = append(problems, fmt.Sprintf("row %d: slider %q can only set the show slider number option to \"number\"", row, field.FieldName))
+			}
+		default:
+			if validation != "" {
+				problems = append(problems, fmt.Sprintf("row %d: field %q of type %q cannot have a validation type", row, field.FieldName, field.FieldType))
+			}
+		}
+
+		switch field.FieldType {
+		case "radio", "dropdown", "checkbox":
+			choices, err := ParseChoices(field.SelectChoicesOrCalculations)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("row %d: field %q: %s", row, field.FieldName, err))
+				continue
+			}
+			if len(choices) == 0 {
+				problems = append(problems, fmt.Sprintf("row %d: field %q of type %q has no choices", row, field.FieldName, field.FieldType))
+			}
+			codes := make(map[string]bool, len(choices))
+			for _, choice := range choices {
+				if codes[choice[0]] {
+					problems = append(problems, fmt.Sprintf("row %d: field %q has duplicate choice code %q", row, field.FieldName, choice[0]))
+				}
+				codes[choice[0]] = true
+				if field.FieldType == "checkbox" && !checkboxCodePattern.MatchString(choice[0]) {
+					problems = append(problems, fmt.Sprintf("row %d: checkbox %q has invalid choice code %q", row, field.FieldName, choice[0]))
+				}
+			}
+		case "calc":
+			if strings.TrimSpace(field.SelectChoicesOrCalculations) == "" {
+				problems = append(problems, fmt.Sprintf("row %d: calculated field %q has no calculation", row, field.FieldName))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &DataDictionaryError{Problems: problems}
+	}
+	return nil
+}
+
+/*
+	ExportDataDictionary exports the project metadata as a parsed data
+	dictionary, whatever the client's response format.
+	
+	Args:
+		None
+	
+	Returns:
+		The project's data dictionary.
+*/
+func (r *RedCapClient) ExportDataDictionary() (DataDictionary, error) {
+	client := r.httpClient()
+	formating := fmt.Sprintf("token=%s&content=metadata&format=json&returnFormat=json", r.Token)
+
+	data := strings.NewReader(formating)
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		return nil, fmt.Errorf("exporting the data dictionary: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("exporting the data dictionary: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	bodyText, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("exporting the data dictionary: %w", err)
+	}
+
+	return ParseMetadata(bodyText)
+}
+
+/*
+	FieldsOfType returns the fields of the given type, such as "file".
+	
+	Args:
+		fieldType: The REDCap field type.
+	
+	Returns:
+		The matching fields in dictionary order.
+*/
+func (d DataDictionary) FieldsOfType(fieldType string) DataDictionary {
+	var fields DataDictionary
+	for _, field := range d {
+		if field.FieldType == fieldType {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+/*
+	RecordIDField returns the name of the record ID field, which is always
+	the first field of the dictionary.
+	
+	Args:
+		None
+	
+	Returns:
+		The record ID field name, or "" for an empty dictionary.
+*/
+func (d DataDictionary) RecordIDField() string {
+	if len(d) == 0 {
+		return ""
+	}
+	return d[0].FieldName
+}
+
+/*
+	Forms returns the instrument names in the order they appear.
+	
+	Args:
+		None
+	
+	Returns:
+		The unique form names.
+*/
+func (d DataDictionary) Forms() []string {
+	var forms []string
+	seen := make(map[string]bool)
+	for _, field := range d {
+		if !seen[field.FormName] {
+			seen[field.FormName] = true
+			forms = append(forms, field.FormName)
+		}
+	}
+	return forms
+}
+
+/*
+	Field finds a field by name.
+	
+	Args:
+		name: The field name.
+	
+	Returns:
+		The field, or nil if the dictionary has no such field.
+*/
+func (d DataDictionary) Field(name string) *MetadataField {
+	for i := range d {
+		if d[i].FieldName == name {
+			return &d[i]
+		}
+	}
+	return nil
+}
+
+/*
+	CheckboxColumn returns the export column REDCap uses for one choice of a
+	checkbox field, such as "race___1" or "race____1" for a code of -1.
+	
+	Args:
+		field: The checkbox field name.
+		code: The choice code.
+	
+	Returns:
+		The column name.
+*/
+func CheckboxColumn(field string, code string) string {
+	code = strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToLower(code))
+	return field + "___" + code
+}
+
+/*
+	ExportColumns returns the columns a field occupies in a flat record
+	export: one per choice for checkbox fields, none for descriptive fields
+	and the field name otherwise.
+	
+	Args:
+		None
+	
+	Returns:
+		The column names.
+*/
+func (f MetadataField) ExportColumns() []string {
+	switch f.FieldType {
+	case "descriptive":
+		return nil
+	case "checkbox":
+		choices, _ := ParseChoices(f.SelectChoicesOrCalculations)
+		columns := make([]string, len(choices))
+		for i, choice := range choices {
+			columns[i] = CheckboxColumn(f.FieldName, choice[0])
+		}
+		return columns
+	}
+	return []string{f.FieldName}
+}
+
+/*
+	ImportMetadata imports a data dictionary into a REDCap project. The
+	dictionary is validated locally first and nothing is sent if it is invalid.
+	
+	Args:
+		dictionary: The fields to import, record ID field first.
+	
+	Returns:
+		A byte slice containing the response from the REDCap API.
+*/
+func (r *RedCapClient) ImportMetadata(dictionary DataDictionary) ([]byte, error) {
+	if err := dictionary.Validate(); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(dictionary)
+	if err != nil {
+		return nil, err
+	}
+
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"metadata"},
+		"format":       {"json"},
+		"data":         {string(payload)},
+		"returnFormat": {string(r.ResponseFormat)},
+	}
+
+	data := strings.NewReader(formating.Encode())
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		return nil, fmt.Errorf("importing metadata: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("importing metadata: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	bodyText, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("importing metadata: %w", err)
+	}
+
+	return bodyText, nil
+}
+
+/*
+	ImportMetadataCSV imports a REDCap data dictionary CSV into a REDCap project.
+	
+	Args:
+		reader: The data dictionary CSV.
+	
+	Returns:
+		A byte slice containing the response from the REDCap API.
+*/
+func (r *RedCapClient) ImportMetadataCSV(reader io.Reader) ([]byte, error) {
+	dictionary, err := ParseDataDictionaryCSV(reader)
+	if err != nil {
+		return nil, err
+	}
+	return r.ImportMetadata(dictionary)
+}
@@ -0,0 +1,489 @@
+// Package mirror keeps a SQLite copy of a REDCap project for querying with
+// SQL. Each instrument gets a table whose columns follow the data
+// dictionary, keyed by record, event and repeat instance, and the copy is
+// kept current with redcap.Syncer. The SQLite driver is pure Go, so no C
+// compiler is needed.
+//
+// A table holds one row for every record, event and instance where its
+// instrument has data, so instruments join on their keys:
+//
+//	SELECT * FROM demographics JOIN visits USING (record_id, redcap_event_name)
+package mirror
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	_ "modernc.org/sqlite"
+)
+
+// StateTable is the table where a mirror keeps its schema and high water
+// mark. Instrument names cannot start with an underscore, so it never
+// clashes with an instrument table.
+const StateTable = "_redcap_state"
+
+// Keys of the state table.
+const (
+	stateSchema   = "schema"
+	stateTables   = "tables"
+	stateLastSync = "last_sync"
+)
+
+// deleteBatch is how many records one DELETE names, well below SQLite's
+// limit on statement variables.
+const deleteBatch = 500
+
+// Mirror is a SQLite copy of a project. It is the sink and the store of
+// the redcap.Syncer that keeps it current.
+type Mirror struct {
+	DB         *sql.DB
+	dictionary redcap.DataDictionary
+	mappings   []redcap.FormEventMapping
+	tables     []string
+}
+
+/*
+	Open opens or creates the mirror database at a path.
+	
+	Args:
+		path: The SQLite database file.
+		dictionary: The project's data dictionary.
+		mappings: The form-event mappings, or nil for classic projects.
+	
+	Returns:
+		The mirror, which the caller closes.
+*/
+func Open(path string, dictionary redcap.DataDictionary, mappings []redcap.FormEventMapping) (*Mirror, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, so share one connection rather
+	// than have writers wait on each other's locks.
+	db.SetMaxOpenConns(1)
+	m, err := New(db, dictionary, mappings)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+/*
+	OpenProject opens the mirror of the project behind a client, reading
+	the data dictionary and, for longitudinal projects, the form-event
+	mappings from REDCap.
+	
+	Args:
+		path: The SQLite database file.
+		client: A client for the project.
+	
+	Returns:
+		The mirror, which the caller closes.
+*/
+func OpenProject(path string, client *redcap.RedCapClient) (*Mirror, error) {
+	dictionary, err := client.ExportDataDictionary()
+	if err != nil {
+		return nil, err
+	}
+	if len(dictionary) == 0 {
+		return nil, fmt.Errorf("project has no fields")
+	}
+	info, err := client.ExportProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+	var mappings []redcap.FormEventMapping
+	if info.IsLongitudinal {
+		if mappings, err = client.ExportFormEventMappings(); err != nil {
+			return nil, err
+		}
+	}
+	return Open(path, dictionary, mappings)
+}
+
+/*
+	New sets up a mirror in an open SQLite database, creating the instrument
+	tables. When the dictionary or mappings no longer match the tables, the
+	tables are dropped and created again and the high water mark cleared, so
+	the next sync reloads every record.
+	
+	Args:
+		db: The database, opened with the "sqlite" driver.
+		dictionary: The project's data dictionary.
+		mappings: The form-event mappings, or nil for classic projects.
+	
+	Returns:
+		The mirror.
+*/
+func New(db *sql.DB, dictionary redcap.DataDictionary, mappings []redcap.FormEventMapping) (*Mirror, error) {
+	m := &Mirror{DB: db, dictionary: dictionary, mappings: mappings, tables: dictionary.Forms()}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + quote(StateTable) + ` (name TEXT PRIMARY KEY, value TEXT NOT NULL)`); err != nil {
+		return nil, err
+	}
+
+	// The mappings decide which rows the syncer writes to each table, so
+	// they are part of the schema although no statement names them.
+	statements := m.schema()
+	designated, err := json.Marshal(mappings)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(strings.Join(statements, ";\n") + "\n" + string(designated)))
+	schema := hex.EncodeToString(sum[:])
+	current, err := m.state(stateSchema)
+	if err != nil || current == schema {
+		return m, err
+	}
+
+	var previous []string
+	if saved, err := m.state(stateTables); err != nil {
+		return nil, err
+	} else if saved != "" {
+		if err := json.Unmarshal([]byte(saved), &previous); err != nil {
+			return nil, fmt.Errorf("reading mirror tables: %w", err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, table := range previous {
+		if _, err := tx.Exec(`DROP TABLE IF EXISTS ` + quote(table)); err != nil {
+			return nil, err
+		}
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return nil, err
+		}
+	}
+	tables, _ := json.Marshal(m.tables)
+	for name, value := range map[string]string{stateSchema: schema, stateTables: string(tables)} {
+		if err := setState(tx, name, value); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM `+quote(StateTable)+` WHERE name = ?`, stateLastSync); err != nil {
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+// schema returns the CREATE TABLE statements of the instrument tables.
+func (m *Mirror) schema() []string {
+	recordID := m.dictionary.RecordIDField()
+	var statements []string
+	for _, form := range m.tables {
+		columns := []string{
+			quote(recordID) + " TEXT NOT NULL",
+			quote(redcap.EventNameColumn) + " TEXT NOT NULL DEFAULT ''",
+			quote(redcap.RepeatInstanceColumn) + " INTEGER NOT NULL DEFAULT 1",
+			quote(redcap.DataAccessGroupColumn) + " TEXT",
+		}
+		for _, field := range m.dictionary {
+			if field.FormName != form || field.FieldName == recordID {
+				continue
+			}
+			for _, column := range field.ExportColumns() {
+				columns = append(columns, quote(column)+" "+columnType(field))
+			}
+		}
+		columns = append(columns,
+			quote(form+"_complete")+" INTEGER",
+			"PRIMARY KEY ("+quote(recordID)+", "+quote(redcap.EventNameColumn)+", "+quote(redcap.RepeatInstanceColumn)+")",
+		)
+		statements = append(statements, "CREATE TABLE "+quote(form)+" (\n\t"+strings.Join(columns, ",\n\t")+"\n)")
+	}
+	return statements
+}
+
+// columnType picks the SQLite type of a field's columns. Values are stored
+// as REDCap exports them and SQLite converts those that fit the type, so a
+// malformed number stays readable as text.
+func columnType(field redcap.MetadataField) string {
+	switch field.FieldType {
+	case "checkbox", "yesno", "truefalse", "slider":
+		return "INTEGER"
+	case "calc":
+		return "REAL"
+	case "radio", "dropdown":
+		choices, err := redcap.ParseChoices(field.SelectChoicesOrCalculations)
+		if err != nil || len(choices) == 0 {
+			return "TEXT"
+		}
+		for _, choice := range choices {
+			if _, err := strconv.Atoi(choice[0]); err != nil {
+				return "TEXT"
+			}
+		}
+		return "INTEGER"
+	case "text":
+		validation := field.TextValidationTypeOrShowSliderNumber
+		switch {
+		case validation == "integer":
+			return "INTEGER"
+		case validation == "number" || strings.HasPrefix(validation, "number_"):
+			return "REAL"
+		}
+	}
+	return "TEXT"
+}
+
+/*
+	Upsert replaces the rows of the given records with the rows of a flat
+	export, split by instrument. It makes the mirror a redcap.SyncSink.
+	
+	Args:
+		recordIDField: The record ID column of the rows.
+		rows: Every row of the records to replace.
+	
+	Returns:
+		An error if the database could not be written.
+*/
+func (m *Mirror) Upsert(recordIDField string, rows []redcap.Record) error {
+	var records []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if id := row[recordIDField]; !seen[id] {
+			seen[id] = true
+			records = append(records, id)
+		}
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.delete(tx, records); err != nil {
+		return err
+	}
+	for _, table := range redcap.SplitByInstrument(rows, m.dictionary, m.mappings) {
+		if len(table.Rows) == 0 {
+			continue
+		}
+		columns := make([]string, len(table.Columns))
+		for i, column := range table.Columns {
+			columns[i] = quote(column)
+		}
+		insert, err := tx.Prepare(`INSERT INTO ` + quote(table.Instrument) + ` (` + strings.Join(columns, ", ") +
+			`) VALUES (?` + strings.Repeat(", ?", len(columns)-1) + `)`)
+		if err != nil {
+			return err
+		}
+		for _, row := range table.Rows {
+			values := make([]interface{}, len(table.Columns))
+			for i, column := range table.Columns {
+				values[i] = value(column, row[column])
+			}
+			if _, err := insert.Exec(values...); err != nil {
+				insert.Close()
+				return fmt.Errorf("mirroring record %s in %s: %w", row[recordIDField], table.Instrument, err)
+			}
+		}
+		insert.Close()
+	}
+	return tx.Commit()
+}
+
+// value converts an exported value for the database. Blank values are
+// NULL, except for the key columns, where a non-repeating row is instance 1
+// and a classic project's event is empty.
+func value(column string, value string) interface{} {
+	switch {
+	case column == redcap.RepeatInstanceColumn && value == "":
+		return 1
+	case column == redcap.EventNameColumn:
+		return value
+	case value == "":
+		return nil
+	}
+	return value
+}
+
+/*
+	Delete removes every row of the given records. It makes the mirror a
+	redcap.SyncSink.
+	
+	Args:
+		records: The record names.
+	
+	Returns:
+		An error if the database could not be written.
+*/
+func (m *Mirror) Delete(records []string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := m.delete(tx, records); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *Mirror) delete(tx *sql.Tx, records []string) error {
+	recordID := quote(m.dictionary.RecordIDField())
+	for start := 0; start < len(records); start += deleteBatch {
+		batch := records[start:min(start+deleteBatch, len(records))]
+		args := make([]interface{}, len(batch))
+		for i, record := range batch {
+			args[i] = record
+		}
+		placeholders := "?" + strings.Repeat(", ?", len(batch)-1)
+		for _, table := range m.tables {
+			if _, err := tx.Exec(`DELETE FROM `+quote(table)+` WHERE `+recordID+` IN (`+placeholders+`)`, args...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+	Reset empties the instrument tables before a full sync. It makes the
+	mirror a redcap.SyncResetter.
+	
+	Args:
+		None
+	
+	Returns:
+		An error if the database could not be written.
+*/
+func (m *Mirror) Reset() error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range m.tables {
+		if _, err := tx.Exec(`DELETE FROM ` + quote(table)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+/*
+	Load reads the high water mark from the state table. It makes the
+	mirror a redcap.SyncStore, so the mark is kept with the data it covers.
+	
+	Args:
+		None
+	
+	Returns:
+		The saved state, or the zero state before the first sync.
+*/
+func (m *Mirror) Load() (redcap.SyncState, error) {
+	var state redcap.SyncState
+	saved, err := m.state(stateLastSync)
+	if err != nil || saved == "" {
+		return state, err
+	}
+	if state.LastSync, err = time.Parse(time.RFC3339Nano, saved); err != nil {
+		return state, fmt.Errorf("reading mirror state: %w", err)
+	}
+	return state, nil
+}
+
+/*
+	Save writes the high water mark to the state table. Saving the zero
+	state makes the next sync a full one.
+	
+	Args:
+		state: The state to save.
+	
+	Returns:
+		An error if the database could not be written.
+*/
+func (m *Mirror) Save(state redcap.SyncState) error {
+	if state.LastSync.IsZero() {
+		_, err := m.DB.Exec(`DELETE FROM `+quote(StateTable)+` WHERE name = ?`, stateLastSync)
+		return err
+	}
+	return setState(m.DB, stateLastSync, state.LastSync.Format(time.RFC3339Nano))
+}
+
+/*
+	Syncer returns a syncer that keeps the mirror current with a project.
+	Set its Location to the REDCap server's time zone if it differs from
+	the local one.
+	
+	Args:
+		client: A client for the project.
+	
+	Returns:
+		The syncer; each call of its Sync applies the changes since the last.
+*/
+func (m *Mirror) Syncer(client *redcap.RedCapClient) *redcap.Syncer {
+	return &redcap.Syncer{
+		Client:  client,
+		Store:   m,
+		Sink:    m,
+		Options: redcap.RecordsOptions{ExportDataAccessGroups: true},
+	}
+}
+
+/*
+	Tables returns the names of the instrument tables.
+	
+	Args:
+		None
+	
+	Returns:
+		The table names in dictionary order.
+*/
+func (m *Mirror) Tables() []string {
+	return append([]string(nil), m.tables...)
+}
+
+/*
+	Close closes the database.
+	
+	Args:
+		None
+	
+	Returns:
+		An error if the database could not be closed.
+*/
+func (m *Mirror) Close() error {
+	return m.DB.Close()
+}
+
+// state reads a value of the state table, or "" if it is not set.
+func (m *Mirror) state(name string) (string, error) {
+	var value string
+	err := m.DB.QueryRow(`SELECT value FROM `+quote(StateTable)+` WHERE name = ?`, name).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return value, err
+}
+
+// execer is what setState needs of a database or transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func setState(db execer, name string, value string) error {
+	_, err := db.Exec(`INSERT INTO `+quote(StateTable)+` (name, value) VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE SET value = excluded.value`, name, value)
+	return err
+}
+
+// quote quotes an SQL identifier.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
@@ -0,0 +1,393 @@
+package redcap
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const (
+	// ODMNamespace is the CDISC ODM 1.3 namespace used by ExportProjectXML.
+	ODMNamespace = "http://www.cdisc.org/ns/odm/v1.3"
+	// REDCapNamespace is the namespace of the redcap: extensions in project XML.
+	REDCapNamespace = "https://projectredcap.org"
+)
+
+// ODM is a CDISC ODM 1.3 document as produced by ExportProjectXML.
+type ODM struct {
+	XMLName             xml.Name      `xml:"http://www.cdisc.org/ns/odm/v1.3 ODM"`
+	ODMVersion          string        `xml:"ODMVersion,attr,omitempty"`
+	FileOID             string        `xml:"FileOID,attr,omitempty"`
+	FileType            string        `xml:"FileType,attr,omitempty"`
+	Description         string        `xml:"Description,attr,omitempty"`
+	AsOfDateTime        string        `xml:"AsOfDateTime,attr,omitempty"`
+	CreationDateTime    string        `xml:"CreationDateTime,attr,omitempty"`
+	SourceSystem        string        `xml:"SourceSystem,attr,omitempty"`
+	SourceSystemVersion string        `xml:"SourceSystemVersion,attr,omitempty"`
+	Study               Study         `xml:"Study"`
+	ClinicalData        *ClinicalData `xml:"ClinicalData,omitempty"`
+}
+
+type Study struct {
+	OID             string          `xml:"OID,attr"`
+	GlobalVariables GlobalVariables `xml:"GlobalVariables"`
+	MetaDataVersion MetaDataVersion `xml:"MetaDataVersion"`
+}
+
+// GlobalVariables holds the study names and the REDCap project settings.
+// Extensions not modelled here are kept in Other so a document survives a
+// parse and write unchanged.
+type GlobalVariables struct {
+	StudyName                     string                            `xml:"StudyName"`
+	StudyDescription              string                            `xml:"StudyDescription"`
+	ProtocolName                  string                            `xml:"ProtocolName"`
+	RecordAutonumberingEnabled    string                            `xml:"https://projectredcap.org RecordAutonumberingEnabled,omitempty"`
+	CustomRecordLabel             string                            `xml:"https://projectredcap.org CustomRecordLabel,omitempty"`
+	SecondaryUniqueField          string                            `xml:"https://projectredcap.org SecondaryUniqueField,omitempty"`
+	SchedulingEnabled             string                            `xml:"https://projectredcap.org SchedulingEnabled,omitempty"`
+	SurveysEnabled                string                            `xml:"https://projectredcap.org SurveysEnabled,omitempty"`
+	SurveyInvitationEmailField    string                            `xml:"https://projectredcap.org SurveyInvitationEmailField,omitempty"`
+	Purpose                       string                            `xml:"https://projectredcap.org Purpose,omitempty"`
+	PurposeOther                  string                            `xml:"https://projectredcap.org PurposeOther,omitempty"`
+	ProjectNotes                  string                            `xml:"https://projectredcap.org ProjectNotes,omitempty"`
+	MissingDataCodes              string                            `xml:"https://projectredcap.org MissingDataCodes,omitempty"`
+	RepeatingInstrumentsAndEvents *ODMRepeatingInstrumentsAndEvents `xml:"https://projectredcap.org RepeatingInstrumentsAndEvents,omitempty"`
+	DataAccessGroupsGroup         *ODMDataAccessGroupsGroup         `xml:"https://projectredcap.org DataAccessGroupsGroup,omitempty"`
+	SurveysGroup                  *ODMSurveysGroup                  `xml:"https://projectredcap.org SurveysGroup,omitempty"`
+	Other                         []ODMExtension                    `xml:",any"`
+}
+
+type ODMRepeatingInstrumentsAndEvents struct {
+	RepeatingInstruments *ODMRepeatingInstruments `xml:"https://projectredcap.org RepeatingInstruments,omitempty"`
+	RepeatingEvents      []ODMRepeatingEvent      `xml:"https://projectredcap.org RepeatingEvent"`
+}
+
+type ODMRepeatingInstruments struct {
+	RepeatingInstruments []ODMRepeatingInstrument `xml:"https://projectredcap.org RepeatingInstrument"`
+}
+
+type ODMRepeatingInstrument struct {
+	UniqueEventName  string `xml:"https://projectredcap.org UniqueEventName,attr"`
+	RepeatInstrument string `xml:"https://projectredcap.org RepeatInstrument,attr"`
+	CustomLabel      string `xml:"https://projectredcap.org CustomLabel,attr"`
+}
+
+type ODMRepeatingEvent struct {
+	UniqueEventName string `xml:"https://projectredcap.org UniqueEventName,attr"`
+}
+
+type ODMDataAccessGroupsGroup struct {
+	DataAccessGroups []ODMDataAccessGroup `xml:"https://projectredcap.org DataAccessGroups"`
+}
+
+type ODMDataAccessGroup struct {
+	GroupName string     `xml:"https://projectredcap.org group_name,attr"`
+	Other     []xml.Attr `xml:",any,attr"`
+}
+
+type ODMSurveysGroup struct {
+	Surveys []ODMSurvey `xml:"https://projectredcap.org Surveys"`
+}
+
+// ODMSurvey holds the settings of a single survey. REDCap writes every survey
+// setting as an attribute; the ones not named here are kept in Other.
+type ODMSurvey struct {
+	FormName        string     `xml:"https://projectredcap.org form_name,attr"`
+	Title           string     `xml:"https://projectredcap.org title,attr"`
+	Instructions    string     `xml:"https://projectredcap.org instructions,attr,omitempty"`
+	Acknowledgement string     `xml:"https://projectredcap.org acknowledgement,attr,omitempty"`
+	SurveyEnabled   string     `xml:"https://projectredcap.org survey_enabled,attr,omitempty"`
+	SaveAndReturn   string     `xml:"https://projectredcap.org save_and_return,attr,omitempty"`
+	Other           []xml.Attr `xml:",any,attr"`
+}
+
+// ODMExtension is an element the model does not know about, kept verbatim.
+type ODMExtension struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Content string     `xml:",innerxml"`
+}
+
+type MetaDataVersion struct {
+	OID            string          `xml:"OID,attr"`
+	Name           string          `xml:"Name,attr"`
+	RecordIdField  string          `xml:"https://projectredcap.org RecordIdField,attr,omitempty"`
+	StudyEventRefs []StudyEventRef `xml:"Protocol>StudyEventRef"`
+	StudyEventDefs []StudyEventDef `xml:"StudyEventDef"`
+	FormDefs       []FormDef       `xml:"FormDef"`
+	ItemGroupDefs  []ItemGroupDef  `xml:"ItemGroupDef"`
+	ItemDefs       []ItemDef       `xml:"ItemDef"`
+	CodeLists      []CodeList      `xml:"CodeList"`
+}
+
+type StudyEventRef struct {
+	StudyEventOID string `xml:"StudyEventOID,attr"`
+	OrderNumber   string `xml:"OrderNumber,attr,omitempty"`
+	Mandatory     string `xml:"Mandatory,attr,omitempty"`
+}
+
+type StudyEventDef struct {
+	OID              string    `xml:"OID,attr"`
+	Name             string    `xml:"Name,attr"`
+	Type             string    `xml:"Type,attr,omitempty"`
+	Repeating        string    `xml:"Repeating,attr,omitempty"`
+	EventName        string    `xml:"https://projectredcap.org EventName,attr,omitempty"`
+	CustomEventLabel string    `xml:"https://projectredcap.org CustomEventLabel,attr,omitempty"`
+	UniqueEventName  string    `xml:"https://projectredcap.org UniqueEventName,attr,omitempty"`
+	ArmNum           string    `xml:"https://projectredcap.org ArmNum,attr,omitempty"`
+	ArmName          string    `xml:"https://projectredcap.org ArmName,attr,omitempty"`
+	DayOffset        string    `xml:"https://projectredcap.org DayOffset,attr,omitempty"`
+	OffsetMin        string    `xml:"https://projectredcap.org OffsetMin,attr,omitempty"`
+	OffsetMax        string    `xml:"https://projectredcap.org OffsetMax,attr,omitempty"`
+	FormRefs         []FormRef `xml:"FormRef"`
+}
+
+type FormRef struct {
+	FormOID     string `xml:"FormOID,attr"`
+	OrderNumber string `xml:"OrderNumber,attr,omitempty"`
+	Mandatory   string `xml:"Mandatory,attr,omitempty"`
+	FormName    string `xml:"https://projectredcap.org FormName,attr,omitempty"`
+}
+
+type FormDef struct {
+	OID           string         `xml:"OID,attr"`
+	Name          string         `xml:"Name,attr"`
+	Repeating     string         `xml:"Repeating,attr,omitempty"`
+	FormName      string         `xml:"https://projectredcap.org FormName,attr,omitempty"`
+	ItemGroupRefs []ItemGroupRef `xml:"ItemGroupRef"`
+}
+
+type ItemGroupRef struct {
+	ItemGroupOID string `xml:"ItemGroupOID,attr"`
+	Mandatory    string `xml:"Mandatory,attr,omitempty"`
+}
+
+type ItemGroupDef struct {
+	OID       string    `xml:"OID,attr"`
+	Name      string    `xml:"Name,attr"`
+	Repeating string    `xml:"Repeating,attr,omitempty"`
+	ItemRefs  []ItemRef `xml:"ItemRef"`
+}
+
+type ItemRef struct {
+	ItemOID   string `xml:"ItemOID,attr"`
+	Mandatory string `xml:"Mandatory,attr,omitempty"`
+	Variable  string `xml:"https://projectredcap.org Variable,attr,omitempty"`
+}
+
+// ItemDef describes a single field. The redcap: attributes carry the parts of
+// the data dictionary ODM has no place for.
+type ItemDef struct {
+	OID                string         `xml:"OID,attr"`
+	Name               string         `xml:"Name,attr"`
+	DataType           string         `xml:"DataType,attr"`
+	Length             string         `xml:"Length,attr,omitempty"`
+	SignificantDigits  string         `xml:"SignificantDigits,attr,omitempty"`
+	Variable           string         `xml:"https://projectredcap.org Variable,attr,omitempty"`
+	FieldType          string         `xml:"https://projectredcap.org FieldType,attr,omitempty"`
+	TextValidationType string         `xml:"https://projectredcap.org TextValidationType,attr,omitempty"`
+	FieldNote          string         `xml:"https://projectredcap.org FieldNote,attr,omitempty"`
+	SectionHeader      string         `xml:"https://projectredcap.org SectionHeader,attr,omitempty"`
+	BranchingLogic     string         `xml:"https://projectredcap.org BranchingLogic,attr,omitempty"`
+	Calculation        string         `xml:"https://projectredcap.org Calculation,attr,omitempty"`
+	Identifier         string         `xml:"https://projectredcap.org Identifier,attr,omitempty"`
+	RequiredField      string         `xml:"https://projectredcap.org RequiredField,attr,omitempty"`
+	FieldAnnotation    string         `xml:"https://projectredcap.org FieldAnnotation,attr,omitempty"`
+	MatrixGroupName    string         `xml:"https://projectredcap.org MatrixGroupName,attr,omitempty"`
+	Other              []xml.Attr     `xml:",any,attr"`
+	Question           string         `xml:"Question>TranslatedText,omitempty"`
+	CodeListRef        *CodeListRef   `xml:"CodeListRef,omitempty"`
+	Extensions         []ODMExtension `xml:",any"`
+}
+
+type CodeListRef struct {
+	CodeListOID string `xml:"CodeListOID,attr"`
+}
+
+type CodeList struct {
+	OID             string         `xml:"OID,attr"`
+	Name            string         `xml:"Name,attr"`
+	DataType        string         `xml:"DataType,attr"`
+	Variable        string         `xml:"https://projectredcap.org Variable,attr,omitempty"`
+	CheckboxChoices string         `xml:"https://projectredcap.org CheckboxChoices,attr,omitempty"`
+	CodeListItems   []CodeListItem `xml:"CodeListItem"`
+}
+
+type CodeListItem struct {
+	CodedValue string `xml:"CodedValue,attr"`
+	Decode     string `xml:"Decode>TranslatedText"`
+}
+
+type ClinicalData struct {
+	StudyOID           string        `xml:"StudyOID,attr"`
+	MetaDataVersionOID string        `xml:"MetaDataVersionOID,attr"`
+	SubjectData        []SubjectData `xml:"SubjectData"`
+}
+
+type SubjectData struct {
+	SubjectKey     string           `xml:"SubjectKey,attr"`
+	RecordIdField  string           `xml:"https://projectredcap.org RecordIdField,attr,omitempty"`
+	StudyEventData []StudyEventData `xml:"StudyEventData"`
+}
+
+type StudyEventData struct {
+	StudyEventOID       string     `xml:"StudyEventOID,attr"`
+	StudyEventRepeatKey string     `xml:"StudyEventRepeatKey,attr,omitempty"`
+	UniqueEventName     string     `xml:"https://projectredcap.org UniqueEventName,attr,omitempty"`
+	FormData            []FormData `xml:"FormData"`
+}
+
+type FormData struct {
+	FormOID       string          `xml:"FormOID,attr"`
+	FormRepeatKey string          `xml:"FormRepeatKey,attr,omitempty"`
+	ItemGroupData []ItemGroupData `xml:"ItemGroupData"`
+}
+
+type ItemGroupData struct {
+	ItemGroupOID         string                 `xml:"ItemGroupOID,attr"`
+	ItemGroupRepeatKey   string                 `xml:"ItemGroupRepeatKey,attr,omitempty"`
+	ItemData             []ItemData             `xml:"ItemData"`
+	ItemDataBase64Binary []ItemDataBase64Binary `xml:"ItemDataBase64Binary"`
+}
+
+type ItemData struct {
+	ItemOID string `xml:"ItemOID,attr"`
+	Value   string `xml:"Value,attr"`
+}
+
+// ItemDataBase64Binary is an uploaded file embedded by ExportProjectXML when
+// ExportFiles is set.
+type ItemDataBase64Binary struct {
+	ItemOID  string `xml:"ItemOID,attr"`
+	DocName  string `xml:"https://projectredcap.org DocName,attr,omitempty"`
+	MimeType string `xml:"https://projectredcap.org MimeType,attr,omitempty"`
+	Value    string `xml:",chardata"`
+}
+
+/*
+	ParseODM parses a CDISC ODM document exported by ExportProjectXML.
+	
+	Args:
+		reader: The XML source.
+	
+	Returns:
+		The parsed document.
+*/
+func ParseODM(reader io.Reader) (*ODM, error) {
+	var odm ODM
+	if err := xml.NewDecoder(reader).Decode(&odm); err != nil {
+		return nil, fmt.Errorf("parsing ODM: %w", err)
+	}
+
+	global := &odm.Study.GlobalVariables
+	for i := range global.Other {
+		global.Other[i].Attrs = withoutNamespaceDeclarations(global.Other[i].Attrs)
+	}
+	if global.DataAccessGroupsGroup != nil {
+		for i := range global.DataAccessGroupsGroup.DataAccessGroups {
+			group := &global.DataAccessGroupsGroup.DataAccessGroups[i]
+			group.Other = withoutNamespaceDeclarations(group.Other)
+		}
+	}
+	if global.SurveysGroup != nil {
+		for i := range global.SurveysGroup.Surveys {
+			survey := &global.SurveysGroup.Surveys[i]
+			survey.Other = withoutNamespaceDeclarations(survey.Other)
+		}
+	}
+	for i := range odm.Study.MetaDataVersion.ItemDefs {
+		item := &odm.Study.MetaDataVersion.ItemDefs[i]
+		item.Other = withoutNamespaceDeclarations(item.Other)
+	}
+	return &odm, nil
+}
+
+// withoutNamespaceDeclarations drops xmlns attributes caught by ",any,attr"
+// fields, which the encoder adds back itself when the document is written.
+func withoutNamespaceDeclarations(attrs []xml.Attr) []xml.Attr {
+	var kept []xml.Attr
+	for _, attr := range attrs {
+		if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+			continue
+		}
+		kept = append(kept, attr)
+	}
+	return kept
+}
+
+/*
+	Write encodes the document as indented XML with an XML declaration. The
+	redcap: namespace is written with a prefix chosen by encoding/xml, which is
+	equivalent but not byte for byte identical to what REDCap produced.
+	
+	Args:
+		w: The writer the XML is written to.
+	
+	Returns:
+		An error if the document could not be encoded.
+*/
+func (o *ODM) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(o); err != nil {
+		return fmt.Errorf("writing ODM: %w", err)
+	}
+	return encoder.Close()
+}
+
+/*
+	ItemDef finds the definition of a field by its variable name.
+	
+	Args:
+		variable: The REDCap field name.
+	
+	Returns:
+		The item definition, or nil if there is none.
+*/
+func (o *ODM) ItemDef(variable string) *ItemDef {
+	for i, item := range o.Study.MetaDataVersion.ItemDefs {
+		if item.Variable == variable || item.OID == variable {
+			return &o.Study.MetaDataVersion.ItemDefs[i]
+		}
+	}
+	return nil
+}
+
+/*
+	CodeList finds a code list by its OID, as referenced from an ItemDef.
+	
+	Args:
+		oid: The code list OID.
+	
+	Returns:
+		The code list, or nil if there is none.
+*/
+func (o *ODM) CodeList(oid string) *CodeList {
+	for i, codeList := range o.Study.MetaDataVersion.CodeLists {
+		if codeList.OID == oid {
+			return &o.Study.MetaDataVersion.CodeLists[i]
+		}
+	}
+	return nil
+}
+
+/*
+	FormDef finds the definition of an instrument by its unique form name.
+	
+	Args:
+		formName: The instrument name, as used in the data dictionary.
+	
+	Returns:
+		The form definition, or nil if there is none.
+*/
+func (o *ODM) FormDef(formName string) *FormDef {
+	for i, form := range o.Study.MetaDataVersion.FormDefs {
+		if form.FormName == formName || form.OID == "Form."+formName {
+			return &o.Study.MetaDataVersion.FormDefs[i]
+		}
+	}
+	return nil
+}
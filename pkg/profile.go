@@ -0,0 +1,285 @@
+package redcap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Config holds named connection profiles, read from a JSON file such as:
+//
+//	{
+//	  "default": "study",
+//	  "profiles": {
+//	    "study": {"url": "https://redcap.example.com/api/", "token_env": "STUDY_TOKEN"},
+//	    "pilot": {"url": "https://redcap.example.com/api/", "token_file": "~/.redcap/pilot.token", "format": "csv"},
+//	    "admin": {"url": "https://redcap.example.com/api/", "token_command": ["pass", "show", "redcap/admin"]}
+//	  }
+//	}
+//
+// Tokens are never stored in the config file itself.
+type Config struct {
+	Default  string             `json:"default"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Profile is a REDCap connection: the API URL, where its token comes from
+// and the response format to use. Exactly one token source must be set.
+type Profile struct {
+	URL          string         `json:"url"`
+	Format       ResponseFormat `json:"format,omitempty"`
+	TokenEnv     string         `json:"token_env,omitempty"`
+	TokenFile    string         `json:"token_file,omitempty"`
+	TokenCommand []string       `json:"token_command,omitempty"`
+}
+
+/*
+	DefaultConfigPath returns where the config file is read from when no
+	path is given: $REDCAP_CONFIG if set, otherwise redcap/config.json in
+	the user's config directory.
+	
+	Args:
+		None
+	
+	Returns:
+		The config file path.
+*/
+func DefaultConfigPath() (string, error) {
+	if path := os.Getenv("REDCAP_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "redcap", "config.json"), nil
+}
+
+/*
+	LoadConfig reads a config file and checks its profiles.
+	
+	Args:
+		path: The config file, or "" for DefaultConfigPath.
+	
+	Returns:
+		The config.
+*/
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		var err error
+		if path, err = DefaultConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	for _, name := range config.Names() {
+		if err := config.Profiles[name].validate(); err != nil {
+			return nil, fmt.Errorf("%s: profile %q: %w", path, name, err)
+		}
+	}
+	if config.Default != "" {
+		if _, ok := config.Profiles[config.Default]; !ok {
+			return nil, fmt.Errorf("%s: default profile %q is not defined", path, config.Default)
+		}
+	}
+	return &config, nil
+}
+
+// Names returns the profile names in order.
+func (c *Config) Names() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*
+	Profile looks up a profile by name.
+	
+	Args:
+		name: The profile name, or "" for the default profile.
+	
+	Returns:
+		The profile.
+*/
+func (c *Config) Profile(name string) (Profile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return Profile{}, fmt.Errorf("no profile given and no default profile set")
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("profile %q is not defined", name)
+	}
+	return profile, nil
+}
+
+/*
+	Client builds a client for a profile, reading its token.
+	
+	Args:
+		name: The profile name, or "" for the default profile.
+	
+	Returns:
+		A client ready to use.
+*/
+func (c *Config) Client(name string) (RedCapClient, error) {
+	profile, err := c.Profile(name)
+	if err != nil {
+		return RedCapClient{}, err
+	}
+	return profile.Client()
+}
+
+func (p Profile) validate() error {
+	if p.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	switch p.Format {
+	case "", JSON, CSV, XML:
+	default:
+		return fmt.Errorf("unknown format %q", p.Format)
+	}
+	sources := 0
+	for _, set := range []bool{p.TokenEnv != "", p.TokenFile != "", len(p.TokenCommand) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("exactly one of token_env, token_file or token_command is required")
+	}
+	return nil
+}
+
+/*
+	TokenSource describes where the profile's token is read from, without
+	reading it.
+	
+	Args:
+		None
+	
+	Returns:
+		A description such as "env STUDY_TOKEN".
+*/
+func (p Profile) TokenSource() string {
+	switch {
+	case p.TokenEnv != "":
+		return "env " + p.TokenEnv
+	case p.TokenFile != "":
+		return "file " + p.TokenFile
+	case len(p.TokenCommand) > 0:
+		return "command " + p.TokenCommand[0]
+	}
+	return "none"
+}
+
+/*
+	Token reads the profile's API token from its environment variable, its
+	token file or its credential helper command. Token files must not be
+	readable by group or others. A credential helper is run without a shell
+	and must print the token on standard output.
+	
+	Args:
+		None
+	
+	Returns:
+		The API token.
+*/
+func (p Profile) Token() (string, error) {
+	var token string
+	switch {
+	case p.TokenEnv != "":
+		token = os.Getenv(p.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("environment variable %s is not set", p.TokenEnv)
+		}
+	case p.TokenFile != "":
+		path, err := expandHome(p.TokenFile)
+		if err != nil {
+			return "", err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+			return "", fmt.Errorf("token file %s has permissions %v; it must only be readable by its owner (chmod 600)", path, info.Mode().Perm())
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		token = string(content)
+	case len(p.TokenCommand) > 0:
+		var stderr bytes.Buffer
+		cmd := exec.Command(p.TokenCommand[0], p.TokenCommand[1:]...)
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("credential helper %s: %w: %s", p.TokenCommand[0], err, strings.TrimSpace(stderr.String()))
+		}
+		token = string(output)
+	default:
+		return "", fmt.Errorf("the profile has no token source")
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("the token from %s is empty", p.TokenSource())
+	}
+	return token, nil
+}
+
+/*
+	Client builds a client for the profile, reading its token.
+	
+	Args:
+		None
+	
+	Returns:
+		A client using the profile's format, or JSON if it has none.
+*/
+func (p Profile) Client() (RedCapClient, error) {
+	token, err := p.Token()
+	if err != nil {
+		return RedCapClient{}, err
+	}
+	format := p.Format
+	if format == "" {
+		format = JSON
+	}
+	return RedCapClient{URL: p.URL, Token: token, ResponseFormat: format}, nil
+}
+
+// expandHome replaces a leading ~ with the user's home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[1:]), nil
+}
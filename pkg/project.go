@@ -0,0 +1,188 @@
+package redcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type ProjectPurpose int
+
+const (
+	PurposePractice ProjectPurpose = iota
+	PurposeOther
+	PurposeResearch
+	PurposeQualityImprovement
+	PurposeOperationalSupport
+)
+
+// UnmarshalJSON accepts the purpose as a number or, as some REDCap versions
+// send it, a quoted number.
+func (p *ProjectPurpose) UnmarshalJSON(data []byte) error {
+	purpose, err := strconv.Atoi(strings.Trim(string(data), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid project purpose %s", data)
+	}
+	*p = ProjectPurpose(purpose)
+	return nil
+}
+
+// NewProject holds the settings of a project created by ImportProject. For
+// PurposeResearch, PurposeOther lists the research categories as REDCap's
+// comma separated codes.
+type NewProject struct {
+	ProjectTitle               string
+	Purpose                    ProjectPurpose
+	PurposeOther               string
+	ProjectNotes               string
+	IsLongitudinal             bool
+	SurveysEnabled             bool
+	RecordAutonumberingEnabled bool
+}
+
+func (p NewProject) validate() error {
+	if p.ProjectTitle == "" {
+		return fmt.Errorf("project title is required")
+	}
+	if p.Purpose < PurposePractice || p.Purpose > PurposeOperationalSupport {
+		return fmt.Errorf("invalid project purpose %d", p.Purpose)
+	}
+	if (p.Purpose == PurposeOther || p.Purpose == PurposeResearch) && p.PurposeOther == "" {
+		return fmt.Errorf("purpose_other is required when the purpose is other or research")
+	}
+	return nil
+}
+
+func (p NewProject) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"project_title":                p.ProjectTitle,
+		"purpose":                      int(p.Purpose),
+		"purpose_other":                p.PurposeOther,
+		"project_notes":                p.ProjectNotes,
+		"is_longitudinal":              boolToInt(p.IsLongitudinal),
+		"surveys_enabled":              boolToInt(p.SurveysEnabled),
+		"record_autonumbering_enabled": boolToInt(p.RecordAutonumberingEnabled),
+	})
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Flag is a REDCap yes/no setting, sent and received as 0 or 1.
+type Flag bool
+
+func (f Flag) MarshalJSON() ([]byte, error) {
+	return json.Marshal(boolToInt(bool(f)))
+}
+
+func (f *Flag) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "1", "true":
+		*f = true
+	case "0", "false", "", "null":
+		*f = false
+	default:
+		return fmt.Errorf("invalid flag %s", data)
+	}
+	return nil
+}
+
+// ProjectInfo is the project information returned by ExportProjectInfo.
+type ProjectInfo struct {
+	ProjectID                       int            `json:"project_id"`
+	ProjectTitle                    string         `json:"project_title"`
+	CreationTime                    string         `json:"creation_time"`
+	ProductionTime                  string         `json:"production_time"`
+	InProduction                    Flag           `json:"in_production"`
+	ProjectLanguage                 string         `json:"project_language"`
+	Purpose                         ProjectPurpose `json:"purpose"`
+	PurposeOther                    string         `json:"purpose_other"`
+	ProjectNotes                    string         `json:"project_notes"`
+	CustomRecordLabel               string         `json:"custom_record_label"`
+	SecondaryUniqueField            string         `json:"secondary_unique_field"`
+	IsLongitudinal                  Flag           `json:"is_longitudinal"`
+	HasRepeatingInstrumentsOrEvents Flag           `json:"has_repeating_instruments_or_events"`
+	SurveysEnabled                  Flag           `json:"surveys_enabled"`
+	SchedulingEnabled               Flag           `json:"scheduling_enabled"`
+	RecordAutonumberingEnabled      Flag           `json:"record_autonumbering_enabled"`
+	RandomizationEnabled            Flag           `json:"randomization_enabled"`
+	DDPEnabled                      Flag           `json:"ddp_enabled"`
+	ProjectIRBNumber                string         `json:"project_irb_number"`
+	ProjectGrantNumber              string         `json:"project_grant_number"`
+	ProjectPIFirstname              string         `json:"project_pi_firstname"`
+	ProjectPILastname               string         `json:"project_pi_lastname"`
+	DisplayTodayNowButton           Flag           `json:"display_today_now_button"`
+	MissingDataCodes                string         `json:"missing_data_codes"`
+	ExternalModules                 string         `json:"external_modules"`
+	BypassBranchingEraseFieldPrompt Flag           `json:"bypass_branching_erase_field_prompt"`
+}
+
+// importableProjectSettings are the ProjectInfo keys REDCap accepts on import.
+var importableProjectSettings = []string{
+	"project_title",
+	"project_language",
+	"purpose",
+	"purpose_other",
+	"project_notes",
+	"custom_record_label",
+	"secondary_unique_field",
+	"is_longitudinal",
+	"surveys_enabled",
+	"scheduling_enabled",
+	"record_autonumbering_enabled",
+	"randomization_enabled",
+	"project_irb_number",
+	"project_grant_number",
+	"project_pi_firstname",
+	"project_pi_lastname",
+	"display_today_now_button",
+	"missing_data_codes",
+	"bypass_branching_erase_field_prompt",
+}
+
+/*
+	ChangedSettings lists the importable settings that differ between two
+	exports of the project information.
+	
+	Args:
+		original: The settings as exported.
+		updated: The settings with the wanted changes applied.
+	
+	Returns:
+		The changed settings keyed by their REDCap name.
+*/
+func ChangedSettings(original ProjectInfo, updated ProjectInfo) (map[string]interface{}, error) {
+	before, err := settingsMap(original)
+	if err != nil {
+		return nil, err
+	}
+	after, err := settingsMap(updated)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make(map[string]interface{})
+	for _, key := range importableProjectSettings {
+		if fmt.Sprint(before[key]) != fmt.Sprint(after[key]) {
+			changed[key] = after[key]
+		}
+	}
+	return changed, nil
+}
+
+func settingsMap(info ProjectInfo) (map[string]interface{}, error) {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(encoded, &settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
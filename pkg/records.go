@@ -0,0 +1,224 @@
+package redcap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DateRangeFormat is the layout REDCap expects for dateRangeBegin and
+// dateRangeEnd.
+const DateRangeFormat = "2006-01-02 15:04:05"
+
+// RecordsOptions selects what ExportRecords returns. Records are always
+// exported flat, one row per record, event and repeating instance.
+// DateRangeBegin and DateRangeEnd limit the export to records created or
+// modified in that window; they are read in the REDCap server's time zone.
+type RecordsOptions struct {
+	Records                []string
+	Fields                 []string
+	Forms                  []string
+	Events                 []string
+	RawOrLabel             string
+	FilterLogic            string
+	ExportSurveyFields     bool
+	ExportDataAccessGroups bool
+	DateRangeBegin         time.Time
+	DateRangeEnd           time.Time
+}
+
+// Record is a single row of a flat record export, keyed by field name.
+type Record map[string]string
+
+func (o RecordsOptions) values(token string, format ResponseFormat) url.Values {
+	values := url.Values{
+		"token":        {token},
+		"content":      {"record"},
+		"format":       {string(format)},
+		"type":         {"flat"},
+		"returnFormat": {"json"},
+	}
+	setArray(values, "records", o.Records)
+	setArray(values, "fields", o.Fields)
+	setArray(values, "forms", o.Forms)
+	setArray(values, "events", o.Events)
+	if o.RawOrLabel != "" {
+		values.Set("rawOrLabel", o.RawOrLabel)
+	}
+	if o.FilterLogic != "" {
+		values.Set("filterLogic", o.FilterLogic)
+	}
+	if o.ExportSurveyFields {
+		values.Set("exportSurveyFields", "true")
+	}
+	if o.ExportDataAccessGroups {
+		values.Set("exportDataAccessGroups", "true")
+	}
+	if !o.DateRangeBegin.IsZero() {
+		values.Set("dateRangeBegin", o.DateRangeBegin.Format(DateRangeFormat))
+	}
+	if !o.DateRangeEnd.IsZero() {
+		values.Set("dateRangeEnd", o.DateRangeEnd.Format(DateRangeFormat))
+	}
+	return values
+}
+
+/*
+	ExportRecordRows exports records as parsed rows, whatever the client's
+	response format. The CSV export is used so the columns keep the order of
+	the data dictionary.
+	
+	Args:
+		options: The records, fields, forms and events to export.
+	
+	Returns:
+		The column names in export order and the exported rows.
+*/
+func (r *RedCapClient) ExportRecordRows(options RecordsOptions) ([]string, []Record, error) {
+	return r.exportRecordsCSV(options.values(r.Token, CSV))
+}
+
+// DeleteRecordsOptions narrows what DeleteRecords removes. Either Confirm or
+// DryRun must be set: Confirm deletes, DryRun only reports what would go.
+type DeleteRecordsOptions struct {
+	Arm            string
+	Instrument     string
+	Event          string
+	RepeatInstance int
+	DeleteLogging  bool
+	Confirm        bool
+	DryRun         bool
+}
+
+// RecordDeletion is a row of data that DeleteRecords would remove.
+type RecordDeletion struct {
+	Record           string
+	Event            string
+	RepeatInstrument string
+	RepeatInstance   string
+}
+
+func (o DeleteRecordsOptions) validate() error {
+	if o.Confirm == o.DryRun {
+		return fmt.Errorf("DeleteRecords requires exactly one of Confirm or DryRun")
+	}
+	if o.RepeatInstance > 0 && o.Instrument == "" {
+		return fmt.Errorf("a repeat instance can only be deleted together with its instrument")
+	}
+	return nil
+}
+
+// planDeleteRecords exports the rows matching a deletion so a dry run can
+// report them. The CSV export is used because its first column is always the
+// record ID field, whatever the project calls it. REDCap exports a row for
+// every record in a requested form's events, so when an instrument is given
+// only rows with data in one of its fields are kept, read from the data
+// dictionary as SplitByInstrument does.
+func (r *RedCapClient) planDeleteRecords(records []string, options DeleteRecordsOptions) ([]RecordDeletion, error) {
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"record"},
+		"format":       {"csv"},
+		"type":         {"flat"},
+		"returnFormat": {"json"},
+	}
+	setArray(formating, "records", records)
+	if options.Instrument != "" {
+		setArray(formating, "forms", []string{options.Instrument})
+	}
+	if options.Event != "" {
+		setArray(formating, "events", []string{options.Event})
+	}
+
+	header, rows, err := r.exportRecordsCSV(formating)
+	if err != nil {
+		return nil, err
+	}
+	if options.Arm != "" && len(header) > 0 && !slices.Contains(header, EventNameColumn) {
+		return nil, fmt.Errorf("arm %s: the project is not longitudinal", options.Arm)
+	}
+	var formColumns []exportColumn
+	if options.Instrument != "" && len(rows) > 0 {
+		dictionary, err := r.ExportDataDictionary()
+		if err != nil {
+			return nil, err
+		}
+		formColumns = dictionary.formColumns(options.Instrument)
+	}
+
+	var plan []RecordDeletion
+	for _, row := range rows {
+		deletion := RecordDeletion{
+			Record:           row[header[0]],
+			Event:            row["redcap_event_name"],
+			RepeatInstrument: row["redcap_repeat_instrument"],
+			RepeatInstance:   row["redcap_repeat_instance"],
+		}
+		if options.Arm != "" && !strings.HasSuffix(deletion.Event, "_arm_"+options.Arm) {
+			continue
+		}
+		if options.RepeatInstance > 0 && deletion.RepeatInstance != fmt.Sprint(options.RepeatInstance) {
+			continue
+		}
+		if options.Instrument != "" && !hasData(row, formColumns) {
+			continue
+		}
+		plan = append(plan, deletion)
+	}
+	return plan, nil
+}
+
+// exportRecordsCSV posts a CSV record export and returns its header and rows.
+func (r *RedCapClient) exportRecordsCSV(formating url.Values) ([]string, []Record, error) {
+	client := r.httpClient()
+
+	data := strings.NewReader(formating.Encode())
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("exporting records: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "text/csv")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("exporting records: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, newAPIError(resp)
+	}
+
+	reader := csv.NewReader(resp.Body)
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading records: %w", err)
+	}
+
+	var rows []Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading records: %w", err)
+		}
+		row := make(Record, len(header))
+		for i, value := range record {
+			row[header[i]] = value
+		}
+		rows = append(rows, row)
+	}
+	return header, rows, nil
+}
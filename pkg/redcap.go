@@ -1,12 +1,15 @@
 package redcap
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
-	"time"
 )
 
 type ResponseFormat string
@@ -31,6 +34,17 @@ type RedCapClient struct {
 	Token          string
 	URL            string
 	ResponseFormat ResponseFormat
+	// HTTPClient sends the requests. A plain http.Client is used when nil;
+	// set one to add timeouts or a custom transport such as a recorder.
+	HTTPClient *http.Client
+}
+
+// httpClient returns the client requests are sent with.
+func (r RedCapClient) httpClient() *http.Client {
+	if r.HTTPClient != nil {
+		return r.HTTPClient
+	}
+	return &http.Client{}
 }
 
 type RedCapResponse struct {
@@ -38,40 +52,104 @@ type RedCapResponse struct {
 	StatusCode int
 }
 
+// APIError is returned when REDCap answers a request with a non-200 status.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("redcap: %d: %s", e.StatusCode, e.Message)
+}
+
+// PDFOptions selects what ExportInstrumentPDF renders.
+type PDFOptions struct {
+	Record         string
+	Event          string
+	Instrument     string
+	RepeatInstance int
+	AllRecords     bool
+	CompactDisplay bool
+}
+
+// ProjectXMLOptions selects what ExportProjectXML includes in the ODM document.
+// ExportFiles embeds uploaded documents as base64, which can make the export large.
+type ProjectXMLOptions struct {
+	ReturnMetadataOnly     bool
+	Records                []string
+	Fields                 []string
+	Events                 []string
+	FilterLogic            string
+	ExportSurveyFields     bool
+	ExportDataAccessGroups bool
+	ExportFiles            bool
+}
+
+// newAPIError reads the error message REDCap sends back as JSON, XML or plain text.
+func newAPIError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	message := strings.TrimSpace(string(body))
+
+	var jsonError struct {
+		Error string `json:"error"`
+	}
+	var xmlError struct {
+		Error string `xml:"error"`
+	}
+	if json.Unmarshal(body, &jsonError) == nil && jsonError.Error != "" {
+		message = jsonError.Error
+	} else if xml.Unmarshal(body, &xmlError) == nil && xmlError.Error != "" {
+		message = xmlError.Error
+	}
+	return &APIError{StatusCode: resp.StatusCode, Message: message}
+}
+
 func parameterBuilder(parameters []string, builder BuilderType) string {
-	var formating string
+	var name string
 	switch builder {
 	case Dags:
-		for i, v := range parameters {
-			formating += fmt.Sprintf("dags[%d]=%s", i, v)
-		}
-		return formating
+		name = "dags"
 	case Arms:
-		for i, v := range parameters {
-			formating += fmt.Sprintf("[%d]=%s", i, v)
-		}
-		return formating
+		name = "arms"
 	case Events:
-		for i, v := range parameters {
-			formating += fmt.Sprintf("events[%d]=%s", i, v)
-		}
-		return formating
+		name = "events"
 	case UserRoles:
-		for i, v := range parameters {
-			formating += fmt.Sprintf("roles[%d]=%s", i, v)
-		}
-		return formating
+		name = "roles"
 	case Users:
-		for i, v := range parameters {
-			formating += fmt.Sprintf("users[%d]=%s", i, v)
-		}
-		return formating
-	default:
-		for i, v := range parameters {
-			formating += fmt.Sprintf("[%d]=%s", i, v)
-		}
-		return formating
+		name = "users"
+	}
+
+	formating := make([]string, len(parameters))
+	for i, v := range parameters {
+		formating[i] = fmt.Sprintf("%s[%d]=%s", name, i, url.QueryEscape(v))
+	}
+	return strings.Join(formating, "&")
+}
+
+// setArray adds items to a request using REDCap's name[0]=a&name[1]=b array syntax.
+func setArray(values url.Values, name string, items []string) {
+	for i, item := range items {
+		values.Set(fmt.Sprintf("%s[%d]", name, i), item)
+	}
+}
+
+// fileParameters builds the request shared by the file import, export and delete actions.
+func fileParameters(token string, action string, record string, field string, event string, repeatInstance int) url.Values {
+	values := url.Values{
+		"token":        {token},
+		"content":      {"file"},
+		"action":       {action},
+		"record":       {record},
+		"field":        {field},
+		"returnFormat": {"json"},
+	}
+	if event != "" {
+		values.Set("event", event)
+	}
+	if repeatInstance > 0 {
+		values.Set("repeat_instance", strconv.Itoa(repeatInstance))
 	}
+	return values
 }
 
 /*
@@ -85,10 +163,10 @@ func parameterBuilder(parameters []string, builder BuilderType) string {
 */
 func (r *RedCapClient) DeleteArms(arms []string) ([]byte, error) {
 	
-	client := &http.Client{}
+	client := r.httpClient()
 	var builderType = BuilderType("arms")
 	params := parameterBuilder(arms, builderType)
-	formating := fmt.Sprintf("token=%s&content=arm&action=delete&format=%s&arms=%s", r.Token, r.ResponseFormat, params)
+	formating := fmt.Sprintf("token=%s&content=arm&action=delete&format=%s&%s", r.Token, r.ResponseFormat, params)
 
 	data := strings.NewReader(formating)
 	req, err := http.NewRequest("POST", r.URL, data)
@@ -104,6 +182,10 @@ func (r *RedCapClient) DeleteArms(arms []string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal("Error reading response body: ", err)
@@ -123,7 +205,7 @@ func (r *RedCapClient) DeleteArms(arms []string) ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) DeleteDags(dags []string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	var builderType = BuilderType("dags")
 	params := parameterBuilder(dags, builderType)
 	formating := fmt.Sprintf("token=%s&content=dag&action=delete&format=%s&%s", r.Token, r.ResponseFormat, params)
@@ -143,6 +225,10 @@ func (r *RedCapClient) DeleteDags(dags []string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -162,7 +248,7 @@ func (r *RedCapClient) DeleteDags(dags []string) ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) DeleteEvents(events []string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	var builderType = BuilderType("events")
 	params := parameterBuilder(events, builderType)
 	formating := fmt.Sprintf("token=%s&content=event&action=delete&format=%s&%s", r.Token, r.ResponseFormat, params)
@@ -182,6 +268,10 @@ func (r *RedCapClient) DeleteEvents(events []string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -197,16 +287,17 @@ func (r *RedCapClient) DeleteEvents(events []string) ([]byte, error) {
 		record: The record ID of the file to delete.
 		field: The field name of the file to delete.
 		event: The event name of the file to delete.
+		repeatInstance: The repeating instance of the file, or 0 if the
+			field is not on a repeating instrument or event.
 	
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
-func (r *RedCapClient) DeleteFile(record string, field string, event string) ([]byte, error) {
-	client := &http.Client{}
-	// TODO: Response format is not being used? Check API docs!
-	formating := fmt.Sprintf("token=%s&content=file&action=delete&record=%s&field=%s&event=%s", r.Token, record, field, event)
+func (r *RedCapClient) DeleteFile(record string, field string, event string, repeatInstance int) ([]byte, error) {
+	client := r.httpClient()
+	formating := fileParameters(r.Token, "delete", record, field, event, repeatInstance)
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -221,6 +312,10 @@ func (r *RedCapClient) DeleteFile(record string, field string, event string) ([]
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -230,23 +325,64 @@ func (r *RedCapClient) DeleteFile(record string, field string, event string) ([]
 }
 
 /*
-	DeleteRecords deletes records from a REDCap project.
+	DeleteRecords deletes records from a REDCap project. Set options.DryRun to
+	see what would be deleted first; nothing is removed unless
+	options.Confirm is set.
 	
 	Args:
-		record: The record ID of the file to delete.
-		field: The field name of the file to delete.
-		event: The event name of the file to delete.
+		records: The record IDs to delete.
+		options: The arm, instrument, event and repeat instance to limit the
+			deletion to, and whether to confirm it or only do a dry run.
 	
 	Returns:
-		A byte slice containing the response from the REDCap API.
+		The number of records deleted, or that would be deleted in a dry run,
+		and for a dry run the rows that would be removed.
 */
-func (r *RedCapClient) DeleteRecords(records string, arms string, instrument string) ([]byte, error) {
-	// TODO: For simplicity implementing, we can for now just use strings for the parameters
-	// TODO: We will need to come back and implement a **kwargs, (I think it's ...) in Go for this
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&action=delete&content=record&records[0]=%s&arm=%s&instrument=%s&event=visit_1_arm_1&returnformat=%s", r.Token, records, arms, instrument, r.ResponseFormat)
-	// TODO: ^ The problem is for deleting multiple records or multiple arms, we need to implement a loop to iterate over the parameters
-	data := strings.NewReader(formating)
+func (r *RedCapClient) DeleteRecords(records []string, options DeleteRecordsOptions) (int, []RecordDeletion, error) {
+	if len(records) == 0 {
+		return 0, nil, fmt.Errorf("no records to delete")
+	}
+	if err := options.validate(); err != nil {
+		return 0, nil, err
+	}
+
+	if options.DryRun {
+		plan, err := r.planDeleteRecords(records, options)
+		if err != nil {
+			return 0, nil, err
+		}
+		matched := make(map[string]bool)
+		for _, deletion := range plan {
+			matched[deletion.Record] = true
+		}
+		return len(matched), plan, nil
+	}
+
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"action":       {"delete"},
+		"content":      {"record"},
+		"returnFormat": {"json"},
+	}
+	setArray(formating, "records", records)
+	if options.Arm != "" {
+		formating.Set("arm", options.Arm)
+	}
+	if options.Instrument != "" {
+		formating.Set("instrument", options.Instrument)
+	}
+	if options.Event != "" {
+		formating.Set("event", options.Event)
+	}
+	if options.RepeatInstance > 0 {
+		formating.Set("repeat_instance", strconv.Itoa(options.RepeatInstance))
+	}
+	if options.DeleteLogging {
+		formating.Set("delete_logging", "1")
+	}
+
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -261,12 +397,20 @@ func (r *RedCapClient) DeleteRecords(records string, arms string, instrument str
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return 0, nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	
-	return bodyText, nil
+
+	deleted, err := strconv.Atoi(strings.TrimSpace(string(bodyText)))
+	if err != nil {
+		return 0, nil, fmt.Errorf("unexpected delete response %q", bodyText)
+	}
+	return deleted, nil, nil
 }
 
 /*
@@ -279,7 +423,7 @@ func (r *RedCapClient) DeleteRecords(records string, arms string, instrument str
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) DeleteUserRoles(roles []string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	var builderType = BuilderType("userRoles")
 	params := parameterBuilder(roles, builderType)
 	formating := fmt.Sprintf("token=%s&content=userRole&action=delete&format=%s&%s", r.Token, r.ResponseFormat, params)
@@ -299,6 +443,10 @@ func (r *RedCapClient) DeleteUserRoles(roles []string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -317,7 +465,7 @@ func (r *RedCapClient) DeleteUserRoles(roles []string) ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) DeleteUsers(users []string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	var builderType = BuilderType("users")
 	params := parameterBuilder(users, builderType)
 	formating := fmt.Sprintf("token=%s&content=user&action=delete&format=%s&%s", r.Token, r.ResponseFormat, params)
@@ -337,6 +485,10 @@ func (r *RedCapClient) DeleteUsers(users []string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -355,7 +507,7 @@ func (r *RedCapClient) DeleteUsers(users []string) ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportArms() ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=arm&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -373,6 +525,10 @@ func (r *RedCapClient) ExportArms() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -381,6 +537,53 @@ func (r *RedCapClient) ExportArms() ([]byte, error) {
 	return bodyText, nil
 }
 
+/*
+	ExportContent exports a list that REDCap returns for a content name
+	alone, such as metadata, arm, event, formEventMapping, dag,
+	userDagMapping, user, userRole or userRoleMapping.
+	
+	Args:
+		content: The REDCap content name.
+		format: The format to export, JSON, CSV or XML.
+	
+	Returns:
+		A byte slice containing the response from the REDCap API.
+*/
+func (r *RedCapClient) ExportContent(content string, format ResponseFormat) ([]byte, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {content},
+		"format":       {string(format)},
+		"returnFormat": {"json"},
+	}
+
+	req, err := http.NewRequest("POST", r.URL, strings.NewReader(formating.Encode()))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	bodyText, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return bodyText, nil
+}
+
 /*
 	ExportDags exports data access groups from a REDCap project.
 	
@@ -391,7 +594,7 @@ func (r *RedCapClient) ExportArms() ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportDags() ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=dag&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -409,6 +612,10 @@ func (r *RedCapClient) ExportDags() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -428,7 +635,7 @@ func (r *RedCapClient) ExportDags() ([]byte, error) {
 */
 func (r *RedCapClient) ExportEvents() ([]byte, error) {
 	// TODO: This looks like it will fail? What does it mean by `arms=`?
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=event&format=%s&arms=", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -446,6 +653,10 @@ func (r *RedCapClient) ExportEvents() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -464,7 +675,7 @@ func (r *RedCapClient) ExportEvents() ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportFieldNames(feild string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=exportFieldNames&format=%s&field=%s", r.Token, r.ResponseFormat, feild)
 
 	data := strings.NewReader(formating)
@@ -482,6 +693,10 @@ func (r *RedCapClient) ExportFieldNames(feild string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -497,16 +712,17 @@ func (r *RedCapClient) ExportFieldNames(feild string) ([]byte, error) {
 		record: The record ID of the file to export.
 		field: The field name of the file to export.
 		event: The event name of the file to export.
+		repeatInstance: The repeating instance of the file, or 0 if the
+			field is not on a repeating instrument or event.
 	
 	Returns:
-		A byte slice containing the response from the REDCap API.
+		A byte slice containing the contents of the file.
 */
-func (r *RedCapClient) ExportFile(record string, feild string, event string) ([]byte, error) {
-	client := &http.Client{}
-	// TODO: Response format is not being used? Check API docs!
-	formating := fmt.Sprintf("token=%s&content=file&action=export&record=%s&field=%s&event=%s", r.Token, record, feild, event)
+func (r *RedCapClient) ExportFile(record string, feild string, event string, repeatInstance int) ([]byte, error) {
+	client := r.httpClient()
+	formating := fileParameters(r.Token, "export", record, feild, event, repeatInstance)
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -521,6 +737,10 @@ func (r *RedCapClient) ExportFile(record string, feild string, event string) ([]
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -539,7 +759,7 @@ func (r *RedCapClient) ExportFile(record string, feild string, event string) ([]
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportInstrumentEventMaps() ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=formEventMapping&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -557,6 +777,10 @@ func (r *RedCapClient) ExportInstrumentEventMaps() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -567,26 +791,51 @@ func (r *RedCapClient) ExportInstrumentEventMaps() ([]byte, error) {
 
 
 /*
-	ExportInstrumentPDF exports instrument PDFs from a REDCap project.
+	ExportInstrumentPDF exports instrument PDFs from a REDCap project and
+	streams them to the given writer.
 	
 	Args:
-		None
+		w: The writer the PDF is copied to.
+		options: The record, event, instrument and display options. The zero
+			value exports a blank PDF of every instrument.
 	
 	Returns:
-		A byte slice containing the response from the REDCap API.
+		The number of bytes written.
 */
-func (r *RedCapClient) ExportInstrumentPDF() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=pdf&format=%s", r.Token, r.ResponseFormat)
+func (r *RedCapClient) ExportInstrumentPDF(w io.Writer, options PDFOptions) (int64, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"pdf"},
+		"returnFormat": {string(r.ResponseFormat)},
+	}
+	if options.Record != "" {
+		formating.Set("record", options.Record)
+	}
+	if options.Event != "" {
+		formating.Set("event", options.Event)
+	}
+	if options.Instrument != "" {
+		formating.Set("instrument", options.Instrument)
+	}
+	if options.RepeatInstance > 0 {
+		formating.Set("repeat_instance", strconv.Itoa(options.RepeatInstance))
+	}
+	if options.AllRecords {
+		formating.Set("allRecords", "true")
+	}
+	if options.CompactDisplay {
+		formating.Set("compactDisplay", "true")
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept", "application/pdf")
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Fatal(err)
@@ -594,14 +843,12 @@ func (r *RedCapClient) ExportInstrumentPDF() ([]byte, error) {
 
 	defer resp.Body.Close()
 
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(resp)
 	}
-	
-	return bodyText, nil
-}
 
+	return io.Copy(w, resp.Body)
+}
 
 /*
 	ExportInstruments exports instruments from a REDCap project.
@@ -613,7 +860,7 @@ func (r *RedCapClient) ExportInstrumentPDF() ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportInstruments() ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=instrument&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -631,11 +878,14 @@ func (r *RedCapClient) ExportInstruments() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
 	return bodyText, nil
 }
 
@@ -644,40 +894,65 @@ func (r *RedCapClient) ExportInstruments() ([]byte, error) {
 	ExportLogging exports logging from a REDCap project.
 	
 	Args:
-		startTime: The start time of the log.
-		endTime: The end time of the log.
+		options: The log type, user, record, DAG and time range to filter by.
 	
 	Returns:
-		A byte slice containing the response from the REDCap API.
+		The matching log entries.
 */
-func (r *RedCapClient) ExportLogging(startTime time.Time, endTime time.Time) ([]byte, error) {
-	// TODO: COME BACK TO THIS. The logType ...string might not be the best way to handle this
-	// TODO: IE: logType ...string, user ...string, record ...string
-	// TODO: time.Time also needs to match the format of 10/06/2020 17:37
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=log&format=%s&logtype=&user=&record=&beginTime=%s&endTime=%s", r.Token, r.ResponseFormat, startTime, endTime)
+func (r *RedCapClient) ExportLogging(options LoggingOptions) ([]LogEntry, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"log"},
+		"format":       {"json"},
+		"returnFormat": {"json"},
+	}
+	if options.LogType != "" {
+		if !options.LogType.valid() {
+			return nil, fmt.Errorf("invalid log type %q", options.LogType)
+		}
+		formating.Set("logtype", string(options.LogType))
+	}
+	if options.User != "" {
+		formating.Set("user", options.User)
+	}
+	if options.Record != "" {
+		formating.Set("record", options.Record)
+	}
+	if options.Dag != "" {
+		formating.Set("dag", options.Dag)
+	}
+	if !options.BeginTime.IsZero() {
+		formating.Set("beginTime", options.BeginTime.Format(LoggingTimeFormat))
+	}
+	if !options.EndTime.IsZero() {
+		formating.Set("endTime", options.EndTime.Format(LoggingTimeFormat))
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("exporting logging: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("exporting logging: %w", err)
 	}
 
 	defer resp.Body.Close()
 
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
 	}
-	
-	return bodyText, nil
+
+	var entries []LogEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decoding logging: %w", err)
+	}
+	return entries, nil
 }
 
 /*
@@ -690,7 +965,7 @@ func (r *RedCapClient) ExportLogging(startTime time.Time, endTime time.Time) ([]
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportMetadata() ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=metadata&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -708,6 +983,10 @@ func (r *RedCapClient) ExportMetadata() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -717,28 +996,43 @@ func (r *RedCapClient) ExportMetadata() ([]byte, error) {
 }
 
 /*
-	ExportProjectXML exports project XML from a REDCap project.
+	ExportProjectXML exports the project as a CDISC ODM XML document and
+	streams it to the given writer.
 	
 	Args:
-		startTime: The start time of the log.
-		endTime: The end time of the log.
+		w: The writer the XML is copied to.
+		options: What to include in the document. The zero value exports the
+			metadata and all records without survey fields, DAGs or files.
 	
 	Returns:
-		A byte slice containing the response from the REDCap API.
+		The number of bytes written.
 */
-func (r *RedCapClient) ExportProjectXML() ([]byte, error) {
-	client := &http.Client{}
-	// TODO: Right now we are not going to pass any additional parameters, we will have to come back to this.
-	formating := fmt.Sprintf("token=%s&content=project_xml&returnMetadataOnly=false&exportSurveyFields=false&exportDataAccessGroups=false&returnformat=%s", r.Token, r.ResponseFormat)
-
-	data := strings.NewReader(formating)
+func (r *RedCapClient) ExportProjectXML(w io.Writer, options ProjectXMLOptions) (int64, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":                  {r.Token},
+		"content":                {"project_xml"},
+		"returnMetadataOnly":     {strconv.FormatBool(options.ReturnMetadataOnly)},
+		"exportSurveyFields":     {strconv.FormatBool(options.ExportSurveyFields)},
+		"exportDataAccessGroups": {strconv.FormatBool(options.ExportDataAccessGroups)},
+		"exportFiles":            {strconv.FormatBool(options.ExportFiles)},
+		"returnFormat":           {string(r.ResponseFormat)},
+	}
+	setArray(formating, "records", options.Records)
+	setArray(formating, "fields", options.Fields)
+	setArray(formating, "events", options.Events)
+	if options.FilterLogic != "" {
+		formating.Set("filterLogic", options.FilterLogic)
+	}
+
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept", "application/xml")
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Fatal(err)
@@ -746,13 +1040,13 @@ func (r *RedCapClient) ExportProjectXML() ([]byte, error) {
 
 	defer resp.Body.Close()
 
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(resp)
 	}
-	
-	return bodyText, nil
+
+	return io.Copy(w, resp.Body)
 }
+
 /*
 	ExportProject exports project from a REDCap project.
 	
@@ -763,7 +1057,7 @@ func (r *RedCapClient) ExportProjectXML() ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportProject() ([]byte, error) {
-	client := &http.Client{}	
+	client := r.httpClient()	
 	formating := fmt.Sprintf("token=%s&content=project&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -781,28 +1075,49 @@ func (r *RedCapClient) ExportProject() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
 	return bodyText, nil
 }
 
 /*
-	ExportRecords exports records from a REDCap project.
+	ExportProjectInfo exports the project information as a typed value.
 	
 	Args:
 		None
+	
+	Returns:
+		The project information.
+*/
+func (r *RedCapClient) ExportProjectInfo() (ProjectInfo, error) {
+	var info ProjectInfo
+	if err := r.exportJSON("project", nil, &info); err != nil {
+		return ProjectInfo{}, err
+	}
+	return info, nil
+}
+
+/*
+	ExportRecords exports records from a REDCap project.
+	
+	Args:
+		options: The records, fields, forms and events to export and the
+			optional extra columns. The zero value exports everything.
 
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
-func (r *RedCapClient) ExportRecords() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=record&format=%s&type=flat", r.Token, r.ResponseFormat)
+func (r *RedCapClient) ExportRecords(options RecordsOptions) ([]byte, error) {
+	client := r.httpClient()
+	formating := options.values(r.Token, r.ResponseFormat)
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -817,6 +1132,10 @@ func (r *RedCapClient) ExportRecords() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -835,7 +1154,7 @@ func (r *RedCapClient) ExportRecords() ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportRedcapVersion() ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=version", r.Token)
 
 	data := strings.NewReader(formating)
@@ -853,6 +1172,10 @@ func (r *RedCapClient) ExportRedcapVersion() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -871,7 +1194,7 @@ func (r *RedCapClient) ExportRedcapVersion() ([]byte, error) {
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportReports(reportID string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=report&format=%s&report_id=%s", r.Token, r.ResponseFormat, reportID)
 
 	data := strings.NewReader(formating)
@@ -889,6 +1212,10 @@ func (r *RedCapClient) ExportReports(reportID string) ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -897,42 +1224,62 @@ func (r *RedCapClient) ExportReports(reportID string) ([]byte, error) {
 	return bodyText, nil
 }
 
-/*
-	ExportSurveyLink exports a survey link from a REDCap project.
-	
-	Args:
-		reportId: The report ID to export.
-	
-	Returns:
-		A byte slice containing the response from the REDCap API.
-*/
-func (r *RedCapClient) ExportSurveyLink(recordID string, instrument string, event string) ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=surveyLink&record=%s&instrument=%s&event=%s&format=%s", r.Token, recordID, instrument, event, r.ResponseFormat)
+// surveyRecordRequest asks for one of the survey details REDCap keeps per
+// record, such as its survey link or return code.
+func (r *RedCapClient) surveyRecordRequest(content string, recordID string, instrument string, event string) ([]byte, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":      {r.Token},
+		"content":    {content},
+		"record":     {recordID},
+		"instrument": {instrument},
+		"format":     {string(r.ResponseFormat)},
+	}
+	if event != "" {
+		formating.Set("event", event)
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("exporting %s: %w", content, err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("exporting %s: %w", content, err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("exporting %s: %w", content, err)
 	}
-	
 	return bodyText, nil
 }
 
+/*
+	ExportSurveyLink exports the link to a record's survey.
+	
+	Args:
+		recordID: The record ID.
+		instrument: The survey instrument.
+		event: The event name, or "" for classic projects.
+	
+	Returns:
+		A byte slice containing the response from the REDCap API.
+*/
+func (r *RedCapClient) ExportSurveyLink(recordID string, instrument string, event string) ([]byte, error) {
+	return r.surveyRecordRequest("surveyLink", recordID, instrument, event)
+}
+
 /*
 	ExportSurveyParticipants exports survey participants from a REDCap project.
 	
@@ -943,7 +1290,7 @@ func (r *RedCapClient) ExportSurveyLink(recordID string, instrument string, even
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportSurveyParticipants(instrument string, event string) ([]byte, error) {
-	client := &http.Client{}
+	client := r.httpClient()
 	formating := fmt.Sprintf("token=%s&content=participantList&instrument=%s&event=%s&format=%s", r.Token, instrument, event, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
@@ -961,6 +1308,10 @@ func (r *RedCapClient) ExportSurveyParticipants(instrument string, event string)
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -981,30 +1332,7 @@ func (r *RedCapClient) ExportSurveyParticipants(instrument string, event string)
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportSurveyQueueLink(recordID string, instrument string, event string) ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=surveyQueueLink&record=%s&instrument=%s&event=%s&format=%s", r.Token, recordID, instrument, event, r.ResponseFormat)
-
-	data := strings.NewReader(formating)
-	req, err := http.NewRequest("POST", r.URL, data)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	defer resp.Body.Close()
-
-	bodyText, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-	
-	return bodyText, nil
+	return r.surveyRecordRequest("surveyQueueLink", recordID, instrument, event)
 }
 
 
@@ -1020,8 +1348,21 @@ func (r *RedCapClient) ExportSurveyQueueLink(recordID string, instrument string,
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) ExportSurveyReturnCode(recordID string, instrument string, event string) ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=surveyReturnCode&record=%s&instrument=%s&event=%s&format=%s", r.Token, recordID, instrument, event, r.ResponseFormat)
+	return r.surveyRecordRequest("surveyReturnCode", recordID, instrument, event)
+}
+
+/*
+	ExportDagMaps exports user DAG maps from a REDCap project.
+	
+	Args:
+		None
+	
+	Returns:
+		A byte slice containing the response from the REDCap API.
+*/
+func (r *RedCapClient) ExportDagMaps() ([]byte, error) {
+	client := r.httpClient()
+	formating := fmt.Sprintf("token=%s&content=userDagMapping&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
 	req, err := http.NewRequest("POST", r.URL, data)
@@ -1038,16 +1379,20 @@ func (r *RedCapClient) ExportSurveyReturnCode(recordID string, instrument string
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
 /*
-	ExportDagMaps exports user DAG maps from a REDCap project.
+	ExportUserRoles exports user roles from a REDCap project.
 	
 	Args:
 		None
@@ -1055,9 +1400,9 @@ func (r *RedCapClient) ExportSurveyReturnCode(recordID string, instrument string
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
-func (r *RedCapClient) ExportDagMaps() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=userDagMapping&format=%s", r.Token, r.ResponseFormat)
+func (r *RedCapClient) ExportUserRoles() ([]byte, error) {
+	client := r.httpClient()
+	formating := fmt.Sprintf("token=%s&content=userRole&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
 	req, err := http.NewRequest("POST", r.URL, data)
@@ -1074,6 +1419,10 @@ func (r *RedCapClient) ExportDagMaps() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -1083,7 +1432,7 @@ func (r *RedCapClient) ExportDagMaps() ([]byte, error) {
 }
 
 /*
-	ExportUserRoles exports user roles from a REDCap project.
+	ExportUsers exports users from a REDCap project.
 	
 	Args:
 		None
@@ -1091,9 +1440,9 @@ func (r *RedCapClient) ExportDagMaps() ([]byte, error) {
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
-func (r *RedCapClient) ExportUserRoles() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=userRole&format=%s", r.Token, r.ResponseFormat)
+func (r *RedCapClient) ExportUsers() ([]byte, error) {
+	client := r.httpClient()
+	formating := fmt.Sprintf("token=%s&content=user&format=%s", r.Token, r.ResponseFormat)
 
 	data := strings.NewReader(formating)
 	req, err := http.NewRequest("POST", r.URL, data)
@@ -1110,16 +1459,19 @@ func (r *RedCapClient) ExportUserRoles() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	
 	return bodyText, nil
 }
 
 /*
-	ExportUsers exports users from a REDCap project.
+	ImporArms imports arms into a REDCap project.
 	
 	Args:
 		None
@@ -1127,11 +1479,19 @@ func (r *RedCapClient) ExportUserRoles() ([]byte, error) {
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
-func (r *RedCapClient) ExportUsers() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=user&format=%s", r.Token, r.ResponseFormat)
+func (r *RedCapClient) ImportArms() ([]byte, error) {
+	// TODO: We need to come back to this and implement a loop to iterate over the parameters as a JSON builder
+	client := r.httpClient()
+	formating := url.Values{
+		"token":    {r.Token},
+		"content":  {"arm"},
+		"action":   {"import"},
+		"override": {"0"},
+		"format":   {string(r.ResponseFormat)},
+		"data":     {`[{"arm_num":"1","name":"Arm 1"}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1146,30 +1506,48 @@ func (r *RedCapClient) ExportUsers() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
 /*
-	ImporArms imports arms into a REDCap project.
+	ImportContent imports rows of a project structure that REDCap takes as a
+	data parameter, such as arms, events, dags, userDagMapping, user,
+	userRole or formEventMapping.
 	
 	Args:
-		None
+		content: The REDCap content name.
+		format: The format of data, JSON or CSV.
+		data: The rows to import.
+		override: Whether to replace all existing arms or events first.
 	
 	Returns:
-		A byte slice containing the response from the REDCap API.
+		A byte slice containing the response from the REDCap API, usually the
+		number of rows imported.
 */
-func (r *RedCapClient) ImportArms() ([]byte, error) {
-	// TODO: We need to come back to this and implement a loop to iterate over the parameters as a JSON builder
-	client := &http.Client{}
-	formating := fmt.Sprintf(`token=%s&content=arm&action=import&override=0&format=%s&data=[{\"arm_num\":\"1\",\"name\":\"Arm%201\"}]`, r.Token, r.ResponseFormat)
+func (r *RedCapClient) ImportContent(content string, format ResponseFormat, data []byte, override bool) ([]byte, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {content},
+		"action":       {"import"},
+		"format":       {string(format)},
+		"data":         {string(data)},
+		"returnFormat": {"json"},
+	}
+	if override {
+		formating.Set("override", "1")
+	}
 
-	data := strings.NewReader(formating)
-	req, err := http.NewRequest("POST", r.URL, data)
+	req, err := http.NewRequest("POST", r.URL, strings.NewReader(formating.Encode()))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1183,11 +1561,15 @@ func (r *RedCapClient) ImportArms() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+
 	return bodyText, nil
 }
 
@@ -1202,10 +1584,16 @@ func (r *RedCapClient) ImportArms() ([]byte, error) {
 */
 func (r *RedCapClient) ImportDags() ([]byte, error) {
 	// TODO: We need to come back to this and implement a loop to iterate over the parameters as a JSON builder
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=dag&action=import&format=%s&data=[{\"data_access_group_name\":\"Group%20API\",\"unique_group_name\":\"\"}]", r.Token, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":   {r.Token},
+		"content": {"dag"},
+		"action":  {"import"},
+		"format":  {string(r.ResponseFormat)},
+		"data":    {`[{"data_access_group_name":"Group API","unique_group_name":""}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1220,6 +1608,10 @@ func (r *RedCapClient) ImportDags() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
@@ -1239,10 +1631,17 @@ func (r *RedCapClient) ImportDags() ([]byte, error) {
 */
 func (r *RedCapClient) ImportEvents() ([]byte, error) {
 	// TODO: We need to come back to this and implement a loop to iterate over the parameters as a JSON builder
-	client := &http.Client{}
-	formating := fmt.Sprintf(`token=%s&content=event&action=import&override=0&format=%s&data=[{\"event_name\":\"Event%201\",\"arm_num\":\"1\",\"day_offset\":\"0\",\"offset_min\":\"0\",\"offset_max\":\"0\",\"unique_event_name\":\"event_1_arm_1\"}]`, r.Token, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":    {r.Token},
+		"content":  {"event"},
+		"action":   {"import"},
+		"override": {"0"},
+		"format":   {string(r.ResponseFormat)},
+		"data":     {`[{"event_name":"Event 1","arm_num":"1","day_offset":"0","offset_min":"0","offset_max":"0","unique_event_name":"event_1_arm_1"}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1257,39 +1656,62 @@ func (r *RedCapClient) ImportEvents() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
-// TODO: FIX THIS - FILE IMPORT IS A BINARY FILE
-func (r *RedCapClient) ImportFile() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("", r.Token, r.ResponseFormat)
+/*
+	ImportFile uploads a file to a file upload field of a record.
+	
+	Args:
+		record: The record ID to upload the file to.
+		field: The file upload field.
+		event: The event name, or "" for classic projects.
+		repeatInstance: The repeating instance, or 0 if the field is not on a
+			repeating instrument or event.
+		filename: The name REDCap stores the file under.
+		file: The contents of the file.
+	
+	Returns:
+		A byte slice containing the response from the REDCap API.
+*/
+func (r *RedCapClient) ImportFile(record string, field string, event string, repeatInstance int, filename string, file io.Reader) ([]byte, error) {
+	client := r.httpClient()
+	formating := fileParameters(r.Token, "import", record, field, event, repeatInstance)
 
-	data := strings.NewReader(formating)
+	data, contentType := multipartUpload(formating, filename, file)
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
-		log.Fatal(err)
+		data.Close()
+		return nil, fmt.Errorf("uploading %s: %w", filename, err)
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Accept", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("uploading %s: %w", filename, err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+
 	return bodyText, nil
 }
 
@@ -1304,10 +1726,16 @@ func (r *RedCapClient) ImportFile() ([]byte, error) {
 */
 func (r *RedCapClient) ImportInstrumentEventMaps() ([]byte, error) {
 	// TODO: We need to come back to this and implement a loop to iterate over the parameters as a JSON builder
-	client := &http.Client{}
-	formating := fmt.Sprintf(`token=%s&content=formEventMapping&format=%s&data=[{\"arm\":{\"number\":\"1\",\"event\":[{\"unique_event_name\":\"event_1_arm_1\",\"form\":[\"instr_1\",\"instr_2\"]}]}},{\"arm\":{\"number\":\"2\",\"event\":[{\"unique_event_name\":\"event_2_arm_1\",\"form\":[\"instr_1\"]}]}}]`, r.Token, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":   {r.Token},
+		"content": {"formEventMapping"},
+		"action":  {"import"},
+		"format":  {string(r.ResponseFormat)},
+		"data":    {`[{"arm":{"number":"1","event":[{"unique_event_name":"event_1_arm_1","form":["instr_1","instr_2"]}]}},{"arm":{"number":"2","event":[{"unique_event_name":"event_2_arm_1","form":["instr_1"]}]}}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1322,48 +1750,122 @@ func (r *RedCapClient) ImportInstrumentEventMaps() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
-func (r *RedCapClient) ImportProject() ([]byte, error) {
-	// TODO: We need to come back to this and implement a loop to iterate over the parameters as a JSON builder
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=$API_SUPER_TOKEN&content=project&format=%s&data=[{\"project_title\":\"New%20Project%20via%20API\",\"purpose\":0,\"purpose_other\":\"\",\"project_note\":\"Some%20notes%20about%20the%20project\"}]", r.Token, r.ResponseFormat)
+/*
+	ImportProject creates a new REDCap project. The client must be using a
+	super API token rather than a project token.
+	
+	Args:
+		project: The settings of the new project.
+		odm: An optional CDISC ODM XML template, as exported by
+			ExportProjectXML, to build the project from. Pass nil to create
+			an empty project.
+	
+	Returns:
+		The API token of the new project.
+*/
+func (r *RedCapClient) ImportProject(project NewProject, odm io.Reader) (string, error) {
+	if len(r.Token) != 64 {
+		return "", fmt.Errorf("ImportProject requires a 64 character super API token")
+	}
+	if err := project.validate(); err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal([]NewProject{project})
+	if err != nil {
+		return "", err
+	}
 
-	data := strings.NewReader(formating)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"project"},
+		"format":       {"json"},
+		"data":         {string(payload)},
+		"returnFormat": {"json"},
+	}
+	if odm != nil {
+		template, err := io.ReadAll(odm)
+		if err != nil {
+			return "", fmt.Errorf("reading ODM template: %w", err)
+		}
+		formating.Set("odm", string(template))
+	}
+
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("importing the project: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("importing the project: %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return "", newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("importing the project: %w", err)
 	}
-	fmt.Printf("%s\n", bodyText)
-	return bodyText, nil
+
+	return strings.TrimSpace(string(bodyText)), nil
 }
 
-// TODO: FIX THIS - RECORD IMPORT IS A MESS
-func (r *RedCapClient) ImportRecords() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("", r.Token, r.ResponseFormat)
+/*
+	ImportRecords imports flat records into a REDCap project.
+	
+	Args:
+		records: The rows to import, keyed by export column name.
+		overwrite: Whether blank values erase existing data instead of being
+			ignored.
+	
+	Returns:
+		A byte slice containing the response from the REDCap API, the number
+		of records imported.
+*/
+func (r *RedCapClient) ImportRecords(records []Record, overwrite bool) ([]byte, error) {
+	payload, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	overwriteBehavior := "normal"
+	if overwrite {
+		overwriteBehavior = "overwrite"
+	}
+
+	client := r.httpClient()
+	formating := url.Values{
+		"token":             {r.Token},
+		"content":           {"record"},
+		"action":            {"import"},
+		"format":            {"json"},
+		"type":              {"flat"},
+		"overwriteBehavior": {overwriteBehavior},
+		"data":              {string(payload)},
+		"returnContent":     {"count"},
+		"returnFormat":      {"json"},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1378,19 +1880,29 @@ func (r *RedCapClient) ImportRecords() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
 func (r *RedCapClient) ImportUserDagMaps() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=userDagMapping&action=import&format=%s&data=[{\"username\":\"testuser\",\"redcap_data_access_group\":\"api_testing_group\"}]", r.Token, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":   {r.Token},
+		"content": {"userDagMapping"},
+		"action":  {"import"},
+		"format":  {string(r.ResponseFormat)},
+		"data":    {`[{"username":"testuser","redcap_data_access_group":"api_testing_group"}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1405,19 +1917,28 @@ func (r *RedCapClient) ImportUserDagMaps() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
 func (r *RedCapClient) ImportUserRoles() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=userRole&format=%s&data=[{\"unique_role_name\":\"U-2119C4Y87T\",\"role_label\":\"Project Manager\",\"data_access_group\":\"1\",\"data_export\":\"0\",\"mobile_app\":\"0\",\"mobile_app_download_data\":\"0\",\"lock_records_all_forms\":\"0\",\"lock_records\":\"0\",\"lock_records_customization\":\"0\",\"record_delete\":\"0\",\"record_rename\":\"0\",\"record_create\":\"1\",\"api_import\":\"1\",\"api_export\":\"1\",\"api_modules\":\"1\",\"data_quality_execute\":\"1\",\"data_quality_create\":\"1\",\"file_repository\":\"1\",\"logging\":\"1\",\"data_comparison_tool\":\"1\",\"data_import_tool\":\"1\",\"calendar\":\"1\",\"stats_and_charts\":\"1\",\"reports\":\"1\",\"user_rights\":\"1\",\"design\":\"1\"}]", r.Token, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":   {r.Token},
+		"content": {"userRole"},
+		"format":  {string(r.ResponseFormat)},
+		"data":    {`[{"unique_role_name":"U-2119C4Y87T","role_label":"Project Manager","data_access_group":"1","data_export":"0","mobile_app":"0","mobile_app_download_data":"0","lock_records_all_forms":"0","lock_records":"0","lock_records_customization":"0","record_delete":"0","record_rename":"0","record_create":"1","api_import":"1","api_export":"1","api_modules":"1","data_quality_execute":"1","data_quality_create":"1","file_repository":"1","logging":"1","data_comparison_tool":"1","data_import_tool":"1","calendar":"1","stats_and_charts":"1","reports":"1","user_rights":"1","design":"1"}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1432,19 +1953,28 @@ func (r *RedCapClient) ImportUserRoles() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
 func (r *RedCapClient) ImportUsers() ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=user&format=%s&data=[{\"username\":\"test_user_47\",\"expiration\":\"\",\"data_access_group\":\"1\",\"data_export\":\"0\",\"mobile_app\":\"0\",\"mobile_app_download_data\":\"0\",\"lock_record_multiform\":\"0\",\"lock_record\":\"0\",\"lock_record_customize\":\"0\",\"record_delete\":\"0\",\"record_rename\":\"0\",\"record_create\":\"1\",\"api_import\":\"1\",\"api_export\":\"1\",\"api_modules\":\"1\",\"data_quality_execute\":\"1\",\"data_quality_design\":\"1\",\"file_repository\":\"1\",\"data_logging\":\"1\",\"data_comparison_tool\":\"1\",\"data_import_tool\":\"1\",\"calendar\":\"1\",\"graphical\":\"1\",\"reports\":\"1\",\"user_rights\":\"1\",\"design\":\"1\"}]", r.Token, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":   {r.Token},
+		"content": {"user"},
+		"format":  {string(r.ResponseFormat)},
+		"data":    {`[{"username":"test_user_47","expiration":"","data_access_group":"1","data_export":"0","mobile_app":"0","mobile_app_download_data":"0","lock_record_multiform":"0","lock_record":"0","lock_record_customize":"0","record_delete":"0","record_rename":"0","record_create":"1","api_import":"1","api_export":"1","api_modules":"1","data_quality_execute":"1","data_quality_design":"1","file_repository":"1","data_logging":"1","data_comparison_tool":"1","data_import_tool":"1","calendar":"1","graphical":"1","reports":"1","user_rights":"1","design":"1"}]`},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
 		log.Fatal(err)
@@ -1459,81 +1989,171 @@ func (r *RedCapClient) ImportUsers() ([]byte, error) {
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", bodyText)
+	
 	return bodyText, nil
 }
 
 /*
-	ImportInstrumentEventMaps imports instrument event maps into a REDCap project.
+	ImportProjectSettings updates the project settings that differ between
+	original and updated. Nothing is sent when no setting has changed.
 	
 	Args:
-		None
+		original: The settings as returned by ExportProjectInfo.
+		updated: A copy of original with the wanted changes applied.
+	
+	Returns:
+		The number of settings REDCap updated.
+*/
+func (r *RedCapClient) ImportProjectSettings(original ProjectInfo, updated ProjectInfo) (int, error) {
+	changed, err := ChangedSettings(original, updated)
+	if err != nil {
+		return 0, err
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+	payload, err := json.Marshal(changed)
+	if err != nil {
+		return 0, err
+	}
+
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"project_settings"},
+		"format":       {"json"},
+		"data":         {string(payload)},
+		"returnFormat": {"json"},
+	}
+
+	data := strings.NewReader(formating.Encode())
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		return 0, fmt.Errorf("importing project settings: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("importing project settings: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, newAPIError(resp)
+	}
+
+	bodyText, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("importing project settings: %w", err)
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(bodyText)))
+}
+
+/*
+	RenameRecord gives a record a new record ID.
+	
+	Args:
+		record_id: The record to rename.
+		arm: The arm the record is renamed in, or "" for every arm.
+		record_id_new: The new record ID.
 	
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) RenameRecord(record_id string, arm string, record_id_new string) ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&action=rename&content=record&record=%s&new_record_name=%s&arm=%s&returnFormat=%s", r.Token, record_id, record_id_new, arm, r.ResponseFormat)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":           {r.Token},
+		"content":         {"record"},
+		"action":          {"rename"},
+		"record":          {record_id},
+		"new_record_name": {record_id_new},
+		"returnFormat":    {string(r.ResponseFormat)},
+	}
+	if arm != "" {
+		formating.Set("arm", arm)
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("renaming the record: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("renaming the record: %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("renaming the record: %w", err)
 	}
 	
 	return bodyText, nil
 }
 
 /*
-	ImportInstrumentEventMaps imports instrument event maps into a REDCap project.
+	SwitchDag moves the API user into a data access group.
 	
 	Args:
-		None
+		dag: The unique group name of the data access group.
 	
 	Returns:
 		A byte slice containing the response from the REDCap API.
 */
 func (r *RedCapClient) SwitchDag(dag string) ([]byte, error) {
-	client := &http.Client{}
-	formating := fmt.Sprintf("token=%s&content=dag&action=switch&format=%s&dag=%s", r.Token, r.ResponseFormat, dag)
+	client := r.httpClient()
+	formating := url.Values{
+		"token":   {r.Token},
+		"content": {"dag"},
+		"action":  {"switch"},
+		"format":  {string(r.ResponseFormat)},
+		"dag":     {dag},
+	}
 
-	data := strings.NewReader(formating)
+	data := strings.NewReader(formating.Encode())
 	req, err := http.NewRequest("POST", r.URL, data)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("switching data access group: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Accept", "application/json")
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("switching data access group: %w", err)
 	}
 
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("switching data access group: %w", err)
 	}
 	
 	return bodyText, nil
@@ -0,0 +1,354 @@
+package redcaptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// createProject answers a project import made with the super token. An
+// odm template sets up the metadata, events and records.
+func (s *Server) createProject(w http.ResponseWriter, form url.Values) error {
+	var settings []redcap.ProjectInfo
+	if err := json.Unmarshal([]byte(form.Get("data")), &settings); err != nil || len(settings) != 1 {
+		return badRequest("The data being imported is not formatted correctly")
+	}
+	if settings[0].ProjectTitle == "" {
+		return badRequest("The project_title is missing")
+	}
+
+	project := NewProject(settings[0].ProjectTitle, redcap.DataDictionary{
+		{FieldName: "record_id", FormName: "my_first_instrument", FieldType: "text", FieldLabel: "Record ID"},
+	})
+	project.Info = settings[0]
+	if template := form.Get("odm"); template != "" {
+		odm, err := redcap.ParseODM(strings.NewReader(template))
+		if err != nil {
+			return badRequest("The ODM template is not valid: %s", err)
+		}
+		if err := project.applyODM(odm); err != nil {
+			return err
+		}
+	}
+	project.Token = newToken(16)
+	s.addProject(project)
+
+	writeText(w, project.Token)
+	return nil
+}
+
+func (p *Project) exportProject(w http.ResponseWriter, form url.Values) error {
+	if form.Get("format") == "json" {
+		return writeJSON(w, p.Info)
+	}
+	rows, err := toRows([]redcap.ProjectInfo{p.Info})
+	if err != nil {
+		return err
+	}
+	return writeRows(w, form.Get("format"), nil, rows)
+}
+
+func (p *Project) importProjectSettings(w http.ResponseWriter, form url.Values) error {
+	var changes map[string]interface{}
+	if err := json.Unmarshal([]byte(form.Get("data")), &changes); err != nil {
+		return badRequest("The data being imported is not formatted correctly: %s", err)
+	}
+
+	current, err := json.Marshal(p.Info)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	if err := json.Unmarshal(current, &settings); err != nil {
+		return err
+	}
+	for key, value := range changes {
+		if _, ok := settings[key]; !ok {
+			return badRequest("%q is not a project setting", key)
+		}
+		settings[key] = value
+	}
+
+	updated, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	var info redcap.ProjectInfo
+	if err := json.Unmarshal(updated, &info); err != nil {
+		return badRequest("invalid project settings: %s", err)
+	}
+	p.Info = info
+	writeText(w, strconv.Itoa(len(changes)))
+	return nil
+}
+
+func (p *Project) exportMetadata(w http.ResponseWriter, form url.Values) error {
+	fields, forms := array(form, "fields"), array(form, "forms")
+	for _, field := range fields {
+		if p.Metadata.Field(field) == nil {
+			return badRequest("The following values in the parameter \"fields\" are not valid: %s", field)
+		}
+	}
+
+	metadata := redcap.DataDictionary{}
+	for _, field := range p.Metadata {
+		if (len(fields) > 0 || len(forms) > 0) && !contains(fields, field.FieldName) && !contains(forms, field.FormName) {
+			continue
+		}
+		metadata = append(metadata, field)
+	}
+	if form.Get("format") == "json" {
+		return writeJSON(w, metadata)
+	}
+	rows, err := toRows(metadata)
+	if err != nil {
+		return err
+	}
+	return writeRows(w, form.Get("format"), nil, rows)
+}
+
+func (p *Project) importMetadata(w http.ResponseWriter, form url.Values) error {
+	if form.Get("format") != "json" {
+		return badRequest("The fake REDCap server only imports metadata as json")
+	}
+	dictionary, err := redcap.ParseMetadata([]byte(form.Get("data")))
+	if err != nil {
+		return badRequest("%s", err)
+	}
+	if err := dictionary.Validate(); err != nil {
+		return badRequest("%s", err)
+	}
+	p.Metadata = dictionary
+	p.log("Upload data dictionary", "")
+	writeText(w, strconv.Itoa(len(dictionary)))
+	return nil
+}
+
+func (p *Project) exportInstruments(w http.ResponseWriter, form url.Values) error {
+	var rows []Row
+	for _, formName := range p.Metadata.Forms() {
+		rows = append(rows, Row{"instrument_name": formName, "instrument_label": p.instrumentLabel(formName)})
+	}
+	return writeRows(w, form.Get("format"), []string{"instrument_name", "instrument_label"}, rows)
+}
+
+func (p *Project) exportFieldNames(w http.ResponseWriter, form url.Values) error {
+	name := form.Get("field")
+	if name != "" && p.Metadata.Field(name) == nil {
+		return badRequest("The field %q does not exist", name)
+	}
+
+	var rows []Row
+	for _, field := range p.Metadata {
+		if name != "" && field.FieldName != name {
+			continue
+		}
+		if field.FieldType == "checkbox" {
+			choices, _ := redcap.ParseChoices(field.SelectChoicesOrCalculations)
+			for _, choice := range choices {
+				rows = append(rows, Row{"original_field_name": field.FieldName, "choice_value": choice[0], "export_field_name": redcap.CheckboxColumn(field.FieldName, choice[0])})
+			}
+			continue
+		}
+		for _, column := range field.ExportColumns() {
+			rows = append(rows, Row{"original_field_name": field.FieldName, "choice_value": "", "export_field_name": column})
+		}
+	}
+	return writeRows(w, form.Get("format"), []string{"original_field_name", "choice_value", "export_field_name"}, rows)
+}
+
+func (p *Project) exportArms(w http.ResponseWriter, form url.Values) error {
+	arms := array(form, "arms")
+	var rows []Row
+	for _, arm := range p.Arms {
+		if len(arms) == 0 || contains(arms, arm["arm_num"]) {
+			rows = append(rows, arm)
+		}
+	}
+	return writeRows(w, form.Get("format"), []string{"arm_num", "name"}, rows)
+}
+
+func (p *Project) importArms(w http.ResponseWriter, form url.Values) error {
+	if form.Get("override") == "1" {
+		rows, err := parseData(form.Get("format"), form.Get("data"))
+		if err != nil {
+			return err
+		}
+		p.Arms = nil
+		p.Events = nil
+		p.Mappings = nil
+		form.Set("data", mustJSON(rows))
+		form.Set("format", "json")
+	}
+	return p.importRows(w, form, &p.Arms, "arm_num", nil)
+}
+
+// deleteArms removes arms together with their events and mappings.
+func (p *Project) deleteArms(w http.ResponseWriter, form url.Values) error {
+	arms := array(form, "arms")
+	if err := p.deleteRows(w, form, &p.Arms, "arm_num", "arms"); err != nil {
+		return err
+	}
+
+	events := p.Events[:0]
+	for _, event := range p.Events {
+		if !contains(arms, event["arm_num"]) {
+			events = append(events, event)
+		}
+	}
+	p.Events = events
+
+	mappings := p.Mappings[:0]
+	for _, mapping := range p.Mappings {
+		if !contains(arms, mapping.ArmNum.String()) {
+			mappings = append(mappings, mapping)
+		}
+	}
+	p.Mappings = mappings
+	return nil
+}
+
+var eventColumns = []string{"event_name", "arm_num", "unique_event_name", "custom_event_label", "event_id", "days_offset", "offset_min", "offset_max"}
+
+func (p *Project) exportEvents(w http.ResponseWriter, form url.Values) error {
+	arms := array(form, "arms")
+	var rows []Row
+	for _, event := range p.Events {
+		if len(arms) == 0 || contains(arms, event["arm_num"]) {
+			rows = append(rows, event)
+		}
+	}
+	return writeRows(w, form.Get("format"), eventColumns, rows)
+}
+
+func (p *Project) importEvents(w http.ResponseWriter, form url.Values) error {
+	rows, err := parseData(form.Get("format"), form.Get("data"))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		found := false
+		for _, arm := range p.Arms {
+			found = found || arm["arm_num"] == row["arm_num"]
+		}
+		if !found {
+			return badRequest("The arm %q does not exist", row["arm_num"])
+		}
+	}
+	if form.Get("override") == "1" {
+		p.Events = nil
+		p.Mappings = nil
+	}
+
+	form.Set("data", mustJSON(rows))
+	form.Set("format", "json")
+	return p.importRows(w, form, &p.Events, "unique_event_name", func(row Row) {
+		if row["unique_event_name"] == "" {
+			row["unique_event_name"] = uniqueName(row["event_name"], 18) + "_arm_" + row["arm_num"]
+		}
+		if row["event_id"] == "" {
+			row["event_id"] = strconv.Itoa(p.newID())
+		}
+	})
+}
+
+// deleteEvents removes events together with their mappings.
+func (p *Project) deleteEvents(w http.ResponseWriter, form url.Values) error {
+	events := array(form, "events")
+	if err := p.deleteRows(w, form, &p.Events, "unique_event_name", "events"); err != nil {
+		return err
+	}
+	mappings := p.Mappings[:0]
+	for _, mapping := range p.Mappings {
+		if !contains(events, mapping.UniqueEventName) {
+			mappings = append(mappings, mapping)
+		}
+	}
+	p.Mappings = mappings
+	return nil
+}
+
+func (p *Project) exportMappings(w http.ResponseWriter, form url.Values) error {
+	arms := array(form, "arms")
+	var rows []Row
+	for _, mapping := range p.Mappings {
+		if len(arms) == 0 || contains(arms, mapping.ArmNum.String()) {
+			rows = append(rows, Row{"arm_num": mapping.ArmNum.String(), "unique_event_name": mapping.UniqueEventName, "form": mapping.Form})
+		}
+	}
+	return writeRows(w, form.Get("format"), []string{"arm_num", "unique_event_name", "form"}, rows)
+}
+
+// importMappings replaces the mappings of every arm in the import. Both the
+// flat format of the export and the older nested arm format are accepted.
+func (p *Project) importMappings(w http.ResponseWriter, form url.Values) error {
+	var entries []struct {
+		ArmNum          json.Number `json:"arm_num"`
+		UniqueEventName string      `json:"unique_event_name"`
+		Form            string      `json:"form"`
+		Arm             *struct {
+			Number json.Number `json:"number"`
+			Event  []struct {
+				UniqueEventName string   `json:"unique_event_name"`
+				Form            []string `json:"form"`
+			} `json:"event"`
+		} `json:"arm"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("data")), &entries); err != nil {
+		return badRequest("The data being imported is not formatted correctly: %s", err)
+	}
+
+	var mappings []redcap.FormEventMapping
+	for _, entry := range entries {
+		if entry.Arm == nil {
+			mappings = append(mappings, redcap.FormEventMapping{ArmNum: entry.ArmNum, UniqueEventName: entry.UniqueEventName, Form: entry.Form})
+			continue
+		}
+		for _, event := range entry.Arm.Event {
+			for _, formName := range event.Form {
+				mappings = append(mappings, redcap.FormEventMapping{ArmNum: entry.Arm.Number, UniqueEventName: event.UniqueEventName, Form: formName})
+			}
+		}
+	}
+
+	arms := make(map[string]bool)
+	for _, mapping := range mappings {
+		found := false
+		for _, event := range p.Events {
+			found = found || event["unique_event_name"] == mapping.UniqueEventName
+		}
+		if !found {
+			return badRequest("The event %q does not exist", mapping.UniqueEventName)
+		}
+		if !p.hasForm(mapping.Form) {
+			return badRequest("The instrument %q does not exist", mapping.Form)
+		}
+		arms[mapping.ArmNum.String()] = true
+	}
+
+	kept := p.Mappings[:0]
+	for _, mapping := range p.Mappings {
+		if !arms[mapping.ArmNum.String()] {
+			kept = append(kept, mapping)
+		}
+	}
+	p.Mappings = append(kept, mappings...)
+	sort.SliceStable(p.Mappings, func(i, j int) bool {
+		return p.Mappings[i].ArmNum.String() < p.Mappings[j].ArmNum.String()
+	})
+	writeText(w, strconv.Itoa(len(mappings)))
+	return nil
+}
+
+func mustJSON(v interface{}) string {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(payload)
+}
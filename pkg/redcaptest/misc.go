@@ -0,0 +1,115 @@
+package redcaptest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func (p *Project) exportLogging(w http.ResponseWriter, form url.Values) error {
+	var begin, end time.Time
+	var err error
+	if value := form.Get("beginTime"); value != "" {
+		if begin, err = time.Parse(redcap.LoggingTimeFormat, value); err != nil {
+			return badRequest("The beginTime %q is not valid", value)
+		}
+	}
+	if value := form.Get("endTime"); value != "" {
+		if end, err = time.Parse(redcap.LoggingTimeFormat, value); err != nil {
+			return badRequest("The endTime %q is not valid", value)
+		}
+	}
+	user, record := form.Get("user"), form.Get("record")
+
+	entries := []redcap.LogEntry{}
+	for _, entry := range p.Logging {
+		at, err := time.Parse(redcap.LoggingTimeFormat, entry.Timestamp)
+		if err != nil {
+			return err
+		}
+		if (!begin.IsZero() && at.Before(begin)) || (!end.IsZero() && at.After(end)) {
+			continue
+		}
+		if user != "" && entry.Username != user {
+			continue
+		}
+		if record != "" && !strings.HasSuffix(entry.Action, " "+record) {
+			continue
+		}
+		if logType := form.Get("logtype"); logType == "record_delete" && !strings.HasPrefix(entry.Action, "Deleted Record") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return writeJSON(w, entries)
+}
+
+// survey checks that an instrument is enabled as a survey.
+func (p *Project) survey(instrument string) error {
+	if _, ok := p.Surveys[instrument]; !ok {
+		return badRequest("The instrument %q is not enabled as a survey", instrument)
+	}
+	return nil
+}
+
+func (p *Project) exportParticipants(w http.ResponseWriter, form url.Values) error {
+	instrument := form.Get("instrument")
+	if err := p.survey(instrument); err != nil {
+		return err
+	}
+	participants := p.Surveys[instrument]
+	if participants == nil {
+		participants = []redcap.SurveyParticipant{}
+	}
+	return writeJSON(w, participants)
+}
+
+// exportSurveyAccess answers survey link, survey queue link and return code
+// requests with values derived from the record, instrument and event.
+func (p *Project) exportSurveyAccess(w http.ResponseWriter, form url.Values) error {
+	record, instrument, event := form.Get("record"), form.Get("instrument"), form.Get("event")
+	if err := p.survey(instrument); err != nil {
+		return err
+	}
+	if !p.hasRecord(record) {
+		return badRequest("The record %q does not exist", record)
+	}
+
+	sum := sha1.Sum([]byte(record + "|" + instrument + "|" + event))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	switch form.Get("content") {
+	case "surveyLink":
+		writeText(w, p.server.URL+"/surveys/?s="+hash[:10])
+	case "surveyQueueLink":
+		writeText(w, p.server.URL+"/surveys/?sq="+hash[10:20])
+	default:
+		writeText(w, hash[20:28])
+	}
+	return nil
+}
+
+// exportPDF answers with a placeholder PDF document.
+func (p *Project) exportPDF(w http.ResponseWriter, form url.Values) error {
+	if instrument := form.Get("instrument"); instrument != "" && !p.hasForm(instrument) {
+		return badRequest("The instrument %q does not exist", instrument)
+	}
+	if record := form.Get("record"); record != "" && !p.hasRecord(record) {
+		return badRequest("The record %q does not exist", record)
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Write([]byte("%PDF-1.3\n% " + p.Info.ProjectTitle + "\n%%EOF\n"))
+	return nil
+}
+
+func (p *Project) exportReport(w http.ResponseWriter, form url.Values) error {
+	rows, ok := p.Reports[form.Get("report_id")]
+	if !ok {
+		return badRequest("The report %q does not exist", form.Get("report_id"))
+	}
+	return writeRows(w, form.Get("format"), nil, rows)
+}
@@ -0,0 +1,369 @@
+package redcaptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// choiceTypes are the field types whose choices are written as code lists.
+var choiceTypes = map[string]bool{"dropdown": true, "radio": true, "checkbox": true}
+
+// exportProjectXML answers with an ODM document of the project's metadata,
+// events and repeating setup and, unless returnMetadataOnly is set, its
+// records. Uploaded files are never embedded.
+func (p *Project) exportProjectXML(w http.ResponseWriter, form url.Values) error {
+	odm := redcap.ODM{
+		ODMVersion:          "1.3.1",
+		FileType:            "Snapshot",
+		SourceSystem:        "REDCap",
+		SourceSystemVersion: p.server.Version,
+		Study: redcap.Study{
+			OID: fmt.Sprintf("Project.%s", uniqueName(p.Info.ProjectTitle, 0)),
+			GlobalVariables: redcap.GlobalVariables{
+				StudyName:                     p.Info.ProjectTitle,
+				StudyDescription:              "This file contains the metadata, events, and data for REDCap project.",
+				ProtocolName:                  p.Info.ProjectTitle,
+				RepeatingInstrumentsAndEvents: p.repeatingSetup(),
+			},
+			MetaDataVersion: p.metaDataVersion(),
+		},
+	}
+	if form.Get("returnMetadataOnly") != "true" {
+		odm.ClinicalData = p.clinicalData(odm.Study.OID)
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	return odm.Write(w)
+}
+
+func (p *Project) metaDataVersion() redcap.MetaDataVersion {
+	version := redcap.MetaDataVersion{
+		OID:           "Metadata.1",
+		Name:          p.Info.ProjectTitle,
+		RecordIdField: p.Metadata.RecordIDField(),
+	}
+
+	arms := make(map[string]string)
+	for _, arm := range p.Arms {
+		arms[arm["arm_num"]] = arm["name"]
+	}
+	for i, event := range p.Events {
+		def := redcap.StudyEventDef{
+			OID:              "Event." + event["unique_event_name"],
+			Name:             event["event_name"],
+			Type:             "Common",
+			Repeating:        "No",
+			EventName:        event["event_name"],
+			CustomEventLabel: event["custom_event_label"],
+			UniqueEventName:  event["unique_event_name"],
+			ArmNum:           event["arm_num"],
+			ArmName:          arms[event["arm_num"]],
+			DayOffset:        event["days_offset"],
+			OffsetMin:        event["offset_min"],
+			OffsetMax:        event["offset_max"],
+		}
+		for _, mapping := range p.Mappings {
+			if mapping.UniqueEventName == event["unique_event_name"] {
+				def.FormRefs = append(def.FormRefs, redcap.FormRef{FormOID: "Form." + mapping.Form, OrderNumber: fmt.Sprint(len(def.FormRefs) + 1), Mandatory: "No", FormName: mapping.Form})
+			}
+		}
+		version.StudyEventRefs = append(version.StudyEventRefs, redcap.StudyEventRef{StudyEventOID: def.OID, OrderNumber: fmt.Sprint(i + 1), Mandatory: "No"})
+		version.StudyEventDefs = append(version.StudyEventDefs, def)
+	}
+
+	for _, formName := range p.Metadata.Forms() {
+		group := redcap.ItemGroupDef{OID: formName + ".1", Name: p.instrumentLabel(formName), Repeating: "No"}
+		for _, field := range p.Metadata {
+			if field.FormName != formName {
+				continue
+			}
+			group.ItemRefs = append(group.ItemRefs, redcap.ItemRef{ItemOID: field.FieldName, Mandatory: "No", Variable: field.FieldName})
+			item := redcap.ItemDef{
+				OID:                field.FieldName,
+				Name:               field.FieldName,
+				DataType:           "text",
+				Variable:           field.FieldName,
+				FieldType:          field.FieldType,
+				TextValidationType: field.TextValidationTypeOrShowSliderNumber,
+				FieldNote:          field.FieldNote,
+				SectionHeader:      field.SectionHeader,
+				BranchingLogic:     field.BranchingLogic,
+				Identifier:         field.Identifier,
+				RequiredField:      field.RequiredField,
+				FieldAnnotation:    field.FieldAnnotation,
+				MatrixGroupName:    field.MatrixGroupName,
+				Question:           field.FieldLabel,
+			}
+			if field.FieldType == "calc" {
+				item.Calculation = field.SelectChoicesOrCalculations
+			}
+			if choices, err := redcap.ParseChoices(field.SelectChoicesOrCalculations); err == nil && choiceTypes[field.FieldType] {
+				codeList := redcap.CodeList{OID: field.FieldName + ".choices", Name: field.FieldName, DataType: "text", Variable: field.FieldName}
+				for _, choice := range choices {
+					codeList.CodeListItems = append(codeList.CodeListItems, redcap.CodeListItem{CodedValue: choice[0], Decode: choice[1]})
+				}
+				item.CodeListRef = &redcap.CodeListRef{CodeListOID: codeList.OID}
+				version.CodeLists = append(version.CodeLists, codeList)
+			}
+			version.ItemDefs = append(version.ItemDefs, item)
+		}
+		version.FormDefs = append(version.FormDefs, redcap.FormDef{
+			OID:           "Form." + formName,
+			Name:          p.instrumentLabel(formName),
+			Repeating:     "No",
+			FormName:      formName,
+			ItemGroupRefs: []redcap.ItemGroupRef{{ItemGroupOID: group.OID, Mandatory: "No"}},
+		})
+		version.ItemGroupDefs = append(version.ItemGroupDefs, group)
+	}
+	return version
+}
+
+// repeatingSetup describes the repeating instruments and events, which the
+// fake project only knows from the records that use them.
+func (p *Project) repeatingSetup() *redcap.ODMRepeatingInstrumentsAndEvents {
+	if !p.Info.HasRepeatingInstrumentsOrEvents {
+		return nil
+	}
+	setup := &redcap.ODMRepeatingInstrumentsAndEvents{}
+	instruments := make(map[[2]string]bool)
+	events := make(map[string]bool)
+	for _, row := range p.Records {
+		event, instrument := row[redcap.EventNameColumn], row[redcap.RepeatInstrumentColumn]
+		switch {
+		case instrument != "" && !instruments[[2]string{event, instrument}]:
+			instruments[[2]string{event, instrument}] = true
+			if setup.RepeatingInstruments == nil {
+				setup.RepeatingInstruments = &redcap.ODMRepeatingInstruments{}
+			}
+			setup.RepeatingInstruments.RepeatingInstruments = append(setup.RepeatingInstruments.RepeatingInstruments, redcap.ODMRepeatingInstrument{UniqueEventName: event, RepeatInstrument: instrument})
+		case instrument == "" && row[redcap.RepeatInstanceColumn] != "" && !events[event]:
+			events[event] = true
+			setup.RepeatingEvents = append(setup.RepeatingEvents, redcap.ODMRepeatingEvent{UniqueEventName: event})
+		}
+	}
+	return setup
+}
+
+// clinicalData writes the records, one FormData per instrument with data in
+// each record, event and repeat instance.
+func (p *Project) clinicalData(studyOID string) *redcap.ClinicalData {
+	data := &redcap.ClinicalData{StudyOID: studyOID, MetaDataVersionOID: "Metadata.1"}
+	recordID := p.Metadata.RecordIDField()
+	located := p.columnFields()
+	columns := p.dataColumns()
+	subjects := make(map[string]int)
+
+	for _, row := range p.Records {
+		i, ok := subjects[row[recordID]]
+		if !ok {
+			i = len(data.SubjectData)
+			subjects[row[recordID]] = i
+			data.SubjectData = append(data.SubjectData, redcap.SubjectData{SubjectKey: row[recordID], RecordIdField: recordID})
+		}
+		subject := &data.SubjectData[i]
+
+		eventOID, repeatKey := "Event.1", ""
+		if event := row[redcap.EventNameColumn]; event != "" {
+			eventOID = "Event." + event
+		}
+		instrument, instance := row[redcap.RepeatInstrumentColumn], row[redcap.RepeatInstanceColumn]
+		if instrument == "" {
+			repeatKey = instance
+		}
+
+		var forms []redcap.FormData
+		for _, formName := range p.Metadata.Forms() {
+			if instrument != "" && instrument != formName {
+				continue
+			}
+			group := redcap.ItemGroupData{ItemGroupOID: formName + ".1"}
+			for _, column := range columns {
+				if located[column][1] == formName && row[column] != "" {
+					group.ItemData = append(group.ItemData, redcap.ItemData{ItemOID: column, Value: row[column]})
+				}
+			}
+			if len(group.ItemData) == 0 {
+				continue
+			}
+			formData := redcap.FormData{FormOID: "Form." + formName, ItemGroupData: []redcap.ItemGroupData{group}}
+			if instrument != "" {
+				formData.FormRepeatKey = instance
+			}
+			forms = append(forms, formData)
+		}
+		if len(forms) == 0 {
+			continue
+		}
+
+		found := false
+		for j, event := range subject.StudyEventData {
+			if event.StudyEventOID == eventOID && event.StudyEventRepeatKey == repeatKey {
+				subject.StudyEventData[j].FormData = append(subject.StudyEventData[j].FormData, forms...)
+				found = true
+			}
+		}
+		if !found {
+			subject.StudyEventData = append(subject.StudyEventData, redcap.StudyEventData{
+				StudyEventOID:       eventOID,
+				StudyEventRepeatKey: repeatKey,
+				UniqueEventName:     row[redcap.EventNameColumn],
+				FormData:            forms,
+			})
+		}
+	}
+	return data
+}
+
+// applyODM builds the project's metadata, arms, events, mappings and records
+// from an ODM document, as REDCap does for a project created from an XML
+// template.
+func (p *Project) applyODM(odm *redcap.ODM) error {
+	version := odm.Study.MetaDataVersion
+	groups := make(map[string]redcap.ItemGroupDef)
+	for _, group := range version.ItemGroupDefs {
+		groups[group.OID] = group
+	}
+
+	var dictionary redcap.DataDictionary
+	for _, form := range version.FormDefs {
+		formName := form.FormName
+		if formName == "" {
+			formName = strings.TrimPrefix(form.OID, "Form.")
+		}
+		p.InstrumentLabels[formName] = form.Name
+		for _, ref := range form.ItemGroupRefs {
+			for _, itemRef := range groups[ref.ItemGroupOID].ItemRefs {
+				item := odm.ItemDef(itemRef.ItemOID)
+				if item == nil || item.FieldType == "" {
+					continue
+				}
+				field := redcap.MetadataField{
+					FieldName:                            item.Variable,
+					FormName:                             formName,
+					SectionHeader:                        item.SectionHeader,
+					FieldType:                            item.FieldType,
+					FieldLabel:                           item.Question,
+					SelectChoicesOrCalculations:          item.Calculation,
+					FieldNote:                            item.FieldNote,
+					TextValidationTypeOrShowSliderNumber: item.TextValidationType,
+					Identifier:                           item.Identifier,
+					BranchingLogic:                       item.BranchingLogic,
+					RequiredField:                        item.RequiredField,
+					MatrixGroupName:                      item.MatrixGroupName,
+					FieldAnnotation:                      item.FieldAnnotation,
+				}
+				if field.FieldName == "" {
+					field.FieldName = item.OID
+				}
+				if item.CodeListRef != nil {
+					if codeList := odm.CodeList(item.CodeListRef.CodeListOID); codeList != nil {
+						var choices []string
+						for _, choice := range codeList.CodeListItems {
+							choices = append(choices, choice.CodedValue+", "+choice.Decode)
+						}
+						field.SelectChoicesOrCalculations = strings.Join(choices, " | ")
+					}
+				}
+				dictionary = append(dictionary, field)
+			}
+		}
+	}
+	if len(dictionary) == 0 {
+		return badRequest("The ODM document has no fields")
+	}
+	if err := dictionary.Validate(); err != nil {
+		return badRequest("%s", err)
+	}
+	p.Metadata = dictionary
+
+	for _, def := range version.StudyEventDefs {
+		p.Info.IsLongitudinal = true
+		if !contains(p.armNumbers(), def.ArmNum) {
+			p.Arms = append(p.Arms, Row{"arm_num": def.ArmNum, "name": def.ArmName})
+		}
+		p.Events = append(p.Events, Row{
+			"event_name":         def.EventName,
+			"arm_num":            def.ArmNum,
+			"unique_event_name":  def.UniqueEventName,
+			"custom_event_label": def.CustomEventLabel,
+			"event_id":           fmt.Sprint(p.newID()),
+			"days_offset":        def.DayOffset,
+			"offset_min":         def.OffsetMin,
+			"offset_max":         def.OffsetMax,
+		})
+		for _, ref := range def.FormRefs {
+			formName := ref.FormName
+			if formName == "" {
+				formName = strings.TrimPrefix(ref.FormOID, "Form.")
+			}
+			p.Mappings = append(p.Mappings, redcap.FormEventMapping{ArmNum: json.Number(def.ArmNum), UniqueEventName: def.UniqueEventName, Form: formName})
+		}
+	}
+
+	repeatingForms := make(map[string]bool)
+	repeatingEvents := make(map[string]bool)
+	if setup := odm.Study.GlobalVariables.RepeatingInstrumentsAndEvents; setup != nil {
+		p.Info.HasRepeatingInstrumentsOrEvents = true
+		if setup.RepeatingInstruments != nil {
+			for _, instrument := range setup.RepeatingInstruments.RepeatingInstruments {
+				repeatingForms[instrument.RepeatInstrument] = true
+			}
+		}
+		for _, event := range setup.RepeatingEvents {
+			repeatingEvents[event.UniqueEventName] = true
+		}
+	}
+
+	if odm.ClinicalData == nil {
+		return nil
+	}
+	recordID := dictionary.RecordIDField()
+	for _, subject := range odm.ClinicalData.SubjectData {
+		for _, event := range subject.StudyEventData {
+			for _, formData := range event.FormData {
+				formName := strings.TrimPrefix(formData.FormOID, "Form.")
+				key := redcap.Record{recordID: subject.SubjectKey}
+				if p.longitudinal() {
+					key[redcap.EventNameColumn] = event.UniqueEventName
+				}
+				switch {
+				case repeatingForms[formName] && formData.FormRepeatKey != "":
+					key[redcap.RepeatInstrumentColumn] = formName
+					key[redcap.RepeatInstanceColumn] = formData.FormRepeatKey
+				case repeatingEvents[event.UniqueEventName] && event.StudyEventRepeatKey != "":
+					key[redcap.RepeatInstanceColumn] = event.StudyEventRepeatKey
+				}
+
+				var row redcap.Record
+				for _, existing := range p.Records {
+					if p.sameRow(existing, key) {
+						row = existing
+					}
+				}
+				if row == nil {
+					row = key
+					p.Records = append(p.Records, row)
+				}
+				for _, group := range formData.ItemGroupData {
+					for _, item := range group.ItemData {
+						row[item.ItemOID] = item.Value
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Project) armNumbers() []string {
+	var numbers []string
+	for _, arm := range p.Arms {
+		numbers = append(numbers, arm["arm_num"])
+	}
+	return numbers
+}
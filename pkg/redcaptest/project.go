@@ -0,0 +1,409 @@
+package redcaptest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// File is a file stored in a file upload field or the File Repository.
+type File struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// FileKey locates a file upload field value.
+type FileKey struct {
+	Record         string
+	Field          string
+	Event          string
+	RepeatInstance string
+}
+
+// RepositoryEntry is a folder or file of the File Repository. Files have
+// a File; folders do not.
+type RepositoryEntry struct {
+	ID       int
+	ParentID int
+	Name     string
+	File     *File
+}
+
+// Project is an in-memory REDCap project. Records are stored flat, one row
+// per record, event and repeat instance, keyed by export column name.
+type Project struct {
+	Token    string
+	Info     redcap.ProjectInfo
+	Username string
+
+	Metadata         redcap.DataDictionary
+	InstrumentLabels map[string]string
+	Arms             []Row
+	Events           []Row
+	Mappings         []redcap.FormEventMapping
+	Records          []redcap.Record
+	Files            map[FileKey]File
+	Repository       []RepositoryEntry
+	Users            []Row
+	UserRoles        []Row
+	DAGs             []Row
+	UserDAGs         []Row
+	UserRoleMaps     []Row
+	Logging          []redcap.LogEntry
+	// Surveys lists the instruments enabled as surveys, with their
+	// participant lists.
+	Surveys map[string][]redcap.SurveyParticipant
+	Reports map[string][]Row
+	// DAG is the data access group the API user is currently in.
+	DAG string
+
+	server *Server
+	nextID int
+}
+
+/*
+	NewProject creates an empty classic project with a data dictionary.
+	
+	Args:
+		title: The project title.
+		metadata: The data dictionary. The first field is the record ID.
+	
+	Returns:
+		The project, ready for Server.AddProject.
+*/
+func NewProject(title string, metadata redcap.DataDictionary) *Project {
+	return &Project{
+		Info:             redcap.ProjectInfo{ProjectTitle: title, Purpose: redcap.PurposePractice},
+		Username:         "api_user",
+		Metadata:         metadata,
+		InstrumentLabels: make(map[string]string),
+		Files:            make(map[FileKey]File),
+		Surveys:          make(map[string][]redcap.SurveyParticipant),
+		Reports:          make(map[string][]Row),
+		nextID:           1,
+	}
+}
+
+func (p *Project) newID() int {
+	if p.nextID == 0 {
+		p.nextID = 1
+	}
+	p.nextID++
+	return p.nextID - 1
+}
+
+// log records an action in the project's logging.
+func (p *Project) log(action string, details string) {
+	p.Logging = append(p.Logging, redcap.LogEntry{
+		Timestamp: p.server.Now().Format(redcap.LoggingTimeFormat),
+		Username:  p.Username,
+		Action:    action,
+		Details:   details,
+	})
+}
+
+func (p *Project) longitudinal() bool {
+	return bool(p.Info.IsLongitudinal)
+}
+
+func (p *Project) hasForm(form string) bool {
+	return contains(p.Metadata.Forms(), form)
+}
+
+func (p *Project) serve(w http.ResponseWriter, req *http.Request, form url.Values) error {
+	content := form.Get("content")
+	action := form.Get("action")
+	if action == "" && form.Get("data") != "" {
+		action = "import"
+	}
+	if action == "" {
+		action = "export"
+	}
+
+	switch content + ":" + action {
+	case "version:export":
+		writeText(w, p.server.Version)
+		return nil
+	case "project:export":
+		return p.exportProject(w, form)
+	case "project_settings:import":
+		return p.importProjectSettings(w, form)
+	case "project_xml:export":
+		return p.exportProjectXML(w, form)
+	case "metadata:export":
+		return p.exportMetadata(w, form)
+	case "metadata:import":
+		return p.importMetadata(w, form)
+	case "instrument:export":
+		return p.exportInstruments(w, form)
+	case "exportFieldNames:export":
+		return p.exportFieldNames(w, form)
+	case "arm:export":
+		return p.exportArms(w, form)
+	case "arm:import":
+		return p.importArms(w, form)
+	case "arm:delete":
+		return p.deleteArms(w, form)
+	case "event:export":
+		return p.exportEvents(w, form)
+	case "event:import":
+		return p.importEvents(w, form)
+	case "event:delete":
+		return p.deleteEvents(w, form)
+	case "formEventMapping:export":
+		return p.exportMappings(w, form)
+	case "formEventMapping:import":
+		return p.importMappings(w, form)
+	case "record:export":
+		return p.exportRecords(w, form)
+	case "record:import":
+		return p.importRecords(w, form)
+	case "record:delete":
+		return p.deleteRecords(w, form)
+	case "record:rename":
+		return p.renameRecord(w, form)
+	case "file:export":
+		return p.exportFile(w, form)
+	case "file:import":
+		return p.importFile(w, req, form)
+	case "file:delete":
+		return p.deleteFile(w, form)
+	case "fileRepository:createFolder":
+		return p.createRepositoryFolder(w, form)
+	case "fileRepository:list":
+		return p.listRepository(w, form)
+	case "fileRepository:export":
+		return p.exportRepositoryFile(w, form)
+	case "fileRepository:import":
+		return p.importRepositoryFile(w, req, form)
+	case "fileRepository:delete":
+		return p.deleteRepositoryFile(w, form)
+	case "user:export":
+		return writeRows(w, form.Get("format"), nil, p.Users)
+	case "user:import":
+		return p.importUsers(w, form)
+	case "user:delete":
+		if err := p.deleteRows(w, form, &p.Users, "username", "users"); err != nil {
+			return err
+		}
+		for _, user := range array(form, "users") {
+			p.log("Deleted User "+user, "")
+		}
+		return nil
+	case "userRole:export":
+		return writeRows(w, form.Get("format"), nil, p.UserRoles)
+	case "userRole:import":
+		return p.importRows(w, form, &p.UserRoles, "unique_role_name", func(row Row) {
+			if row["unique_role_name"] == "" {
+				row["unique_role_name"] = "U-" + newToken(5)
+			}
+		})
+	case "userRole:delete":
+		return p.deleteRows(w, form, &p.UserRoles, "unique_role_name", "roles")
+	case "userRoleMapping:export":
+		return writeRows(w, form.Get("format"), []string{"username", "unique_role_name"}, p.UserRoleMaps)
+	case "userRoleMapping:import":
+		return p.importUserRoleMaps(w, form)
+	case "dag:export":
+		return writeRows(w, form.Get("format"), []string{"data_access_group_name", "unique_group_name", "data_access_group_id"}, p.DAGs)
+	case "dag:import":
+		return p.importRows(w, form, &p.DAGs, "unique_group_name", func(row Row) {
+			if row["unique_group_name"] == "" {
+				row["unique_group_name"] = uniqueName(row["data_access_group_name"], 18)
+			}
+			if row["data_access_group_id"] == "" {
+				row["data_access_group_id"] = fmt.Sprint(p.newID())
+			}
+		})
+	case "dag:delete":
+		return p.deleteRows(w, form, &p.DAGs, "unique_group_name", "dags")
+	case "dag:switch":
+		return p.switchDAG(w, form)
+	case "userDagMapping:export":
+		return writeRows(w, form.Get("format"), []string{"username", "redcap_data_access_group"}, p.UserDAGs)
+	case "userDagMapping:import":
+		return p.importUserDAGs(w, form)
+	case "log:export":
+		return p.exportLogging(w, form)
+	case "participantList:export":
+		return p.exportParticipants(w, form)
+	case "surveyLink:export", "surveyQueueLink:export", "surveyReturnCode:export":
+		return p.exportSurveyAccess(w, form)
+	case "pdf:export":
+		return p.exportPDF(w, form)
+	case "report:export":
+		return p.exportReport(w, form)
+	}
+	return badRequest("The fake REDCap server does not support content %q with action %q", content, action)
+}
+
+// importRows upserts generic rows by a key column. Prepare, when set, fills
+// in generated values before a row is stored.
+func (p *Project) importRows(w http.ResponseWriter, form url.Values, table *[]Row, key string, prepare func(Row)) error {
+	rows, err := parseData(form.Get("format"), form.Get("data"))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if prepare != nil {
+			prepare(row)
+		}
+		if row[key] == "" {
+			return badRequest("%s is missing from an imported row", key)
+		}
+		replaced := false
+		for i, existing := range *table {
+			if existing[key] == row[key] {
+				for column, value := range row {
+					existing[column] = value
+				}
+				(*table)[i] = existing
+				replaced = true
+			}
+		}
+		if !replaced {
+			*table = append(*table, row)
+		}
+	}
+	return writeCount(w, form, len(rows))
+}
+
+// importUsers imports users like importRows and logs the change of each
+// user's rights.
+func (p *Project) importUsers(w http.ResponseWriter, form url.Values) error {
+	rows, err := parseData(form.Get("format"), form.Get("data"))
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool)
+	for _, user := range p.Users {
+		known[user["username"]] = true
+	}
+	if err := p.importRows(w, form, &p.Users, "username", nil); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		var details []string
+		for column, value := range row {
+			if column != "username" {
+				details = append(details, fmt.Sprintf("%s = '%s'", column, value))
+			}
+		}
+		sort.Strings(details)
+		action := "Created User "
+		if known[row["username"]] {
+			action = "Updated User "
+		}
+		p.log(action+row["username"], strings.Join(details, ", "))
+	}
+	return nil
+}
+
+// deleteRows removes generic rows named by an array parameter.
+func (p *Project) deleteRows(w http.ResponseWriter, form url.Values, table *[]Row, key string, parameter string) error {
+	names := array(form, parameter)
+	if len(names) == 0 {
+		return badRequest("No %s were provided", parameter)
+	}
+	for _, name := range names {
+		found := false
+		for _, row := range *table {
+			found = found || row[key] == name
+		}
+		if !found {
+			return badRequest("The following %s do not exist: %s", parameter, name)
+		}
+	}
+
+	kept := (*table)[:0]
+	for _, row := range *table {
+		if !contains(names, row[key]) {
+			kept = append(kept, row)
+		}
+	}
+	*table = kept
+	return writeCount(w, form, len(names))
+}
+
+func (p *Project) switchDAG(w http.ResponseWriter, form url.Values) error {
+	dag := form.Get("dag")
+	for _, row := range p.DAGs {
+		if row["unique_group_name"] == dag {
+			p.DAG = dag
+			writeText(w, "1")
+			return nil
+		}
+	}
+	return badRequest("The data access group %q does not exist", dag)
+}
+
+func (p *Project) importUserDAGs(w http.ResponseWriter, form url.Values) error {
+	rows, err := parseData(form.Get("format"), form.Get("data"))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		dag := row["redcap_data_access_group"]
+		found := dag == ""
+		for _, group := range p.DAGs {
+			found = found || group["unique_group_name"] == dag
+		}
+		if !found {
+			return badRequest("The data access group %q does not exist", dag)
+		}
+	}
+	for _, row := range rows {
+		kept := p.UserDAGs[:0]
+		for _, existing := range p.UserDAGs {
+			if existing["username"] != row["username"] {
+				kept = append(kept, existing)
+			}
+		}
+		p.UserDAGs = append(kept, Row{"username": row["username"], "redcap_data_access_group": row["redcap_data_access_group"]})
+	}
+	return writeCount(w, form, len(rows))
+}
+
+func (p *Project) importUserRoleMaps(w http.ResponseWriter, form url.Values) error {
+	rows, err := parseData(form.Get("format"), form.Get("data"))
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		role := row["unique_role_name"]
+		found := role == ""
+		for _, existing := range p.UserRoles {
+			found = found || existing["unique_role_name"] == role
+		}
+		if !found {
+			return badRequest("The user role %q does not exist", role)
+		}
+	}
+	for _, row := range rows {
+		kept := p.UserRoleMaps[:0]
+		for _, existing := range p.UserRoleMaps {
+			if existing["username"] != row["username"] {
+				kept = append(kept, existing)
+			}
+		}
+		p.UserRoleMaps = append(kept, Row{"username": row["username"], "unique_role_name": row["unique_role_name"]})
+	}
+	return writeCount(w, form, len(rows))
+}
+
+// instrumentLabel returns the display name of an instrument.
+func (p *Project) instrumentLabel(form string) string {
+	if label, ok := p.InstrumentLabels[form]; ok {
+		return label
+	}
+	label := strings.ReplaceAll(form, "_", " ")
+	if label == "" {
+		return label
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
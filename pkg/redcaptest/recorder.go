@@ -0,0 +1,522 @@
+package redcaptest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// RecorderMode selects whether a Recorder talks to a real server or plays
+// back a golden file.
+type RecorderMode int
+
+const (
+	// Replay answers requests from the golden file without any network.
+	Replay RecorderMode = iota
+	// Record sends requests to the server and keeps what it answers.
+	Record
+)
+
+// Redacted replaces API tokens and PHI values in golden files.
+const Redacted = "[REDACTED]"
+
+// tokenPattern matches REDCap API and super API tokens.
+var tokenPattern = regexp.MustCompile(`\b([0-9A-F]{32}|[0-9A-F]{64})\b`)
+
+// Interaction is a request and the response REDCap gave to it.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is a request as stored in a golden file: the form it
+// posted, with uploaded files reduced to their names.
+type RecordedRequest struct {
+	Form  url.Values        `json:"form"`
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// RecordedResponse is a response as stored in a golden file. Bodies that
+// are not valid UTF-8, such as PDFs, are kept base64 encoded.
+type RecordedResponse struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body,omitempty"`
+	BodyBase64  string `json:"body_base64,omitempty"`
+}
+
+// Recorder is an http.RoundTripper that records REDCap requests and
+// responses to a golden file, or replays them from one. Tokens are always
+// scrubbed; values of the fields listed in PHIFields are scrubbed from
+// imported data and exported responses in JSON, CSV and XML. With
+// PHIFields set, data in any other format or that cannot be parsed fails
+// the request rather than be recorded, as does any other text response
+// holding more than a single value such as a count or a link.
+//
+// Replayed requests must arrive in the order they were recorded, and must
+// post the same form once scrubbed.
+type Recorder struct {
+	Mode RecorderMode
+	Path string
+	// PHIFields names the fields whose values never reach the golden file.
+	PHIFields []string
+	// Transport sends requests when recording. It defaults to
+	// http.DefaultTransport.
+	Transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+	next         int
+}
+
+/*
+	NewRecorder creates a recorder for a golden file. In Replay mode the
+	file is read straight away.
+	
+	Args:
+		path: The golden file, usually under testdata.
+		mode: Record to capture a real server, Replay to play it back.
+		phiFields: Fields whose values are scrubbed.
+	
+	Returns:
+		The recorder, or an error if the golden file cannot be read.
+*/
+func NewRecorder(path string, mode RecorderMode, phiFields ...string) (*Recorder, error) {
+	recorder := &Recorder{Mode: mode, Path: path, PHIFields: phiFields}
+	if mode == Replay {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(content, &recorder.interactions); err != nil {
+			return nil, fmt.Errorf("redcaptest: reading %s: %w", path, err)
+		}
+	}
+	return recorder, nil
+}
+
+/*
+	Client returns an HTTP client that sends requests through the
+	recorder, for RedCapClient.HTTPClient.
+	
+	Args:
+		None
+	
+	Returns:
+		The client.
+*/
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip records or replays a single request.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	request, body, err := r.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.Mode == Replay {
+		if r.next >= len(r.interactions) {
+			return nil, fmt.Errorf("redcaptest: no recorded response left in %s for %s", r.Path, request.Form.Get("content"))
+		}
+		interaction := r.interactions[r.next]
+		if !reflect.DeepEqual(interaction.Request, request) {
+			return nil, fmt.Errorf("redcaptest: request %d does not match %s: recorded %v, got %v", r.next+1, r.Path, interaction.Request.Form, request.Form)
+		}
+		r.next++
+		return interaction.Response.response(req)
+	}
+
+	transport := r.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	out := req.Clone(req.Context())
+	out.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	recorded := RecordedResponse{StatusCode: resp.StatusCode, ContentType: contentType}
+	if body, err = r.scrubBody(contentType, body); err != nil {
+		return nil, err
+	}
+	if utf8.Valid(body) {
+		recorded.Body = string(body)
+	} else {
+		recorded.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	r.interactions = append(r.interactions, Interaction{Request: request, Response: recorded})
+	return recorded.response(req)
+}
+
+/*
+	Save writes the recorded interactions to the golden file, creating its
+	directory if needed. It does nothing in Replay mode.
+	
+	Args:
+		None
+	
+	Returns:
+		An error if the file cannot be written.
+*/
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Mode != Record {
+		return nil
+	}
+	content, err := json.MarshalIndent(r.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.Path, append(content, '\n'), 0o644)
+}
+
+/*
+	Unused reports how many recorded interactions a replay has not reached,
+	so tests can check that every request still happens.
+	
+	Args:
+		None
+	
+	Returns:
+		The number of interactions left.
+*/
+func (r *Recorder) Unused() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Mode != Replay {
+		return 0
+	}
+	return len(r.interactions) - r.next
+}
+
+// response builds the HTTP response a recorded response stands for.
+func (rr RecordedResponse) response(req *http.Request) (*http.Response, error) {
+	body := []byte(rr.Body)
+	if rr.BodyBase64 != "" {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(rr.BodyBase64); err != nil {
+			return nil, err
+		}
+	}
+	header := make(http.Header)
+	if rr.ContentType != "" {
+		header.Set("Content-Type", rr.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rr.StatusCode, http.StatusText(rr.StatusCode)),
+		StatusCode:    rr.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// recordRequest reads the form a request posts, scrubbed, along with the
+// raw body so the request can still be sent.
+func (r *Recorder) recordRequest(req *http.Request) (RecordedRequest, []byte, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return RecordedRequest{}, nil, err
+		}
+	}
+
+	parsed := &http.Request{Method: http.MethodPost, Header: req.Header, Body: io.NopCloser(bytes.NewReader(body))}
+	request := RecordedRequest{}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := parsed.ParseMultipartForm(32 << 20); err != nil {
+			return RecordedRequest{}, nil, err
+		}
+		request.Form = url.Values(parsed.MultipartForm.Value)
+		for name, files := range parsed.MultipartForm.File {
+			if request.Files == nil {
+				request.Files = make(map[string]string)
+			}
+			request.Files[name] = files[0].Filename
+		}
+	} else {
+		if err := parsed.ParseForm(); err != nil {
+			return RecordedRequest{}, nil, err
+		}
+		request.Form = parsed.PostForm
+	}
+
+	for name, values := range request.Form {
+		for i, value := range values {
+			switch {
+			case name == "token":
+				values[i] = Redacted
+			case name == "data":
+				scrubbed, err := r.scrubData(request.Form.Get("format"), []byte(value))
+				if err != nil {
+					return RecordedRequest{}, nil, err
+				}
+				values[i] = string(scrubbed)
+			case r.phi(name):
+				values[i] = Redacted
+			}
+		}
+	}
+	return request, body, nil
+}
+
+// phi reports whether a field, or a checkbox column such as colour___2
+// exported for one, is a PHI field.
+func (r *Recorder) phi(field string) bool {
+	for _, name := range r.PHIFields {
+		if name == field {
+			return true
+		}
+		if choice := strings.TrimPrefix(field, name+"___"); choice != field && choice != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// plainValue matches a text response holding a single value, such as a
+// count, a version or a survey link, which cannot carry record data.
+var plainValue = regexp.MustCompile(`^[^\s,;"<>{}\[\]]*$`)
+
+// scrubBody removes tokens and PHI values from a response body.
+func (r *Recorder) scrubBody(contentType string, body []byte) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var err error
+	switch mediaType {
+	case "application/json":
+		body, err = r.scrubData("json", body)
+	case "text/csv":
+		body, err = r.scrubData("csv", body)
+	case "application/xml", "text/xml":
+		body, err = r.scrubData("xml", body)
+	default:
+		text := mediaType == "" || strings.HasPrefix(mediaType, "text/")
+		if len(r.PHIFields) > 0 && text && !plainValue.Match(bytes.TrimSpace(body)) {
+			err = fmt.Errorf("redcaptest: cannot scrub a response of type %q", contentType)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if utf8.Valid(body) {
+		body = tokenPattern.ReplaceAll(body, []byte(Redacted))
+	}
+	return body, nil
+}
+
+// scrubData replaces the values of PHI fields in JSON, CSV or XML data.
+// Data it cannot parse is an error, so PHI never reaches a golden file
+// unscrubbed.
+func (r *Recorder) scrubData(format string, data []byte) ([]byte, error) {
+	if len(r.PHIFields) == 0 || len(bytes.TrimSpace(data)) == 0 {
+		return data, nil
+	}
+	switch format {
+	case "json", "":
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return nil, fmt.Errorf("redcaptest: cannot scrub JSON data: %w", err)
+		}
+		if !r.scrubJSON(value) {
+			return data, nil
+		}
+		return json.Marshal(value)
+	case "csv":
+		lines, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("redcaptest: cannot scrub CSV data: %w", err)
+		}
+		changed := false
+		for i, column := range lines[0] {
+			for _, line := range lines[1:] {
+				if i >= len(line) || line[i] == "" {
+					continue
+				}
+				if r.phi(column) {
+					line[i] = Redacted
+					changed = true
+				} else if column == "details" {
+					var scrubbed bool
+					if line[i], scrubbed = r.scrubLogDetails(line[i]); scrubbed {
+						changed = true
+					}
+				}
+			}
+		}
+		if !changed {
+			return data, nil
+		}
+		var b strings.Builder
+		writer := csv.NewWriter(&b)
+		writer.WriteAll(lines)
+		return []byte(b.String()), nil
+	case "xml", "odm":
+		return r.scrubXML(data)
+	}
+	return nil, fmt.Errorf("redcaptest: cannot scrub %s data", format)
+}
+
+// itemDataPattern matches the ItemData elements of an ODM document, and
+// itemDataAttribute their ItemOID and Value attributes.
+var (
+	itemDataPattern   = regexp.MustCompile(`<ItemData\b[^>]*>`)
+	itemDataAttribute = regexp.MustCompile(`\s(ItemOID|Value)="([^"]*)"`)
+)
+
+// emptyXMLValue matches the content of an element holding no value.
+var emptyXMLValue = regexp.MustCompile(`^\s*(<!\[CDATA\[\]\]>)?\s*$`)
+
+// scrubXML replaces PHI values in XML data: the Value of ODM ItemData
+// elements whose ItemOID is a PHI field, and the content of elements named
+// after a PHI field or its checkbox columns, as in REDCap's flat XML records.
+func (r *Recorder) scrubXML(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("redcaptest: cannot scrub XML data: %w", err)
+		}
+	}
+
+	data = itemDataPattern.ReplaceAllFunc(data, func(element []byte) []byte {
+		phi := false
+		for _, match := range itemDataAttribute.FindAllSubmatch(element, -1) {
+			if string(match[1]) == "ItemOID" && r.phi(string(match[2])) {
+				phi = true
+			}
+		}
+		if !phi {
+			return element
+		}
+		return itemDataAttribute.ReplaceAllFunc(element, func(attribute []byte) []byte {
+			match := itemDataAttribute.FindSubmatch(attribute)
+			if string(match[1]) != "Value" || len(match[2]) == 0 {
+				return attribute
+			}
+			return []byte(` Value="` + Redacted + `"`)
+		})
+	})
+	for _, field := range r.PHIFields {
+		name := regexp.QuoteMeta(field) + `(?:___[^\s/>]+)?`
+		element := regexp.MustCompile(`(?s)(<` + name + `(?:\s[^>]*)?>)(.*?)(</` + name + `\s*>)`)
+		data = element.ReplaceAllFunc(data, func(found []byte) []byte {
+			match := element.FindSubmatch(found)
+			if emptyXMLValue.Match(match[2]) {
+				return found
+			}
+			return []byte(string(match[1]) + Redacted + string(match[3]))
+		})
+	}
+	return data, nil
+}
+
+// scrubJSON replaces PHI values in decoded JSON in place and reports
+// whether anything changed.
+func (r *Recorder) scrubJSON(value interface{}) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			details, isText := item.(string)
+			if r.phi(key) && item != nil && item != "" {
+				v[key] = Redacted
+				changed = true
+			} else if key == "details" && isText {
+				if scrubbed, ok := r.scrubLogDetails(details); ok {
+					v[key] = scrubbed
+					changed = true
+				}
+			} else if r.scrubJSON(item) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if r.scrubJSON(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// scrubLogDetails replaces PHI values in the details of a log entry, such
+// as "name = 'Ada', age = '36'", and reports whether anything changed.
+func (r *Recorder) scrubLogDetails(details string) (string, bool) {
+	var b strings.Builder
+	changed := false
+	rest := details
+	for rest != "" {
+		name, after, ok := strings.Cut(rest, " = ")
+		if !ok {
+			b.WriteString(rest)
+			break
+		}
+		var value, separator string
+		if strings.HasPrefix(after, "'") {
+			// A quoted value ends at a quote followed by the next pair or
+			// the end of the details.
+			if end := strings.Index(after[1:], "', "); end < 0 {
+				value, rest = after, ""
+			} else {
+				value, separator, rest = after[:end+2], ", ", after[end+4:]
+			}
+		} else {
+			var more bool
+			if value, rest, more = strings.Cut(after, ", "); more {
+				separator = ", "
+			}
+		}
+
+		field := strings.TrimSpace(name)
+		if open := strings.Index(field, "("); open > 0 {
+			field = field[:open]
+		}
+		if r.phi(field) && value != "" && value != "''" {
+			value = "'" + Redacted + "'"
+			changed = true
+		}
+		b.WriteString(name + " = " + value + separator)
+	}
+	return b.String(), changed
+}
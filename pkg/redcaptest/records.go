@@ -0,0 +1,592 @@
+package redcaptest
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// dataColumns lists the data columns of a flat export in dictionary order,
+// with each form's completion status after its last field.
+func (p *Project) dataColumns() []string {
+	var columns []string
+	for i, field := range p.Metadata {
+		columns = append(columns, field.ExportColumns()...)
+		if i == len(p.Metadata)-1 || p.Metadata[i+1].FormName != field.FormName {
+			columns = append(columns, field.FormName+"_complete")
+		}
+	}
+	return columns
+}
+
+// columnFields maps each data column to its field and form.
+func (p *Project) columnFields() map[string][2]string {
+	fields := make(map[string][2]string)
+	for _, field := range p.Metadata {
+		for _, column := range field.ExportColumns() {
+			fields[column] = [2]string{field.FieldName, field.FormName}
+		}
+		complete := field.FormName + "_complete"
+		fields[complete] = [2]string{complete, field.FormName}
+	}
+	return fields
+}
+
+func (p *Project) keyColumns() []string {
+	columns := []string{p.Metadata.RecordIDField()}
+	if p.longitudinal() {
+		columns = append(columns, redcap.EventNameColumn)
+	}
+	if p.Info.HasRepeatingInstrumentsOrEvents {
+		columns = append(columns, redcap.RepeatInstrumentColumn, redcap.RepeatInstanceColumn)
+	}
+	return columns
+}
+
+func (p *Project) hasEvent(event string) bool {
+	for _, e := range p.Events {
+		if e["unique_event_name"] == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Project) hasRecord(record string) bool {
+	recordID := p.Metadata.RecordIDField()
+	for _, row := range p.Records {
+		if row[recordID] == record {
+			return true
+		}
+	}
+	return false
+}
+
+// sameRow reports whether two rows are for the same record, event and instance.
+func (p *Project) sameRow(a redcap.Record, b redcap.Record) bool {
+	for _, column := range []string{p.Metadata.RecordIDField(), redcap.EventNameColumn, redcap.RepeatInstrumentColumn, redcap.RepeatInstanceColumn} {
+		if a[column] != b[column] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Project) exportRecords(w http.ResponseWriter, form url.Values) error {
+	if t := form.Get("type"); t != "" && t != "flat" {
+		return badRequest("The fake REDCap server only exports flat records")
+	}
+	if form.Get("rawOrLabel") == "label" {
+		return badRequest("The fake REDCap server only exports raw values")
+	}
+	if form.Get("filterLogic") != "" {
+		return badRequest("The fake REDCap server does not support filterLogic")
+	}
+	modified, err := p.modifiedRecords(form)
+	if err != nil {
+		return err
+	}
+
+	records, fields, forms, events := array(form, "records"), array(form, "fields"), array(form, "forms"), array(form, "events")
+	for _, field := range fields {
+		if p.Metadata.Field(field) == nil {
+			return badRequest("The following values in the parameter \"fields\" are not valid: %s", field)
+		}
+	}
+	for _, event := range events {
+		if !p.hasEvent(event) {
+			return badRequest("The following values in the parameter \"events\" are not valid: %s", event)
+		}
+	}
+
+	surveyFields := form.Get("exportSurveyFields") == "true"
+	columns := p.keyColumns()
+	if surveyFields && len(p.Surveys) > 0 {
+		columns = append(columns, "redcap_survey_identifier")
+	}
+	if form.Get("exportDataAccessGroups") == "true" {
+		columns = append(columns, redcap.DataAccessGroupColumn)
+	}
+
+	recordID := p.Metadata.RecordIDField()
+	located := p.columnFields()
+	started := make(map[string]bool)
+	for _, column := range p.dataColumns() {
+		field, formName := located[column][0], located[column][1]
+		if column == recordID {
+			continue
+		}
+		if (len(fields) > 0 || len(forms) > 0) && !contains(fields, field) && !contains(forms, formName) {
+			continue
+		}
+		if _, ok := p.Surveys[formName]; ok && surveyFields && !started[formName] {
+			columns = append(columns, formName+"_timestamp")
+		}
+		started[formName] = true
+		columns = append(columns, column)
+	}
+
+	var rows []Row
+	for _, record := range p.Records {
+		if len(records) > 0 && !contains(records, record[recordID]) {
+			continue
+		}
+		if len(events) > 0 && !contains(events, record[redcap.EventNameColumn]) {
+			continue
+		}
+		if modified != nil && !modified[record[recordID]] {
+			continue
+		}
+		if instrument := record[redcap.RepeatInstrumentColumn]; instrument != "" && (len(fields) > 0 || len(forms) > 0) && !started[instrument] {
+			continue
+		}
+		if p.DAG != "" && record[redcap.DataAccessGroupColumn] != p.DAG {
+			continue
+		}
+		row := make(Row, len(columns))
+		for _, column := range columns {
+			row[column] = record[column]
+		}
+		rows = append(rows, row)
+	}
+	return writeRows(w, form.Get("format"), columns, rows)
+}
+
+// modifiedRecords finds the records created or updated within the
+// dateRangeBegin and dateRangeEnd of an export, or returns nil when neither
+// is set. The logging only keeps minutes, so an entry in the minute of
+// dateRangeBegin counts as inside the range.
+func (p *Project) modifiedRecords(form url.Values) (map[string]bool, error) {
+	var begin, end time.Time
+	var err error
+	if value := form.Get("dateRangeBegin"); value != "" {
+		if begin, err = time.Parse(redcap.DateRangeFormat, value); err != nil {
+			return nil, badRequest("The dateRangeBegin %q is not valid", value)
+		}
+	}
+	if value := form.Get("dateRangeEnd"); value != "" {
+		if end, err = time.Parse(redcap.DateRangeFormat, value); err != nil {
+			return nil, badRequest("The dateRangeEnd %q is not valid", value)
+		}
+	}
+	if begin.IsZero() && end.IsZero() {
+		return nil, nil
+	}
+
+	modified := make(map[string]bool)
+	for _, entry := range p.Logging {
+		record := strings.TrimPrefix(strings.TrimPrefix(entry.Action, "Created Record "), "Updated Record ")
+		if record == entry.Action {
+			continue
+		}
+		at, err := time.Parse(redcap.LoggingTimeFormat, entry.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		if at.Before(begin.Truncate(time.Minute)) || (!end.IsZero() && !at.Before(end)) {
+			continue
+		}
+		modified[record] = true
+	}
+	return modified, nil
+}
+
+func (p *Project) importRecords(w http.ResponseWriter, form url.Values) error {
+	if t := form.Get("type"); t != "" && t != "flat" {
+		return badRequest("The fake REDCap server only imports flat records")
+	}
+	rows, err := parseData(form.Get("format"), form.Get("data"))
+	if err != nil {
+		return err
+	}
+	overwrite := form.Get("overwriteBehavior") == "overwrite"
+
+	recordID := p.Metadata.RecordIDField()
+	located := p.columnFields()
+	var unknown []string
+	for _, row := range rows {
+		for column := range row {
+			switch column {
+			case redcap.EventNameColumn, redcap.RepeatInstrumentColumn, redcap.RepeatInstanceColumn, redcap.DataAccessGroupColumn:
+				continue
+			}
+			if _, ok := located[column]; !ok && !contains(unknown, column) {
+				unknown = append(unknown, column)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return badRequest("The following fields were not found in the project as real data fields: %s", strings.Join(unknown, ", "))
+	}
+
+	for _, row := range rows {
+		if row[recordID] == "" {
+			return badRequest("The record ID field %s is missing from an imported row", recordID)
+		}
+		if event := row[redcap.EventNameColumn]; p.longitudinal() && !p.hasEvent(event) {
+			return badRequest("The event %q of record %s is not valid", event, row[recordID])
+		}
+		if instrument := row[redcap.RepeatInstrumentColumn]; instrument != "" && !p.hasForm(instrument) {
+			return badRequest("The repeating instrument %q of record %s is not valid", instrument, row[recordID])
+		}
+	}
+
+	var ids []string
+	for _, row := range rows {
+		incoming := redcap.Record(row)
+		var details []string
+		for _, column := range p.dataColumns() {
+			if value, ok := row[column]; ok && value != "" && column != recordID {
+				details = append(details, fmt.Sprintf("%s = '%s'", column, value))
+			}
+		}
+
+		updated := false
+		for _, existing := range p.Records {
+			if !p.sameRow(existing, incoming) {
+				continue
+			}
+			for column, value := range row {
+				if value != "" || overwrite {
+					existing[column] = value
+				}
+			}
+			updated = true
+		}
+		if !updated {
+			action := "Updated Record "
+			if !p.hasRecord(row[recordID]) {
+				action = "Created Record "
+			}
+			stored := make(redcap.Record, len(row))
+			for column, value := range row {
+				stored[column] = value
+			}
+			p.Records = append(p.Records, stored)
+			p.log(action+row[recordID], strings.Join(details, ", "))
+		} else {
+			p.log("Updated Record "+row[recordID], strings.Join(details, ", "))
+		}
+
+		if !contains(ids, row[recordID]) {
+			ids = append(ids, row[recordID])
+		}
+	}
+
+	if form.Get("returnContent") == "ids" {
+		return writeJSON(w, ids)
+	}
+	return writeCount(w, form, len(ids))
+}
+
+// deleteRecords removes whole records, or with an instrument only that
+// instrument's data, narrowed by arm, event and repeat instance.
+func (p *Project) deleteRecords(w http.ResponseWriter, form url.Values) error {
+	records := array(form, "records")
+	if len(records) == 0 {
+		return badRequest("No records were provided")
+	}
+	var missing []string
+	for _, record := range records {
+		if !p.hasRecord(record) {
+			missing = append(missing, record)
+		}
+	}
+	if len(missing) > 0 {
+		return badRequest("One or more of the records provided cannot be deleted because they do not exist in the project. The following records do not exist: %s", strings.Join(missing, ", "))
+	}
+
+	arm, instrument, event, instance := form.Get("arm"), form.Get("instrument"), form.Get("event"), form.Get("repeat_instance")
+	if instrument != "" && !p.hasForm(instrument) {
+		return badRequest("The instrument %q does not exist", instrument)
+	}
+
+	recordID := p.Metadata.RecordIDField()
+	located := p.columnFields()
+	var kept []redcap.Record
+	for _, row := range p.Records {
+		matches := contains(records, row[recordID]) &&
+			(event == "" || row[redcap.EventNameColumn] == event) &&
+			(arm == "" || strings.HasSuffix(row[redcap.EventNameColumn], "_arm_"+arm)) &&
+			(instance == "" || row[redcap.RepeatInstanceColumn] == instance)
+		if !matches {
+			kept = append(kept, row)
+			continue
+		}
+		if instrument == "" || row[redcap.RepeatInstrumentColumn] == instrument {
+			continue
+		}
+		if row[redcap.RepeatInstrumentColumn] == "" {
+			for column, place := range located {
+				if place[1] == instrument {
+					delete(row, column)
+				}
+			}
+		}
+		kept = append(kept, row)
+	}
+	p.Records = kept
+
+	for _, record := range records {
+		if form.Get("delete_logging") == "1" {
+			var logging []redcap.LogEntry
+			for _, entry := range p.Logging {
+				if !strings.HasSuffix(entry.Action, " "+record) {
+					logging = append(logging, entry)
+				}
+			}
+			p.Logging = logging
+			continue
+		}
+		details := ""
+		if instrument != "" {
+			details = "instrument = '" + instrument + "'"
+		}
+		p.log("Deleted Record "+record, details)
+	}
+	writeText(w, strconv.Itoa(len(records)))
+	return nil
+}
+
+func (p *Project) renameRecord(w http.ResponseWriter, form url.Values) error {
+	record, renamed := form.Get("record"), form.Get("new_record_name")
+	if !p.hasRecord(record) {
+		return badRequest("The record %q does not exist", record)
+	}
+	if renamed == "" || p.hasRecord(renamed) {
+		return badRequest("The record cannot be renamed to %q", renamed)
+	}
+
+	recordID := p.Metadata.RecordIDField()
+	for _, row := range p.Records {
+		if row[recordID] == record {
+			row[recordID] = renamed
+		}
+	}
+	for key, file := range p.Files {
+		if key.Record == record {
+			delete(p.Files, key)
+			key.Record = renamed
+			p.Files[key] = file
+		}
+	}
+	p.log("Updated Record "+renamed, fmt.Sprintf("Record ID changed from %s", record))
+	writeText(w, "1")
+	return nil
+}
+
+// fileKey reads and checks the location of a file upload field value.
+func (p *Project) fileKey(form url.Values) (FileKey, error) {
+	key := FileKey{
+		Record:         form.Get("record"),
+		Field:          form.Get("field"),
+		Event:          form.Get("event"),
+		RepeatInstance: form.Get("repeat_instance"),
+	}
+	field := p.Metadata.Field(key.Field)
+	if field == nil || field.FieldType != "file" {
+		return key, badRequest("The field %q is not a file upload field", key.Field)
+	}
+	if !p.hasRecord(key.Record) {
+		return key, badRequest("The record %q does not exist", key.Record)
+	}
+	if p.longitudinal() && !p.hasEvent(key.Event) {
+		return key, badRequest("The event %q is not valid", key.Event)
+	}
+	return key, nil
+}
+
+// setFileValue stores the name of an uploaded file in the record's row,
+// creating the row if the event or instance has no data yet.
+func (p *Project) setFileValue(key FileKey, value string) {
+	recordID := p.Metadata.RecordIDField()
+	for _, row := range p.Records {
+		if row[recordID] == key.Record && row[redcap.EventNameColumn] == key.Event && row[redcap.RepeatInstanceColumn] == key.RepeatInstance {
+			row[key.Field] = value
+			return
+		}
+	}
+	if value == "" {
+		return
+	}
+	row := redcap.Record{recordID: key.Record, key.Field: value}
+	if key.Event != "" {
+		row[redcap.EventNameColumn] = key.Event
+	}
+	if key.RepeatInstance != "" {
+		row[redcap.RepeatInstanceColumn] = key.RepeatInstance
+		row[redcap.RepeatInstrumentColumn] = p.Metadata.Field(key.Field).FormName
+	}
+	p.Records = append(p.Records, row)
+}
+
+func writeFile(w http.ResponseWriter, file File) {
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", mime.FormatMediaType(contentType, map[string]string{"name": file.Name}))
+	w.Write(file.Data)
+}
+
+// uploadedFile reads the file of a multipart import.
+func uploadedFile(req *http.Request) (File, error) {
+	if req.MultipartForm == nil || len(req.MultipartForm.File["file"]) == 0 {
+		return File{}, badRequest("No valid file was uploaded")
+	}
+	header := req.MultipartForm.File["file"][0]
+	upload, err := header.Open()
+	if err != nil {
+		return File{}, err
+	}
+	defer upload.Close()
+
+	data, err := io.ReadAll(upload)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Name: header.Filename, ContentType: header.Header.Get("Content-Type"), Data: data}, nil
+}
+
+func (p *Project) exportFile(w http.ResponseWriter, form url.Values) error {
+	key, err := p.fileKey(form)
+	if err != nil {
+		return err
+	}
+	file, ok := p.Files[key]
+	if !ok {
+		return badRequest("There is no file to download for this record")
+	}
+	writeFile(w, file)
+	return nil
+}
+
+func (p *Project) importFile(w http.ResponseWriter, req *http.Request, form url.Values) error {
+	key, err := p.fileKey(form)
+	if err != nil {
+		return err
+	}
+	file, err := uploadedFile(req)
+	if err != nil {
+		return err
+	}
+	p.Files[key] = file
+	p.setFileValue(key, file.Name)
+	p.log("Updated Record "+key.Record, fmt.Sprintf("%s = '%s'", key.Field, file.Name))
+	return nil
+}
+
+func (p *Project) deleteFile(w http.ResponseWriter, form url.Values) error {
+	key, err := p.fileKey(form)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.Files[key]; !ok {
+		return badRequest("There is no file to delete for this record")
+	}
+	delete(p.Files, key)
+	p.setFileValue(key, "")
+	p.log("Updated Record "+key.Record, fmt.Sprintf("%s = ''", key.Field))
+	return nil
+}
+
+// repositoryEntry finds a File Repository entry by ID.
+func (p *Project) repositoryEntry(id string, folder bool) (int, error) {
+	n, err := strconv.Atoi(id)
+	if err == nil {
+		for i, entry := range p.Repository {
+			if entry.ID == n && (entry.File == nil) == folder {
+				return i, nil
+			}
+		}
+	}
+	if folder {
+		return 0, badRequest("The folder %q does not exist", id)
+	}
+	return 0, badRequest("The file %q does not exist", id)
+}
+
+// repositoryParent reads the folder_id of a File Repository request, 0 for
+// the top level.
+func (p *Project) repositoryParent(form url.Values) (int, error) {
+	if form.Get("folder_id") == "" {
+		return 0, nil
+	}
+	i, err := p.repositoryEntry(form.Get("folder_id"), true)
+	if err != nil {
+		return 0, err
+	}
+	return p.Repository[i].ID, nil
+}
+
+func (p *Project) createRepositoryFolder(w http.ResponseWriter, form url.Values) error {
+	parent, err := p.repositoryParent(form)
+	if err != nil {
+		return err
+	}
+	if form.Get("name") == "" {
+		return badRequest("The folder name is missing")
+	}
+	entry := RepositoryEntry{ID: p.newID(), ParentID: parent, Name: form.Get("name")}
+	p.Repository = append(p.Repository, entry)
+	return writeJSON(w, []map[string]int{{"folder_id": entry.ID}})
+}
+
+func (p *Project) listRepository(w http.ResponseWriter, form url.Values) error {
+	parent, err := p.repositoryParent(form)
+	if err != nil {
+		return err
+	}
+	items := []map[string]interface{}{}
+	for _, entry := range p.Repository {
+		if entry.ParentID != parent {
+			continue
+		}
+		if entry.File == nil {
+			items = append(items, map[string]interface{}{"folder_id": entry.ID, "name": entry.Name})
+		} else {
+			items = append(items, map[string]interface{}{"doc_id": entry.ID, "name": entry.Name})
+		}
+	}
+	return writeJSON(w, items)
+}
+
+func (p *Project) exportRepositoryFile(w http.ResponseWriter, form url.Values) error {
+	i, err := p.repositoryEntry(form.Get("doc_id"), false)
+	if err != nil {
+		return err
+	}
+	writeFile(w, *p.Repository[i].File)
+	return nil
+}
+
+func (p *Project) importRepositoryFile(w http.ResponseWriter, req *http.Request, form url.Values) error {
+	parent, err := p.repositoryParent(form)
+	if err != nil {
+		return err
+	}
+	file, err := uploadedFile(req)
+	if err != nil {
+		return err
+	}
+	p.Repository = append(p.Repository, RepositoryEntry{ID: p.newID(), ParentID: parent, Name: file.Name, File: &file})
+	return nil
+}
+
+func (p *Project) deleteRepositoryFile(w http.ResponseWriter, form url.Values) error {
+	i, err := p.repositoryEntry(form.Get("doc_id"), false)
+	if err != nil {
+		return err
+	}
+	p.Repository = append(p.Repository[:i], p.Repository[i+1:]...)
+	return nil
+}
@@ -0,0 +1,366 @@
+// Package redcaptest provides an in-memory fake REDCap server for testing
+// code that uses the redcap client without a real REDCap instance.
+package redcaptest
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// Row is a generic REDCap object such as an arm, event, user or data access
+// group, keyed by the names REDCap uses in its JSON.
+type Row map[string]string
+
+// Server is a fake REDCap API backed by in-memory projects. Each project is
+// reached with its own API token; SuperToken can create new projects.
+//
+// Projects may be read and changed directly between requests. Use Lock and
+// Unlock when doing so while requests may be in flight.
+type Server struct {
+	*httptest.Server
+
+	SuperToken string
+	Version    string
+	// Now returns the time used for logging. It defaults to time.Now.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	projects map[string]*Project
+	nextID   int
+}
+
+// apiError is a request REDCap refuses, answered with its status code and a
+// JSON error message.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+/*
+	NewServer starts a fake REDCap server with no projects. Close it when
+	done, as with httptest.Server.
+	
+	Args:
+		None
+	
+	Returns:
+		The running server.
+*/
+func NewServer() *Server {
+	s := &Server{
+		SuperToken: strings.Repeat("S", 64),
+		Version:    "14.0.0",
+		Now:        time.Now,
+		projects:   make(map[string]*Project),
+		nextID:     1,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Lock stops requests from being served until Unlock is called.
+func (s *Server) Lock() {
+	s.mu.Lock()
+}
+
+// Unlock lets requests be served again.
+func (s *Server) Unlock() {
+	s.mu.Unlock()
+}
+
+/*
+	AddProject serves a project under an API token. A project with an empty
+	token is given a random one.
+	
+	Args:
+		project: The project to serve.
+	
+	Returns:
+		The project's API token.
+*/
+func (s *Server) AddProject(project *Project) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addProject(project)
+}
+
+func (s *Server) addProject(project *Project) string {
+	if project.Token == "" {
+		project.Token = newToken(16)
+	}
+	if project.Info.ProjectID == 0 {
+		project.Info.ProjectID = s.nextID
+	}
+	s.nextID = project.Info.ProjectID + 1
+	project.server = s
+	s.projects[project.Token] = project
+	return project.Token
+}
+
+/*
+	Project returns the project served under a token.
+	
+	Args:
+		token: The project's API token.
+	
+	Returns:
+		The project, or nil if there is none.
+*/
+func (s *Server) Project(token string) *Project {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.projects[token]
+}
+
+/*
+	Client returns a client for the project served under a token.
+	
+	Args:
+		token: The project's API token.
+	
+	Returns:
+		A client using JSON responses.
+*/
+func (s *Server) Client(token string) redcap.RedCapClient {
+	return redcap.RedCapClient{URL: s.URL + "/api/", Token: token, ResponseFormat: redcap.JSON}
+}
+
+func newToken(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return strings.ToUpper(hex.EncodeToString(b))
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, &apiError{status: http.StatusMethodNotAllowed, message: "The REDCap API only accepts POST requests"})
+		return
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := req.ParseMultipartForm(32 << 20); err != nil {
+			writeError(w, badRequest("invalid multipart request: %s", err))
+			return
+		}
+	} else if err := req.ParseForm(); err != nil {
+		writeError(w, badRequest("invalid request: %s", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	form := req.PostForm
+	token := form.Get("token")
+	content := form.Get("content")
+
+	var err error
+	switch project := s.projects[token]; {
+	case token == s.SuperToken && content == "project":
+		err = s.createProject(w, form)
+	case project == nil:
+		err = &apiError{status: http.StatusForbidden, message: "You do not have permissions to use the API"}
+	default:
+		err = project.serve(w, req, form)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if e, ok := err.(*apiError); ok {
+		status = e.status
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func writeText(w http.ResponseWriter, text string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, text)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// writeCount answers an import or delete with the number of items affected.
+func writeCount(w http.ResponseWriter, form url.Values, count int) error {
+	if form.Get("format") == "json" && form.Get("content") == "record" {
+		return writeJSON(w, map[string]int{"count": count})
+	}
+	writeText(w, strconv.Itoa(count))
+	return nil
+}
+
+// writeRows answers an export in the requested format. Columns give the
+// CSV column order; when nil the keys of the rows are used, sorted.
+func writeRows(w http.ResponseWriter, format string, columns []string, rows []Row) error {
+	if rows == nil {
+		rows = []Row{}
+	}
+	switch format {
+	case "json":
+		return writeJSON(w, rows)
+	case "csv":
+		if columns == nil {
+			seen := make(map[string]bool)
+			for _, row := range rows {
+				for column := range row {
+					if !seen[column] {
+						seen[column] = true
+						columns = append(columns, column)
+					}
+				}
+			}
+			sort.Strings(columns)
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		writer := csv.NewWriter(w)
+		writer.Write(columns)
+		for _, row := range rows {
+			line := make([]string, len(columns))
+			for i, column := range columns {
+				line[i] = row[column]
+			}
+			writer.Write(line)
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return badRequest("The fake REDCap server does not support format %q", format)
+}
+
+// toRows converts typed values to rows through their JSON form.
+func toRows(v interface{}) ([]Row, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return parseData("json", string(payload))
+}
+
+// parseData reads the data parameter of an import, a JSON array or CSV
+// table of objects. JSON numbers and booleans are turned into strings.
+func parseData(format string, data string) ([]Row, error) {
+	switch format {
+	case "json", "":
+		var objects []map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &objects); err != nil {
+			return nil, badRequest("The data being imported is not formatted correctly: %s", err)
+		}
+		rows := make([]Row, len(objects))
+		for i, object := range objects {
+			rows[i] = make(Row, len(object))
+			for key, value := range object {
+				rows[i][key] = stringValue(value)
+			}
+		}
+		return rows, nil
+	case "csv":
+		lines, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, badRequest("The data being imported is not formatted correctly: %s", err)
+		}
+		var rows []Row
+		for _, line := range lines[min(1, len(lines)):] {
+			row := make(Row, len(line))
+			for i, value := range line {
+				row[lines[0][i]] = value
+			}
+			rows = append(rows, row)
+		}
+		return rows, nil
+	}
+	return nil, badRequest("The fake REDCap server does not support format %q", format)
+}
+
+func stringValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// array reads a REDCap array parameter sent as name[0], name[1], ... or as
+// repeated name[] or name values.
+func array(form url.Values, name string) []string {
+	var items []string
+	for i := 0; ; i++ {
+		value, ok := form[fmt.Sprintf("%s[%d]", name, i)]
+		if !ok {
+			break
+		}
+		items = append(items, value...)
+	}
+	items = append(items, form[name+"[]"]...)
+	for _, value := range form[name] {
+		if value != "" {
+			items = append(items, strings.Split(value, ",")...)
+		}
+	}
+	return items
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueName builds a REDCap unique name from a display name, keeping
+// lower case letters, digits and underscores.
+func uniqueName(name string, limit int) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '_' || r == '-':
+			b.WriteRune('_')
+		}
+	}
+	unique := b.String()
+	if limit > 0 && len(unique) > limit {
+		unique = unique[:limit]
+	}
+	return strings.Trim(unique, "_")
+}
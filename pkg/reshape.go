@@ -0,0 +1,372 @@
+package redcap
+
+import (
+	"fmt"
+)
+
+// Columns REDCap adds to flat exports of longitudinal, repeating and DAG projects.
+const (
+	EventNameColumn        = "redcap_event_name"
+	RepeatInstrumentColumn = "redcap_repeat_instrument"
+	RepeatInstanceColumn   = "redcap_repeat_instance"
+	DataAccessGroupColumn  = "redcap_data_access_group"
+)
+
+// InstrumentTable holds the rows of a single instrument, keyed by record,
+// event and repeat instance.
+type InstrumentTable struct {
+	Instrument string
+	Columns    []string
+	Rows       []Record
+}
+
+// EAVRow is a single value of an EAV record export.
+type EAVRow struct {
+	Record           string `json:"record"`
+	Event            string `json:"redcap_event_name,omitempty"`
+	RepeatInstrument string `json:"redcap_repeat_instrument,omitempty"`
+	RepeatInstance   string `json:"redcap_repeat_instance,omitempty"`
+	FieldName        string `json:"field_name"`
+	Value            string `json:"value"`
+}
+
+// LongRow is a single value in tidy long format. Checked checkbox choices
+// get one row each, with the choice code as the value.
+type LongRow struct {
+	Record         string
+	Event          string
+	Instrument     string
+	RepeatInstance string
+	Field          string
+	Value          string
+}
+
+// exportColumn describes one column of a flat export.
+type exportColumn struct {
+	name     string
+	field    string
+	form     string
+	code     string
+	checkbox bool
+	complete bool
+}
+
+// exportColumns lists the data columns of a flat export in dictionary
+// order, with each form's completion status after its last field.
+func (d DataDictionary) exportColumns() []exportColumn {
+	var columns []exportColumn
+	for i, field := range d {
+		if field.FieldType == "checkbox" {
+			choices, _ := ParseChoices(field.SelectChoicesOrCalculations)
+			for _, choice := range choices {
+				columns = append(columns, exportColumn{
+					name:     CheckboxColumn(field.FieldName, choice[0]),
+					field:    field.FieldName,
+					form:     field.FormName,
+					code:     choice[0],
+					checkbox: true,
+				})
+			}
+		} else {
+			for _, name := range field.ExportColumns() {
+				columns = append(columns, exportColumn{name: name, field: field.FieldName, form: field.FormName})
+			}
+		}
+		if i == len(d)-1 || d[i+1].FormName != field.FormName {
+			name := field.FormName + "_complete"
+			columns = append(columns, exportColumn{name: name, field: name, form: field.FormName, complete: true})
+		}
+	}
+	return columns
+}
+
+// hasValue reports whether a column holds data. Unchecked checkboxes and
+// forms that were never started export as "0" and do not count.
+func (c exportColumn) hasValue(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !((c.checkbox || c.complete) && value == "0")
+}
+
+// formColumns lists the export columns of a form's own fields and its
+// completion status, leaving out the record ID.
+func (d DataDictionary) formColumns(form string) []exportColumn {
+	recordID := d.RecordIDField()
+	var columns []exportColumn
+	for _, column := range d.exportColumns() {
+		if column.form == form && column.name != recordID {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// hasData reports whether a row holds data in any of the columns.
+func hasData(row Record, columns []exportColumn) bool {
+	for _, column := range columns {
+		if column.hasValue(row[column.name]) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+	SplitByInstrument splits a flat export into one table per instrument.
+	Each table holds the record ID, event and repeat instance columns
+	followed by the instrument's own fields, with a row for every record,
+	event and instance where the instrument has data.
+	
+	Args:
+		rows: The rows of a flat export, as returned by ExportRecordRows.
+		dictionary: The project's data dictionary.
+		mappings: The form-event mappings, or nil for classic projects.
+	
+	Returns:
+		The instrument tables in dictionary order.
+*/
+func SplitByInstrument(rows []Record, dictionary DataDictionary, mappings []FormEventMapping) []InstrumentTable {
+	recordID := dictionary.RecordIDField()
+	keys := []string{recordID}
+	if mappings != nil {
+		keys = append(keys, EventNameColumn)
+	}
+	keys = append(keys, RepeatInstanceColumn)
+	if len(rows) > 0 {
+		if _, ok := rows[0][DataAccessGroupColumn]; ok {
+			keys = append(keys, DataAccessGroupColumn)
+		}
+	}
+
+	_, eventForms := EventForms(mappings)
+
+	var tables []InstrumentTable
+	for _, form := range dictionary.Forms() {
+		table := InstrumentTable{Instrument: form, Columns: append([]string(nil), keys...)}
+		formColumns := dictionary.formColumns(form)
+		for _, column := range formColumns {
+			table.Columns = append(table.Columns, column.name)
+		}
+
+		mapped := make(map[string]bool)
+		for event, forms := range eventForms {
+			for _, f := range forms {
+				if f == form {
+					mapped[event] = true
+				}
+			}
+		}
+
+		for _, row := range rows {
+			if instrument := row[RepeatInstrumentColumn]; instrument != "" && instrument != form {
+				continue
+			}
+			if mappings != nil && !mapped[row[EventNameColumn]] {
+				continue
+			}
+
+			if !hasData(row, formColumns) {
+				continue
+			}
+
+			split := make(Record, len(table.Columns))
+			for _, name := range table.Columns {
+				split[name] = row[name]
+			}
+			table.Rows = append(table.Rows, split)
+		}
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+/*
+	FlatToLong turns flat rows into tidy long format, one row per value.
+	Empty values, unchecked checkboxes and unstarted forms are left out.
+	
+	Args:
+		rows: The rows of a flat export.
+		dictionary: The project's data dictionary.
+	
+	Returns:
+		The values in row and dictionary order.
+*/
+func FlatToLong(rows []Record, dictionary DataDictionary) []LongRow {
+	recordID := dictionary.RecordIDField()
+	columns := dictionary.exportColumns()
+
+	var long []LongRow
+	for _, row := range rows {
+		for _, column := range columns {
+			value := row[column.name]
+			if column.name == recordID || !column.hasValue(value) {
+				continue
+			}
+			if column.checkbox {
+				value = column.code
+			}
+			long = append(long, LongRow{
+				Record:         row[recordID],
+				Event:          row[EventNameColumn],
+				Instrument:     column.form,
+				RepeatInstance: row[RepeatInstanceColumn],
+				Field:          column.field,
+				Value:          value,
+			})
+		}
+	}
+	return long
+}
+
+/*
+	FlatToEAV turns flat rows into the rows of an EAV export. Checked
+	checkbox choices become one row each, with the choice code as the value.
+	
+	Args:
+		rows: The rows of a flat export.
+		dictionary: The project's data dictionary.
+	
+	Returns:
+		The EAV rows in row and dictionary order.
+*/
+func FlatToEAV(rows []Record, dictionary DataDictionary) []EAVRow {
+	recordID := dictionary.RecordIDField()
+	columns := dictionary.exportColumns()
+
+	var eav []EAVRow
+	for _, row := range rows {
+		for _, column := range columns {
+			value := row[column.name]
+			if column.name == recordID || !column.hasValue(value) {
+				continue
+			}
+			if column.checkbox {
+				value = column.code
+			}
+			eav = append(eav, EAVRow{
+				Record:           row[recordID],
+				Event:            row[EventNameColumn],
+				RepeatInstrument: row[RepeatInstrumentColumn],
+				RepeatInstance:   row[RepeatInstanceColumn],
+				FieldName:        column.field,
+				Value:            value,
+			})
+		}
+	}
+	return eav
+}
+
+/*
+	EAVToFlat pivots EAV rows back into flat rows, one per record, event and
+	repeat instance, in the order they first appear.
+	
+	Args:
+		rows: The EAV rows.
+		dictionary: The project's data dictionary.
+	
+	Returns:
+		The header of the flat rows, the rows themselves and an error if a
+		row names a field the dictionary does not have.
+*/
+func EAVToFlat(rows []EAVRow, dictionary DataDictionary) ([]string, []Record, error) {
+	recordID := dictionary.RecordIDField()
+	columns := dictionary.exportColumns()
+
+	fields := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		fields[column.field] = true
+	}
+
+	longitudinal, repeating := false, false
+	for _, row := range rows {
+		if !fields[row.FieldName] {
+			return nil, nil, fmt.Errorf("record %s: unknown field %q", row.Record, row.FieldName)
+		}
+		longitudinal = longitudinal || row.Event != ""
+		repeating = repeating || row.RepeatInstance != ""
+	}
+
+	header := []string{recordID}
+	if longitudinal {
+		header = append(header, EventNameColumn)
+	}
+	if repeating {
+		header = append(header, RepeatInstrumentColumn, RepeatInstanceColumn)
+	}
+	for _, column := range columns {
+		if column.name != recordID {
+			header = append(header, column.name)
+		}
+	}
+
+	var flat []Record
+	index := make(map[[4]string]int)
+	for _, row := range rows {
+		key := [4]string{row.Record, row.Event, row.RepeatInstrument, row.RepeatInstance}
+		i, ok := index[key]
+		if !ok {
+			record := make(Record, len(header))
+			for _, column := range columns {
+				if column.checkbox {
+					record[column.name] = "0"
+				} else {
+					record[column.name] = ""
+				}
+			}
+			record[recordID] = row.Record
+			if longitudinal {
+				record[EventNameColumn] = row.Event
+			}
+			if repeating {
+				record[RepeatInstrumentColumn] = row.RepeatInstrument
+				record[RepeatInstanceColumn] = row.RepeatInstance
+			}
+			i = len(flat)
+			index[key] = i
+			flat = append(flat, record)
+		}
+
+		if field := dictionary.Field(row.FieldName); field != nil && field.FieldType == "checkbox" {
+			flat[i][CheckboxColumn(row.FieldName, row.Value)] = "1"
+		} else {
+			flat[i][row.FieldName] = row.Value
+		}
+	}
+	return header, flat, nil
+}
+
+/*
+	ExportInstrumentTables exports records and splits them into one table
+	per instrument, fetching the data dictionary and, for longitudinal
+	projects, the form-event mappings to do so.
+	
+	Args:
+		options: The records to export.
+	
+	Returns:
+		The instrument tables in dictionary order.
+*/
+func (r *RedCapClient) ExportInstrumentTables(options RecordsOptions) ([]InstrumentTable, error) {
+	dictionary, err := r.ExportDataDictionary()
+	if err != nil {
+		return nil, err
+	}
+	info, err := r.ExportProjectInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	var mappings []FormEventMapping
+	if info.IsLongitudinal {
+		if mappings, err = r.ExportFormEventMappings(); err != nil {
+			return nil, err
+		}
+	}
+
+	_, rows, err := r.ExportRecordRows(options)
+	if err != nil {
+		return nil, err
+	}
+	return SplitByInstrument(rows, dictionary, mappings), nil
+}
@@ -0,0 +1,187 @@
+package redcap
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type SurveyResponseStatus int
+
+const (
+	ResponseNone SurveyResponseStatus = iota
+	ResponsePartial
+	ResponseCompleted
+)
+
+func (s *SurveyResponseStatus) UnmarshalJSON(data []byte) error {
+	status, err := strconv.Atoi(strings.Trim(string(data), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid response status %s", data)
+	}
+	*s = SurveyResponseStatus(status)
+	return nil
+}
+
+// SurveyParticipant is an entry of a survey's participant list.
+type SurveyParticipant struct {
+	Email                string               `json:"email"`
+	EmailOccurrence      int                  `json:"email_occurrence"`
+	Identifier           string               `json:"identifier"`
+	Record               string               `json:"record"`
+	InvitationSentStatus Flag                 `json:"invitation_sent_status"`
+	InvitationSendTime   string               `json:"invitation_send_time"`
+	ResponseStatus       SurveyResponseStatus `json:"response_status"`
+	SurveyAccessCode     string               `json:"survey_access_code"`
+	SurveyLink           string               `json:"survey_link"`
+	SurveyQueueLink      string               `json:"survey_queue_link"`
+}
+
+// SurveyLinks collects everything needed to invite one record to a survey.
+// Error is set when any of the lookups for the record failed; the other
+// fields hold whatever could still be fetched.
+type SurveyLinks struct {
+	Record     string
+	Email      string
+	Identifier string
+	Link       string
+	QueueLink  string
+	ReturnCode string
+	Error      error
+}
+
+/*
+	ExportSurveyParticipantList exports the participant list of a survey as
+	typed values.
+	
+	Args:
+		instrument: The survey instrument.
+		event: The event name, or "" for classic projects.
+	
+	Returns:
+		The participants of the survey.
+*/
+func (r *RedCapClient) ExportSurveyParticipantList(instrument string, event string) ([]SurveyParticipant, error) {
+	client := r.httpClient()
+	formating := url.Values{
+		"token":        {r.Token},
+		"content":      {"participantList"},
+		"instrument":   {instrument},
+		"format":       {"json"},
+		"returnFormat": {"json"},
+	}
+	if event != "" {
+		formating.Set("event", event)
+	}
+
+	data := strings.NewReader(formating.Encode())
+	req, err := http.NewRequest("POST", r.URL, data)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var participants []SurveyParticipant
+	if err := json.NewDecoder(resp.Body).Decode(&participants); err != nil {
+		return nil, fmt.Errorf("decoding participant list: %w", err)
+	}
+	return participants, nil
+}
+
+/*
+	SurveyLinksReport gathers the survey link, survey queue link and return
+	code of each record, along with the email and identifier from the
+	participant list, making up to concurrency requests at a time.
+	
+	Args:
+		records: The records to report on.
+		instrument: The survey instrument.
+		event: The event name, or "" for classic projects.
+		concurrency: The number of records looked up at once; 4 if 0 or less.
+	
+	Returns:
+		One entry per record, in the order the records were given.
+*/
+func (r *RedCapClient) SurveyLinksReport(records []string, instrument string, event string, concurrency int) ([]SurveyLinks, error) {
+	participants, err := r.ExportSurveyParticipantList(instrument, event)
+	if err != nil {
+		return nil, err
+	}
+	byRecord := make(map[string]SurveyParticipant, len(participants))
+	for _, participant := range participants {
+		if participant.Record != "" {
+			byRecord[participant.Record] = participant
+		}
+	}
+
+	if concurrency <= 0 {
+		concurrency = 4
+	}
+
+	report := make([]SurveyLinks, len(records))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				report[i] = r.surveyLinks(records[i], instrument, event, byRecord[records[i]])
+			}
+		}()
+	}
+	for i := range records {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return report, nil
+}
+
+func (r *RedCapClient) surveyLinks(record string, instrument string, event string, participant SurveyParticipant) SurveyLinks {
+	links := SurveyLinks{
+		Record:     record,
+		Email:      participant.Email,
+		Identifier: participant.Identifier,
+	}
+
+	var failures []string
+	link, err := r.ExportSurveyLink(record, instrument, event)
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("survey link: %s", err))
+	}
+	queueLink, err := r.ExportSurveyQueueLink(record, instrument, event)
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("survey queue link: %s", err))
+	}
+	returnCode, err := r.ExportSurveyReturnCode(record, instrument, event)
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("return code: %s", err))
+	}
+
+	links.Link = strings.TrimSpace(string(link))
+	links.QueueLink = strings.TrimSpace(string(queueLink))
+	links.ReturnCode = strings.TrimSpace(string(returnCode))
+	if len(failures) > 0 {
+		links.Error = fmt.Errorf("record %s: %s", record, strings.Join(failures, "; "))
+	}
+	return links
+}
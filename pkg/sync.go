@@ -0,0 +1,257 @@
+package redcap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SyncState is what a Syncer remembers between runs. LastSync is the high
+// water mark: the time at which the previous sync started, read from the
+// Syncer's clock rather than the REDCap server's.
+type SyncState struct {
+	LastSync time.Time `json:"last_sync"`
+}
+
+// SyncStore keeps the state of a Syncer between runs. Load returns the
+// zero state when nothing has been saved yet.
+type SyncStore interface {
+	Load() (SyncState, error)
+	Save(state SyncState) error
+}
+
+// SyncSink receives the changes found by a Syncer. Delete removes every
+// row of the given records. Upsert receives all rows of each changed record
+// and replaces whatever the sink held for those records.
+type SyncSink interface {
+	Delete(records []string) error
+	Upsert(recordIDField string, rows []Record) error
+}
+
+// SyncResetter is implemented by sinks that can be emptied. A Syncer calls
+// Reset before a full sync so records deleted while no state was kept do
+// not linger.
+type SyncResetter interface {
+	Reset() error
+}
+
+// FileSyncStore keeps the sync state in a JSON file.
+type FileSyncStore struct {
+	Path string
+}
+
+/*
+	Load reads the state file. A missing file is the zero state, which makes
+	the next sync a full one.
+	
+	Args:
+		None
+	
+	Returns:
+		The saved state.
+*/
+func (f FileSyncStore) Load() (SyncState, error) {
+	var state SyncState
+	content, err := os.ReadFile(f.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return state, fmt.Errorf("reading sync state %s: %w", f.Path, err)
+	}
+	return state, nil
+}
+
+/*
+	Save writes the state file, replacing it in one step so an interrupted
+	write never leaves a damaged file behind.
+	
+	Args:
+		state: The state to save.
+	
+	Returns:
+		An error if the file could not be written.
+*/
+func (f FileSyncStore) Save(state SyncState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(f.Path), filepath.Base(f.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), f.Path)
+}
+
+// Syncer copies the records of a project to a sink, exporting only the
+// records changed since the previous run. Changes are found with the
+// dateRangeBegin of the record export and deletions with the record_delete
+// logging. Records whose logging was deleted along with them, and records
+// renamed since the last run, are only caught by a full sync, made by
+// clearing the store.
+type Syncer struct {
+	Client *RedCapClient
+	Store  SyncStore
+	Sink   SyncSink
+	// Options selects the fields, forms and events to sync. Its Records and
+	// date range are set by the Syncer.
+	Options RecordsOptions
+	// Location is the time zone of the REDCap server. It defaults to the
+	// local time zone.
+	Location *time.Location
+	// Now returns the current time. It defaults to time.Now.
+	Now func() time.Time
+	// Overlap is how far before the high water mark each sync starts
+	// reading, so changes are not missed when the local clock runs ahead of
+	// the server's. Records changed in the overlap are upserted again,
+	// which is harmless. It defaults to five minutes; a negative overlap
+	// reads from the mark itself.
+	Overlap time.Duration
+}
+
+// SyncResult summarises one sync.
+type SyncResult struct {
+	// Full is set when every record was exported because there was no
+	// high water mark.
+	Full bool `json:"full"`
+	// Since is where the sync started reading: the high water mark less
+	// the Syncer's Overlap.
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"`
+	Upserted []string  `json:"upserted"`
+	Deleted  []string  `json:"deleted"`
+}
+
+/*
+	Sync applies the changes since the previous run to the sink and moves
+	the high water mark forward. The mark is only saved once the sink has
+	accepted every change, so a failed sync is retried in full next time.
+	
+	Args:
+		None
+	
+	Returns:
+		The records upserted and deleted.
+*/
+func (s *Syncer) Sync() (SyncResult, error) {
+	location := s.Location
+	if location == nil {
+		location = time.Local
+	}
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	overlap := s.Overlap
+	if overlap == 0 {
+		overlap = 5 * time.Minute
+	} else if overlap < 0 {
+		overlap = 0
+	}
+
+	state, err := s.Store.Load()
+	if err != nil {
+		return SyncResult{}, err
+	}
+	result := SyncResult{Full: state.LastSync.IsZero(), Until: now().In(location)}
+	if !result.Full {
+		result.Since = state.LastSync.Add(-overlap)
+	}
+
+	options := s.Options
+	options.Records = nil
+	options.DateRangeBegin, options.DateRangeEnd = time.Time{}, time.Time{}
+	if !result.Full {
+		options.DateRangeBegin = result.Since.In(location)
+		deleted, err := s.deletedRecords(options.DateRangeBegin)
+		if err != nil {
+			return result, err
+		}
+		result.Deleted = deleted
+	}
+
+	header, rows, err := s.Client.ExportRecordRows(options)
+	if err != nil {
+		return result, err
+	}
+	var recordIDField string
+	if len(header) > 0 {
+		recordIDField = header[0]
+	}
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		if id := row[recordIDField]; !seen[id] {
+			seen[id] = true
+			result.Upserted = append(result.Upserted, id)
+		}
+	}
+
+	if resetter, ok := s.Sink.(SyncResetter); ok && result.Full {
+		if err := resetter.Reset(); err != nil {
+			return result, err
+		}
+	}
+	// Deletions go first: a record deleted and created again since the last
+	// run is in both lists and must end up present.
+	if len(result.Deleted) > 0 {
+		if err := s.Sink.Delete(result.Deleted); err != nil {
+			return result, err
+		}
+	}
+	if len(rows) > 0 {
+		if err := s.Sink.Upsert(recordIDField, rows); err != nil {
+			return result, err
+		}
+	}
+	return result, s.Store.Save(SyncState{LastSync: result.Until})
+}
+
+// deletedRecords lists the records deleted since a time, from the logging.
+// Logging times only have minutes, so deletions early in that minute may
+// be seen twice, which is harmless.
+func (s *Syncer) deletedRecords(since time.Time) ([]string, error) {
+	entries, err := s.Client.ExportLogging(LoggingOptions{LogType: LogRecordDelete, BeginTime: since.Truncate(time.Minute)})
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		record, ok := DeletedRecord(entry)
+		if ok && !seen[record] {
+			seen[record] = true
+			deleted = append(deleted, record)
+		}
+	}
+	return deleted, nil
+}
+
+/*
+	DeletedRecord reads the record named by a "Deleted Record" log entry.
+	
+	Args:
+		entry: A log entry, as returned by ExportLogging.
+	
+	Returns:
+		The record name, and false if the entry is not a record deletion.
+*/
+func DeletedRecord(entry LogEntry) (string, bool) {
+	return recordAction(entry.Action, ChangeRecordDeleted)
+}
@@ -0,0 +1,478 @@
+package redcap
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// StatPackage is a statistical package import scripts can be written for.
+type StatPackage string
+
+const (
+	R     StatPackage = "r"
+	SAS   StatPackage = "sas"
+	SPSS  StatPackage = "spss"
+	Stata StatPackage = "stata"
+)
+
+var syntaxExtensions = map[StatPackage]string{R: ".r", SAS: ".sas", SPSS: ".sps", Stata: ".do"}
+
+// variableKind is how a column is read by a statistical package.
+type variableKind int
+
+const (
+	kindString variableKind = iota
+	kindNumber
+	kindDate
+	kindDatetime
+	kindDatetimeSeconds
+	kindTime
+)
+
+// statVariable is a column of a CSV export with its label and value labels.
+type statVariable struct {
+	name   string
+	label  string
+	kind   variableKind
+	values [][2]string
+}
+
+var (
+	htmlTags   = regexp.MustCompile(`<[^>]*>`)
+	whitespace = regexp.MustCompile(`\s+`)
+)
+
+var keyColumnLabels = map[string]string{
+	EventNameColumn:        "Event Name",
+	RepeatInstrumentColumn: "Repeat Instrument",
+	RepeatInstanceColumn:   "Repeat Instance",
+	DataAccessGroupColumn:  "Data Access Group",
+}
+
+// plainLabel strips HTML and collapses whitespace in a field label.
+func plainLabel(label string) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(htmlTags.ReplaceAllString(label, ""), " "))
+}
+
+// numericCodes reports whether every code of a choice list is a number.
+func numericCodes(choices [][2]string) bool {
+	for _, choice := range choices {
+		if _, err := strconv.ParseFloat(choice[0], 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// statVariables describes the columns of a CSV export with the data
+// dictionary. Columns the dictionary does not know are read as strings.
+func statVariables(dictionary DataDictionary, header []string) []statVariable {
+	columns := make(map[string]exportColumn)
+	for _, column := range dictionary.exportColumns() {
+		columns[column.name] = column
+	}
+
+	variables := make([]statVariable, len(header))
+	for i, name := range header {
+		variable := statVariable{name: name, label: name}
+		if label, ok := keyColumnLabels[name]; ok {
+			variable.label = label
+			if name == RepeatInstanceColumn {
+				variable.kind = kindNumber
+			}
+			variables[i] = variable
+			continue
+		}
+
+		column, ok := columns[name]
+		if !ok {
+			variables[i] = variable
+			continue
+		}
+		if column.complete {
+			variable.label = "Complete?"
+			variable.kind = kindNumber
+			variable.values = [][2]string{{"0", "Incomplete"}, {"1", "Unverified"}, {"2", "Complete"}}
+			variables[i] = variable
+			continue
+		}
+
+		field := dictionary.Field(column.field)
+		variable.label = plainLabel(field.FieldLabel)
+		switch field.FieldType {
+		case "checkbox":
+			choices, _ := ParseChoices(field.SelectChoicesOrCalculations)
+			for _, choice := range choices {
+				if choice[0] == column.code {
+					variable.label = fmt.Sprintf("%s (choice=%s)", variable.label, plainLabel(choice[1]))
+				}
+			}
+			variable.kind = kindNumber
+			variable.values = [][2]string{{"0", "Unchecked"}, {"1", "Checked"}}
+		case "radio", "dropdown":
+			choices, _ := ParseChoices(field.SelectChoicesOrCalculations)
+			for _, choice := range choices {
+				variable.values = append(variable.values, [2]string{choice[0], plainLabel(choice[1])})
+			}
+			if numericCodes(choices) {
+				variable.kind = kindNumber
+			}
+		case "yesno":
+			variable.kind = kindNumber
+			variable.values = [][2]string{{"1", "Yes"}, {"0", "No"}}
+		case "truefalse":
+			variable.kind = kindNumber
+			variable.values = [][2]string{{"1", "True"}, {"0", "False"}}
+		case "calc", "slider":
+			variable.kind = kindNumber
+		case "text":
+			validation := field.TextValidationTypeOrShowSliderNumber
+			switch {
+			case validation == "integer" || validation == "number" || strings.HasPrefix(validation, "number_"):
+				variable.kind = kindNumber
+			case strings.HasPrefix(validation, "datetime_seconds_"):
+				variable.kind = kindDatetimeSeconds
+			case strings.HasPrefix(validation, "datetime_"):
+				variable.kind = kindDatetime
+			case strings.HasPrefix(validation, "date_"):
+				variable.kind = kindDate
+			case validation == "time":
+				variable.kind = kindTime
+			}
+		}
+		variables[i] = variable
+	}
+	return variables
+}
+
+// formatNames names the value label set of each variable that has one.
+// SAS and Stata names are limited to 32 characters and SAS formats may not
+// end in a digit. Names cut to fit get a counter, so variables that only
+// differ after the cut keep separate sets; SAS ignores case, so names are
+// compared without it.
+func formatNames(variables []statVariable) map[string]string {
+	names := make(map[string]string)
+	used := make(map[string]bool)
+	for _, v := range variables {
+		if len(v.values) == 0 {
+			continue
+		}
+		name := v.name + "_"
+		for n := 1; len(name) > 32 || used[strings.ToLower(name)]; n++ {
+			suffix := "_" + strconv.Itoa(n) + "_"
+			name = v.name[:min(len(v.name), 32-len(suffix))] + suffix
+		}
+		used[strings.ToLower(name)] = true
+		names[v.name] = name
+	}
+	return names
+}
+
+/*
+	WriteSyntax writes a script that reads a CSV export into a statistical
+	package and applies variable labels, value labels and date formats from
+	the data dictionary.
+	
+	Args:
+		w: The writer the script is written to.
+		pkg: The statistical package.
+		dictionary: The project's data dictionary.
+		header: The columns of the CSV export.
+		csvFile: The path of the CSV export, as the script should open it.
+	
+	Returns:
+		An error if the package is unknown or the script cannot be written.
+*/
+func WriteSyntax(w io.Writer, pkg StatPackage, dictionary DataDictionary, header []string, csvFile string) error {
+	variables := statVariables(dictionary, header)
+	out := bufio.NewWriter(w)
+	switch pkg {
+	case R:
+		writeR(out, variables, csvFile)
+	case SAS:
+		writeSAS(out, variables, csvFile)
+	case SPSS:
+		writeSPSS(out, variables, csvFile)
+	case Stata:
+		writeStata(out, variables, csvFile)
+	default:
+		return fmt.Errorf("unknown statistical package %q", pkg)
+	}
+	return out.Flush()
+}
+
+func writeR(w *bufio.Writer, variables []statVariable, csvFile string) {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+
+	fmt.Fprintf(w, "data <- read.csv(\"%s\", stringsAsFactors = FALSE, na.strings = \"\")\n\n", quote(csvFile))
+	for _, v := range variables {
+		switch v.kind {
+		case kindDate:
+			fmt.Fprintf(w, "data$%s <- as.Date(data$%s)\n", v.name, v.name)
+		case kindDatetime:
+			fmt.Fprintf(w, "data$%s <- as.POSIXct(data$%s, format = \"%%Y-%%m-%%d %%H:%%M\")\n", v.name, v.name)
+		case kindDatetimeSeconds:
+			fmt.Fprintf(w, "data$%s <- as.POSIXct(data$%s, format = \"%%Y-%%m-%%d %%H:%%M:%%S\")\n", v.name, v.name)
+		}
+	}
+	fmt.Fprintln(w)
+	for _, v := range variables {
+		fmt.Fprintf(w, "attr(data$%s, \"label\") <- \"%s\"\n", v.name, quote(v.label))
+	}
+	for _, v := range variables {
+		if len(v.values) == 0 {
+			continue
+		}
+		levels := make([]string, len(v.values))
+		labels := make([]string, len(v.values))
+		for i, value := range v.values {
+			levels[i] = `"` + quote(value[0]) + `"`
+			labels[i] = `"` + quote(value[1]) + `"`
+		}
+		fmt.Fprintf(w, "data$%s.factor <- factor(data$%s, levels = c(%s), labels = c(%s))\n", v.name, v.name, strings.Join(levels, ", "), strings.Join(labels, ", "))
+	}
+}
+
+func writeSAS(w *bufio.Writer, variables []statVariable, csvFile string) {
+	quote := strings.NewReplacer(`'`, `''`).Replace
+	formats := formatNames(variables)
+
+	fmt.Fprintln(w, "proc format;")
+	for _, v := range variables {
+		if len(v.values) == 0 {
+			continue
+		}
+		name := formats[v.name]
+		if v.kind == kindString {
+			name = "$" + name
+		}
+		fmt.Fprintf(w, "\tvalue %s", name)
+		for _, value := range v.values {
+			if v.kind == kindString {
+				fmt.Fprintf(w, " '%s'='%s'", quote(value[0]), quote(value[1]))
+			} else {
+				fmt.Fprintf(w, " %s='%s'", value[0], quote(value[1]))
+			}
+		}
+		fmt.Fprintln(w, ";")
+	}
+	fmt.Fprintln(w, "run;")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "data redcap;")
+	fmt.Fprintf(w, "\tinfile '%s' delimiter=',' missover dsd lrecl=32767 firstobs=2;\n", quote(csvFile))
+	for _, v := range variables {
+		switch v.kind {
+		case kindString:
+			fmt.Fprintf(w, "\tinformat %s $500.;\n", v.name)
+		case kindNumber:
+			fmt.Fprintf(w, "\tinformat %s best32.;\n", v.name)
+		case kindDate:
+			fmt.Fprintf(w, "\tinformat %s yymmdd10.;\n\tformat %s yymmdd10.;\n", v.name, v.name)
+		case kindDatetime, kindDatetimeSeconds:
+			fmt.Fprintf(w, "\tinformat %s anydtdtm40.;\n\tformat %s datetime19.;\n", v.name, v.name)
+		case kindTime:
+			fmt.Fprintf(w, "\tinformat %s time5.;\n\tformat %s time5.;\n", v.name, v.name)
+		}
+	}
+	fmt.Fprintln(w, "\tinput")
+	for _, v := range variables {
+		if v.kind == kindString {
+			fmt.Fprintf(w, "\t\t%s $\n", v.name)
+		} else {
+			fmt.Fprintf(w, "\t\t%s\n", v.name)
+		}
+	}
+	fmt.Fprintln(w, "\t;")
+	for _, v := range variables {
+		fmt.Fprintf(w, "\tlabel %s='%s';\n", v.name, quote(v.label))
+	}
+	for _, v := range variables {
+		if len(v.values) == 0 {
+			continue
+		}
+		name := formats[v.name]
+		if v.kind == kindString {
+			name = "$" + name
+		}
+		fmt.Fprintf(w, "\tformat %s %s.;\n", v.name, name)
+	}
+	fmt.Fprintln(w, "run;")
+}
+
+func writeSPSS(w *bufio.Writer, variables []statVariable, csvFile string) {
+	quote := strings.NewReplacer(`"`, `""`).Replace
+
+	fmt.Fprintf(w, "GET DATA /TYPE=TXT /FILE=\"%s\" /ARRANGEMENT=DELIMITED /DELIMITERS=\",\" /QUALIFIER='\"' /FIRSTCASE=2\n", quote(csvFile))
+	fmt.Fprintln(w, "\t/VARIABLES=")
+	for _, v := range variables {
+		format := "A500"
+		switch v.kind {
+		case kindNumber:
+			format = "F8.2"
+		case kindDate:
+			format = "SDATE10"
+		case kindDatetime:
+			format = "YMDHMS16"
+		case kindDatetimeSeconds:
+			format = "YMDHMS19"
+		case kindTime:
+			format = "TIME5"
+		}
+		fmt.Fprintf(w, "\t%s %s\n", v.name, format)
+	}
+	fmt.Fprintln(w, ".")
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "VARIABLE LABELS")
+	for i, v := range variables {
+		separator := "/"
+		if i == 0 {
+			separator = ""
+		}
+		fmt.Fprintf(w, "\t%s%s \"%s\"\n", separator, v.name, quote(v.label))
+	}
+	fmt.Fprintln(w, ".")
+
+	var labelled []statVariable
+	for _, v := range variables {
+		if len(v.values) > 0 {
+			labelled = append(labelled, v)
+		}
+	}
+	if len(labelled) > 0 {
+		fmt.Fprintln(w, "VALUE LABELS")
+		for i, v := range labelled {
+			separator := "/"
+			if i == 0 {
+				separator = ""
+			}
+			fmt.Fprintf(w, "\t%s%s", separator, v.name)
+			for _, value := range v.values {
+				if v.kind == kindString {
+					fmt.Fprintf(w, " \"%s\" \"%s\"", quote(value[0]), quote(value[1]))
+				} else {
+					fmt.Fprintf(w, " %s \"%s\"", value[0], quote(value[1]))
+				}
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, ".")
+	}
+	fmt.Fprintln(w, "EXECUTE.")
+}
+
+func writeStata(w *bufio.Writer, variables []statVariable, csvFile string) {
+	// Stata has no escape for double quotes inside simple quoted strings.
+	quote := strings.NewReplacer(`"`, `'`).Replace
+	formats := formatNames(variables)
+
+	fmt.Fprintf(w, "import delimited using \"%s\", varnames(1) bindquote(strict) stringcols(_all) clear\n\n", quote(csvFile))
+	for _, v := range variables {
+		switch v.kind {
+		case kindNumber:
+			fmt.Fprintf(w, "destring %s, replace\n", v.name)
+		case kindDate:
+			fmt.Fprintf(w, "generate _temp = date(%s, \"YMD\")\ndrop %s\nrename _temp %s\nformat %s %%td\n", v.name, v.name, v.name, v.name)
+		case kindDatetime, kindDatetimeSeconds:
+			fmt.Fprintf(w, "generate double _temp = clock(%s, \"YMDhms\")\nreplace _temp = clock(%s, \"YMDhm\") if missing(_temp)\ndrop %s\nrename _temp %s\nformat %s %%tc\n", v.name, v.name, v.name, v.name, v.name)
+		}
+	}
+	fmt.Fprintln(w)
+
+	for _, v := range variables {
+		if len(v.values) == 0 || v.kind != kindNumber {
+			continue
+		}
+		name := formats[v.name]
+		fmt.Fprintf(w, "label define %s", name)
+		for _, value := range v.values {
+			fmt.Fprintf(w, " %s \"%s\"", value[0], quote(value[1]))
+		}
+		fmt.Fprintf(w, "\nlabel values %s %s\n", v.name, name)
+	}
+	for _, v := range variables {
+		// Variable labels hold at most 80 characters.
+		label := v.label
+		if utf8.RuneCountInString(label) > 80 {
+			label = string([]rune(label)[:80])
+		}
+		fmt.Fprintf(w, "label variable %s \"%s\"\n", v.name, quote(label))
+	}
+}
+
+/*
+	ExportForStatPackage exports records as raw CSV together with an import
+	script for a statistical package, as REDCap's own data export does.
+	The files are written to dir as name.csv and name with the package's
+	script extension.
+	
+	Args:
+		dir: The directory to write to.
+		name: The base name of the two files.
+		pkg: The statistical package.
+		options: The records to export. RawOrLabel is ignored.
+	
+	Returns:
+		An error if the export or either file fails.
+*/
+func (r *RedCapClient) ExportForStatPackage(dir string, name string, pkg StatPackage, options RecordsOptions) error {
+	extension, ok := syntaxExtensions[pkg]
+	if !ok {
+		return fmt.Errorf("unknown statistical package %q", pkg)
+	}
+
+	dictionary, err := r.ExportDataDictionary()
+	if err != nil {
+		return err
+	}
+	options.RawOrLabel = "raw"
+	header, rows, err := r.ExportRecordRows(options)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	data, err := os.Create(filepath.Join(dir, name+".csv"))
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(data)
+	writer.Write(header)
+	for _, row := range rows {
+		line := make([]string, len(header))
+		for i, column := range header {
+			line[i] = row[column]
+		}
+		writer.Write(line)
+	}
+	writer.Flush()
+	err = writer.Error()
+	if closeErr := data.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	script, err := os.Create(filepath.Join(dir, name+extension))
+	if err != nil {
+		return err
+	}
+	err = WriteSyntax(script, pkg, dictionary, header, name+".csv")
+	if closeErr := script.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
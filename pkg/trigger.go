@@ -0,0 +1,250 @@
+package redcap
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// TriggerSecretParameter is the query parameter of the Data Entry Trigger
+// URL that carries the shared secret. REDCap cannot sign its callbacks or
+// add headers to them, so the secret goes in the URL set in the project's
+// settings, such as https://example.com/det?secret=....
+const TriggerSecretParameter = "secret"
+
+// TriggerEvent is a form save reported by a REDCap Data Entry Trigger.
+type TriggerEvent struct {
+	// URL and ProjectURL are the REDCap base URL and the project's home page.
+	URL              string
+	ProjectURL       string
+	ProjectID        string
+	Username         string
+	Record           string
+	Instrument       string
+	Event            string
+	DataAccessGroup  string
+	RepeatInstrument string
+	RepeatInstance   string
+	// Status is the instrument's status after the save.
+	Status FormStatus
+	// Rows holds the rows of the record in the event when the handler has
+	// a client to fetch them with, and is nil otherwise.
+	Rows []Record
+}
+
+// TriggerFunc handles one trigger event. An error is logged and answered
+// with a server error; REDCap does not retry failed triggers.
+type TriggerFunc func(event *TriggerEvent) error
+
+type triggerRoute struct {
+	instrument string
+	handle     TriggerFunc
+}
+
+// TriggerHandler is an http.Handler receiving the Data Entry Triggers of a
+// project. It checks each callback's source and secret, optionally fetches
+// the saved record and passes the event to the functions registered for
+// its instrument, in registration order, before answering REDCap.
+type TriggerHandler struct {
+	// Secret, when set, must match the TriggerSecretParameter of the
+	// callback URL.
+	Secret string
+	// AllowedNetworks, when set, lists the networks the REDCap server posts
+	// from; callbacks from anywhere else are refused.
+	AllowedNetworks []netip.Prefix
+	// TrustedProxies lists reverse proxies whose X-Forwarded-For header
+	// names the real source of a callback.
+	TrustedProxies []netip.Prefix
+	// ProjectID, when set, refuses callbacks for other projects.
+	ProjectID string
+	// Client, when set, is used to fetch the saved record into the event's
+	// Rows. Its token must belong to the project.
+	Client *RedCapClient
+	// ErrorLog receives failed callbacks. It defaults to the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	mu     sync.RWMutex
+	routes []triggerRoute
+}
+
+/*
+	Handle registers a function for the saves of an instrument.
+	
+	Args:
+		instrument: The instrument name, or "" for every instrument.
+		handle: The function to call.
+	
+	Returns:
+		None
+*/
+func (h *TriggerHandler) Handle(instrument string, handle TriggerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.routes = append(h.routes, triggerRoute{instrument: instrument, handle: handle})
+}
+
+/*
+	ServeHTTP receives one Data Entry Trigger. Callbacks that fail the source
+	or secret checks get 403, malformed ones 400, and those whose record
+	could not be fetched or whose handler failed 502 and 500.
+	
+	Args:
+		w: The response to REDCap.
+		r: The callback.
+	
+	Returns:
+		None
+*/
+func (h *TriggerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Data Entry Triggers are POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.authorize(r); err != nil {
+		h.logf("refused trigger: %v", err)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	event, err := ParseTrigger(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.ProjectID != "" && event.ProjectID != h.ProjectID {
+		h.logf("refused trigger for project %s", event.ProjectID)
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	if h.Client != nil {
+		options := RecordsOptions{Records: []string{event.Record}, ExportDataAccessGroups: event.DataAccessGroup != ""}
+		if event.Event != "" {
+			options.Events = []string{event.Event}
+		}
+		if _, event.Rows, err = h.Client.ExportRecordRows(options); err != nil {
+			h.logf("fetching record %s for a trigger: %v", event.Record, err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+	}
+
+	h.mu.RLock()
+	routes := h.routes
+	h.mu.RUnlock()
+	for _, route := range routes {
+		if route.instrument != "" && route.instrument != event.Instrument {
+			continue
+		}
+		if err := route.handle(event); err != nil {
+			h.logf("handling the trigger for record %s, %s: %v", event.Record, event.Instrument, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// authorize checks the source address and shared secret of a callback.
+func (h *TriggerHandler) authorize(r *http.Request) error {
+	if h.Secret != "" {
+		secret := r.URL.Query().Get(TriggerSecretParameter)
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) != 1 {
+			return fmt.Errorf("wrong secret from %s", r.RemoteAddr)
+		}
+	}
+	if len(h.AllowedNetworks) == 0 {
+		return nil
+	}
+	source, err := h.source(r)
+	if err != nil {
+		return err
+	}
+	for _, network := range h.AllowedNetworks {
+		if network.Contains(source) {
+			return nil
+		}
+	}
+	return fmt.Errorf("callback from %s outside the allowed networks", source)
+}
+
+// source finds the address a callback came from, following X-Forwarded-For
+// back through trusted proxies.
+func (h *TriggerHandler) source(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	source, err := netip.ParseAddr(host)
+	if err != nil {
+		return source, fmt.Errorf("reading the callback address %q: %w", r.RemoteAddr, err)
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0 && h.trusted(source); i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if source, err = netip.ParseAddr(hop); err != nil {
+			return source, fmt.Errorf("reading X-Forwarded-For %q: %w", hop, err)
+		}
+	}
+	return source.Unmap(), nil
+}
+
+func (h *TriggerHandler) trusted(address netip.Addr) bool {
+	for _, proxy := range h.TrustedProxies {
+		if proxy.Contains(address.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *TriggerHandler) logf(format string, args ...interface{}) {
+	if h.ErrorLog != nil {
+		h.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+/*
+	ParseTrigger reads the form REDCap posts for a Data Entry Trigger.
+	
+	Args:
+		r: The callback.
+	
+	Returns:
+		The event, without Rows, and an error if the form is not a trigger.
+*/
+func ParseTrigger(r *http.Request) (*TriggerEvent, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("reading the trigger: %w", err)
+	}
+	form := r.PostForm
+	event := &TriggerEvent{
+		URL:              form.Get("redcap_url"),
+		ProjectURL:       form.Get("project_url"),
+		ProjectID:        form.Get("project_id"),
+		Username:         form.Get("username"),
+		Record:           form.Get("record"),
+		Instrument:       form.Get("instrument"),
+		Event:            form.Get(EventNameColumn),
+		DataAccessGroup:  form.Get(DataAccessGroupColumn),
+		RepeatInstrument: form.Get(RepeatInstrumentColumn),
+		RepeatInstance:   form.Get(RepeatInstanceColumn),
+	}
+	for _, name := range []string{"project_id", "record", "instrument"} {
+		if form.Get(name) == "" {
+			return nil, fmt.Errorf("the trigger has no %s", name)
+		}
+	}
+	event.Status = formStatus(form.Get(event.Instrument + "_complete"))
+	return event, nil
+}
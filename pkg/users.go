@@ -0,0 +1,67 @@
+package redcap
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// User is a user of a project with the rights most often audited. Rights
+// that REDCap sends as levels rather than yes/no, and instrument rights, are
+// left out; use ExportUsers for the full export.
+type User struct {
+	Username          string `json:"username"`
+	Email             string `json:"email"`
+	FirstName         string `json:"firstname"`
+	LastName          string `json:"lastname"`
+	Expiration        string `json:"expiration"`
+	DataAccessGroup   string `json:"data_access_group"`
+	DataAccessGroupID string `json:"data_access_group_id"`
+	Design            Flag   `json:"design"`
+	UserRights        Flag   `json:"user_rights"`
+	DataAccessGroups  Flag   `json:"data_access_groups"`
+	Reports           Flag   `json:"reports"`
+	Logging           Flag   `json:"logging"`
+	FileRepository    Flag   `json:"file_repository"`
+	APIExport         Flag   `json:"api_export"`
+	APIImport         Flag   `json:"api_import"`
+	MobileApp         Flag   `json:"mobile_app"`
+	RecordCreate      Flag   `json:"record_create"`
+	RecordRename      Flag   `json:"record_rename"`
+	RecordDelete      Flag   `json:"record_delete"`
+}
+
+// UnmarshalJSON accepts the data access group ID as a number or a string,
+// as REDCap sends "" for users outside any group.
+func (u *User) UnmarshalJSON(data []byte) error {
+	type user User
+	var raw struct {
+		user
+		DataAccessGroupID json.RawMessage `json:"data_access_group_id"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*u = User(raw.user)
+	u.DataAccessGroupID = strings.Trim(string(raw.DataAccessGroupID), `"`)
+	if u.DataAccessGroupID == "null" {
+		u.DataAccessGroupID = ""
+	}
+	return nil
+}
+
+/*
+	ExportUserList exports the users of a project as typed values.
+	
+	Args:
+		None
+	
+	Returns:
+		The users of the project.
+*/
+func (r *RedCapClient) ExportUserList() ([]User, error) {
+	var users []User
+	if err := r.exportJSON("user", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
@@ -0,0 +1,380 @@
+package redcap
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangeKind is the kind of a change read from the project logging.
+type ChangeKind string
+
+const (
+	ChangeRecordCreated ChangeKind = "record_created"
+	ChangeRecordUpdated ChangeKind = "record_updated"
+	ChangeRecordDeleted ChangeKind = "record_deleted"
+	ChangeUserRights    ChangeKind = "user_rights"
+	ChangeFileUploaded  ChangeKind = "file_uploaded"
+)
+
+// ChangeEvent is a change read from the project logging.
+type ChangeEvent struct {
+	Kind ChangeKind
+	Time time.Time
+	// Username is who made the change.
+	Username string
+	Record   string
+	// Values holds the field values a record change set, keyed by export
+	// column, so checkbox choices appear as "colour___1".
+	Values map[string]string
+	// Field is the upload field of a file uploaded into a record.
+	Field string
+	// User is the user whose rights changed.
+	User  string
+	Entry LogEntry
+	// Cursor is where a watcher resumes to deliver the events after this
+	// one. Save it once the event is handled.
+	Cursor LogCursor
+}
+
+// LogCursor is a position in the project logging. Log times only have
+// minutes, so it keeps the entries of its minute already delivered, by
+// digest and count, to tell them from entries logged later in that minute.
+type LogCursor struct {
+	Time time.Time      `json:"time"`
+	Seen map[string]int `json:"seen,omitempty"`
+}
+
+// Watcher turns the project logging into change events, for projects
+// without a Data Entry Trigger. Each poll asks for the logging from the
+// cursor's minute on and skips the entries delivered before.
+type Watcher struct {
+	Client *RedCapClient
+	// Cursor is where the next poll starts. The zero cursor starts at the
+	// beginning of the logging; to only follow new changes, start at the
+	// current time.
+	Cursor LogCursor
+	// Interval is the time between polls. It defaults to one minute.
+	Interval time.Duration
+	// Location is the time zone of the REDCap server. It defaults to the
+	// local time zone.
+	Location *time.Location
+	// Dictionary, when set, tells which updated fields are file uploads.
+	Dictionary DataDictionary
+	// MaxBackoff caps the wait between retries of a failed poll, which
+	// starts at Interval and doubles with each failure. It defaults to
+	// fifteen minutes.
+	MaxBackoff time.Duration
+	// ErrorLog receives the polls that failed and will be retried. It
+	// defaults to the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+/*
+	Poll reads the logging since the cursor once and moves the cursor past
+	what it read.
+	
+	Args:
+		None
+	
+	Returns:
+		The new change events, oldest first.
+*/
+func (w *Watcher) Poll() ([]ChangeEvent, error) {
+	location := w.Location
+	if location == nil {
+		location = time.Local
+	}
+	options := LoggingOptions{}
+	if !w.Cursor.Time.IsZero() {
+		options.BeginTime = w.Cursor.Time.In(location)
+	}
+	entries, err := w.Client.ExportLogging(options)
+	if err != nil {
+		return nil, err
+	}
+
+	type timedEntry struct {
+		entry LogEntry
+		at    time.Time
+	}
+	timed := make([]timedEntry, len(entries))
+	for i, entry := range entries {
+		at, err := entry.Time(location)
+		if err != nil {
+			return nil, err
+		}
+		timed[i] = timedEntry{entry, at}
+	}
+	// REDCap lists the newest entries first.
+	if len(timed) > 1 && timed[0].at.After(timed[len(timed)-1].at) {
+		for i, j := 0, len(timed)-1; i < j; i, j = i+1, j-1 {
+			timed[i], timed[j] = timed[j], timed[i]
+		}
+	}
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].at.Before(timed[j].at) })
+
+	var events []ChangeEvent
+	cursor := w.Cursor.copy()
+	occurrences := make(map[string]int)
+	for _, t := range timed {
+		if t.at.Before(cursor.Time) {
+			continue
+		}
+		if t.at.After(cursor.Time) {
+			cursor = LogCursor{Time: t.at}
+			occurrences = make(map[string]int)
+		}
+		digest := logDigest(t.entry)
+		occurrences[digest]++
+		if occurrences[digest] <= cursor.Seen[digest] {
+			continue
+		}
+
+		before := cursor.copy()
+		if cursor.Seen == nil {
+			cursor.Seen = make(map[string]int)
+		}
+		cursor.Seen[digest] = occurrences[digest]
+		changes := w.changes(t.entry, t.at)
+		for i := range changes {
+			// Until the entry's last event is handled, resuming must
+			// deliver the entry again.
+			changes[i].Cursor = before
+			if i == len(changes)-1 {
+				changes[i].Cursor = cursor.copy()
+			}
+		}
+		events = append(events, changes...)
+	}
+	w.Cursor = cursor
+	return events, nil
+}
+
+/*
+	Watch polls until the context ends, sending each change event on a
+	channel. A poll that fails because REDCap could not be reached or
+	answered with a server error is retried from the same cursor, waiting
+	longer after each failure. The caller owns the channel; Watch does not
+	close it.
+	
+	Args:
+		ctx: Ends the watch.
+		events: Receives the change events, oldest first.
+	
+	Returns:
+		The context's error, or the error of a poll that cannot succeed on
+		retry, such as a rejected token.
+*/
+func (w *Watcher) Watch(ctx context.Context, events chan<- ChangeEvent) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	maxBackoff := w.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = 15 * time.Minute
+	}
+	backoff := interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		changes, err := w.Poll()
+		if err != nil {
+			if !transient(err) {
+				return err
+			}
+			w.logf("polling the logging, retrying in %s: %v", backoff, err)
+			timer.Reset(backoff)
+			backoff = min(2*backoff, maxBackoff)
+			continue
+		}
+		backoff = interval
+		for _, change := range changes {
+			select {
+			case events <- change:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+func (w *Watcher) logf(format string, args ...interface{}) {
+	if w.ErrorLog != nil {
+		w.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// transient reports whether a failed request may succeed when retried: it
+// did not reach REDCap, or REDCap failed with a server error or asked the
+// client to slow down.
+func transient(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && urlErr.Op != "parse"
+}
+
+// userChange matches the log actions of changes to user rights, such as
+// "Updated User alice", "Add user alice" or "Assign user to role".
+var userChange = regexp.MustCompile(`(?i)^(add|create|edit|update|delete|assign|remove)[a-z]*\s+(user|role)s?\b\s*(.*)$`)
+
+// changes reads the change events of one log entry.
+func (w *Watcher) changes(entry LogEntry, at time.Time) []ChangeEvent {
+	event := ChangeEvent{Time: at, Username: entry.Username, Entry: entry}
+	for _, kind := range []ChangeKind{ChangeRecordCreated, ChangeRecordUpdated, ChangeRecordDeleted} {
+		record, ok := recordAction(entry.Action, kind)
+		if !ok {
+			continue
+		}
+		event.Kind, event.Record = kind, record
+		if kind == ChangeRecordDeleted {
+			return []ChangeEvent{event}
+		}
+		event.Values = ParseLogDetails(entry.Details)
+		changes := []ChangeEvent{event}
+		for _, field := range w.Dictionary.FieldsOfType("file") {
+			if value := event.Values[field.FieldName]; value != "" {
+				upload := event
+				upload.Kind, upload.Field = ChangeFileUploaded, field.FieldName
+				changes = append(changes, upload)
+			}
+		}
+		return changes
+	}
+
+	if match := userChange.FindStringSubmatch(entry.Action); match != nil {
+		event.Kind = ChangeUserRights
+		words := strings.Fields(match[3])
+		if strings.EqualFold(match[2], "user") && len(words) > 0 && !strings.EqualFold(words[0], "to") && !strings.EqualFold(words[0], "from") {
+			event.User = strings.Trim(words[0], "()")
+		} else {
+			values := ParseLogDetails(entry.Details)
+			event.User = values["username"]
+			if event.User == "" {
+				event.User = values["user"]
+			}
+		}
+		return []ChangeEvent{event}
+	}
+
+	action := strings.ToLower(entry.Action)
+	if strings.HasPrefix(action, "upload") && (strings.Contains(action, "file") || strings.Contains(action, "document")) {
+		event.Kind = ChangeFileUploaded
+		return []ChangeEvent{event}
+	}
+	return nil
+}
+
+// recordActions holds the prefixes of the log actions of each kind of
+// record change, lower case.
+var recordActions = map[ChangeKind][]string{
+	ChangeRecordCreated: {"created record ", "create record "},
+	ChangeRecordUpdated: {"updated record ", "update record "},
+	ChangeRecordDeleted: {"deleted record ", "delete record "},
+}
+
+// recordAction reads the record of a log action of a kind. Records in an
+// arm other than the first, and changes made by other means than a form,
+// are logged with a suffix such as "3 (Arm 2: ...)" or "3 (API)".
+func recordAction(action string, kind ChangeKind) (string, bool) {
+	for _, prefix := range recordActions[kind] {
+		if len(action) <= len(prefix) || !strings.EqualFold(action[:len(prefix)], prefix) {
+			continue
+		}
+		record := action[len(prefix):]
+		if i := strings.Index(record, " ("); i > 0 {
+			record = record[:i]
+		}
+		record = strings.TrimSpace(record)
+		return record, record != ""
+	}
+	return "", false
+}
+
+/*
+	ParseLogDetails reads the field values listed in the details of a log
+	entry, such as "name = 'Ada', colour(1) = checked". Checkbox choices
+	are keyed by their export column, with "1" for checked and "0" for
+	unchecked.
+	
+	Args:
+		details: The details of a log entry.
+	
+	Returns:
+		The values by field, empty if the details list none.
+*/
+func ParseLogDetails(details string) map[string]string {
+	values := make(map[string]string)
+	rest := details
+	for rest != "" {
+		name, after, ok := strings.Cut(rest, " = ")
+		if !ok {
+			break
+		}
+		name = strings.TrimSpace(name)
+		var value string
+		if strings.HasPrefix(after, "'") {
+			// A quoted value ends at a quote followed by the next pair or
+			// the end of the details.
+			end := strings.Index(after[1:], "', ")
+			if end < 0 {
+				value, rest = strings.TrimSuffix(after[1:], "'"), ""
+			} else {
+				value, rest = after[1:end+1], after[end+4:]
+			}
+		} else {
+			value, rest, _ = strings.Cut(after, ", ")
+		}
+
+		if open := strings.Index(name, "("); open > 0 && strings.HasSuffix(name, ")") {
+			name = CheckboxColumn(name[:open], name[open+1:len(name)-1])
+			switch value {
+			case "checked":
+				value = "1"
+			case "unchecked":
+				value = "0"
+			}
+		}
+		if name != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+func (c LogCursor) copy() LogCursor {
+	copied := LogCursor{Time: c.Time}
+	if c.Seen != nil {
+		copied.Seen = make(map[string]int, len(c.Seen))
+		for digest, count := range c.Seen {
+			copied.Seen[digest] = count
+		}
+	}
+	return copied
+}
+
+// logDigest identifies a log entry within its minute.
+func logDigest(entry LogEntry) string {
+	sum := sha256.Sum256([]byte(entry.Timestamp + "\x00" + entry.Username + "\x00" + entry.Action + "\x00" + entry.Details))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,153 @@
+package redcaptest
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// newBackupProject extends the test project with the parts a backup must
+// carry besides records: group membership, role assignments and the File
+// Repository.
+func newBackupProject() *redcaptest.Project {
+	project := newTestProject()
+	project.Records[0]["consent_form"] = "consent.pdf"
+	project.Records[0][redcap.DataAccessGroupColumn] = "api_testing_group"
+	project.Users = []redcaptest.Row{{"username": "testuser", "data_access_group": "api_testing_group"}}
+	project.UserDAGs = []redcaptest.Row{{"username": "testuser", "redcap_data_access_group": "api_testing_group"}}
+	project.UserRoleMaps = []redcaptest.Row{{"username": "testuser", "unique_role_name": "U-ADMIN"}}
+	project.Repository = []redcaptest.RepositoryEntry{
+		{ID: 50, Name: "Protocols"},
+		{ID: 51, ParentID: 50, Name: "v1.txt", File: &redcaptest.File{Name: "v1.txt", Data: []byte("version 1")}},
+	}
+	return project
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	project := newBackupProject()
+	client := server.Client(server.AddProject(project))
+
+	var archive bytes.Buffer
+	manifest, err := client.Backup(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.FormatVersion != redcap.BackupFormatVersion || manifest.ProjectTitle != "API Testing" || manifest.REDCapVersion != server.Version {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+	var names []string
+	for _, file := range manifest.Files {
+		names = append(names, file.Name)
+	}
+	for _, name := range []string{"project.json", "project.xml", "metadata.json", "events.json", "user_role_mapping.json", "files/1", "repository/2"} {
+		if !strings.Contains(strings.Join(names, ","), name) {
+			t.Errorf("expected %s in the backup, got %v", name, names)
+		}
+	}
+
+	opened, err := redcap.OpenBackup(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	super := server.Client(server.SuperToken)
+	token, err := opened.Restore(&super)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := server.Project(token)
+	if restored == nil || restored == project {
+		t.Fatalf("expected a new project, got %v", restored)
+	}
+	if !reflect.DeepEqual(restored.Metadata, project.Metadata) {
+		t.Errorf("metadata differs:\n%v\n%v", restored.Metadata, project.Metadata)
+	}
+	if !reflect.DeepEqual(restored.Records, project.Records) {
+		t.Errorf("records differ:\n%v\n%v", restored.Records, project.Records)
+	}
+	if len(restored.Events) != 2 || len(restored.Arms) != 2 || !reflect.DeepEqual(restored.Mappings, project.Mappings) {
+		t.Errorf("unexpected events %v, arms %v, mappings %v", restored.Events, restored.Arms, restored.Mappings)
+	}
+	if file := restored.Files[redcaptest.FileKey{Record: "1", Field: "consent_form", Event: "event_1_arm_1"}]; string(file.Data) != "%PDF" || file.Name != "consent.pdf" {
+		t.Errorf("unexpected restored file %+v", file)
+	}
+	if len(restored.Repository) != 2 || restored.Repository[1].ParentID != restored.Repository[0].ID || string(restored.Repository[1].File.Data) != "version 1" {
+		t.Errorf("unexpected repository %+v", restored.Repository)
+	}
+	if len(restored.DAGs) != 1 || restored.DAGs[0]["data_access_group_name"] != "API testing group" {
+		t.Errorf("unexpected data access groups %v", restored.DAGs)
+	}
+	if len(restored.UserRoles) != 1 || len(restored.UserRoleMaps) != 1 || restored.UserRoleMaps[0]["unique_role_name"] != restored.UserRoles[0]["unique_role_name"] {
+		t.Errorf("unexpected roles %v mapped as %v", restored.UserRoles, restored.UserRoleMaps)
+	}
+	if len(restored.UserDAGs) != 1 || restored.UserDAGs[0]["redcap_data_access_group"] != restored.DAGs[0]["unique_group_name"] {
+		t.Errorf("unexpected user groups %v", restored.UserDAGs)
+	}
+}
+
+// rewriteArchive copies a backup, replacing or adding entries.
+func rewriteArchive(t *testing.T, archive []byte, changes map[string]string) []byte {
+	t.Helper()
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	writer := zip.NewWriter(&out)
+	for _, entry := range reader.File {
+		content, ok := changes[entry.Name]
+		if !ok {
+			file, err := entry.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, _ := io.ReadAll(file)
+			file.Close()
+			content = string(data)
+		}
+		delete(changes, entry.Name)
+		w, _ := writer.Create(entry.Name)
+		w.Write([]byte(content))
+	}
+	for name, content := range changes {
+		w, _ := writer.Create(name)
+		w.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestOpenBackupRejectsDamagedArchives(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	client := server.Client(server.AddProject(newBackupProject()))
+
+	var archive bytes.Buffer
+	if _, err := client.Backup(&archive); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, changes := range map[string]map[string]string{
+		"changed entry":  {"project.json": `{"project_title": "Tampered"}`},
+		"extra entry":    {"notes.txt": "hello"},
+		"future version": {"manifest.json": `{"format_version": 99}`},
+	} {
+		damaged := rewriteArchive(t, archive.Bytes(), changes)
+		if _, err := redcap.OpenBackup(bytes.NewReader(damaged), int64(len(damaged))); err == nil {
+			t.Errorf("%s: expected the archive to be rejected", name)
+		}
+	}
+	if _, err := redcap.OpenBackup(strings.NewReader("not a zip"), 9); err == nil {
+		t.Error("expected a non-zip file to be rejected")
+	}
+}
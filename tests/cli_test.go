@@ -0,0 +1,191 @@
+package redcaptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// buildCLI compiles the redcap command into a temporary directory.
+func buildCLI(t *testing.T) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "redcap")
+	build := exec.Command("go", "build", "-o", binary, "../cmd/redcap")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building the CLI: %v\n%s", err, output)
+	}
+	return binary
+}
+
+// runCLI runs the command against a server and returns its output and exit code.
+func runCLI(t *testing.T, binary string, env []string, args ...string) (string, string, int) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(binary, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.String(), stderr.String(), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return stdout.String(), stderr.String(), 0
+}
+
+func TestCLI(t *testing.T) {
+	binary := buildCLI(t)
+	server := redcaptest.NewServer()
+	defer server.Close()
+	project := newTestProject()
+	token := server.AddProject(project)
+	env := []string{"REDCAP_URL=" + server.URL + "/api/", "REDCAP_TOKEN=" + token}
+	dir := t.TempDir()
+
+	csvFile := filepath.Join(dir, "records.csv")
+	if err := os.WriteFile(csvFile, []byte("record_id,redcap_event_name,name\n3,event_1_arm_1,Katherine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	consent := filepath.Join(dir, "consent.pdf")
+
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		output string
+	}{
+		{"export arms", []string{"export", "arms"}, 0, `"Arm 1"`},
+		{"users as a table", []string{"export", "users", "-output", "table", "-columns", "username,design"}, 0, "USERNAME  DESIGN\ntestuser  0\nuser1     0\n"},
+		{"arms as json lines, sorted", []string{"export", "arms", "-output", "jsonl", "-sort", "-arm_num"}, 0, "{\"arm_num\":2,\"name\":\"Arm 2\"}\n{\"arm_num\":1,\"name\":\"Arm 1\"}\n"},
+		{"dags as yaml", []string{"export", "dags", "-output", "yaml"}, 0, "- data_access_group_name: API testing group\n  unique_group_name: api_testing_group\n  data_access_group_id: 1\n"},
+		{"records with selected columns", []string{"export", "records", "-columns", "name,record_id", "-sort", "-record_id", "-output", "csv"}, 0, "name,record_id\nGrace,2\nAda,1\n"},
+		{"unknown column", []string{"export", "arms", "-columns", "colour"}, 2, ""},
+		{"output needs json", []string{"export", "arms", "-output", "table", "-format", "csv"}, 2, ""},
+		{"export records as csv", []string{"export", "records", "-format", "csv", "-fields", "record_id,name"}, 0, "record_id,redcap_event_name,name\n1,event_1_arm_1,Ada\n"},
+		{"import records from csv", []string{"import", "records", csvFile}, 0, `{"count":1}`},
+		{"records changed since", []string{"export", "records", "-format", "csv", "-fields", "record_id", "-begin", "2000-01-01 00:00:00"}, 0, "record_id,redcap_event_name\n3,event_1_arm_1\n"},
+		{"bad begin", []string{"export", "records", "-begin", "yesterday"}, 2, ""},
+		{"dry run delete", []string{"delete", "records", "-dry-run", "3"}, 0, `"Record": "3"`},
+		{"delete without -yes", []string{"delete", "records", "3"}, 2, ""},
+		{"flag after the records", []string{"delete", "records", "3", "-dry-run"}, 2, ""},
+		{"dry run and -yes", []string{"delete", "records", "-dry-run", "-yes", "3"}, 2, ""},
+		{"survey links of every participant", []string{"export", "survey-links", "-instrument", "instr_2", "-event", "event_1_arm_1", "-output", "jsonl", "-columns", "Record"}, 0, "{\"Record\":\"1\"}\n"},
+		{"export file to disk", []string{"export", "file", "-record", "1", "-field", "consent_form", "-event", "event_1_arm_1", "-out", consent}, 0, ""},
+		{"rename", []string{"rename", "-record", "3", "-new", "4"}, 0, "1"},
+		{"missing flag", []string{"export", "file", "-record", "1"}, 2, ""},
+		{"unknown target", []string{"export", "everything"}, 2, ""},
+		{"rejected by REDCap", []string{"export", "report", "-id", "99"}, 3, ""},
+		{"bad token", []string{"export", "arms", "-token", "0123456789ABCDEF0123456789ABCDEF"}, 4, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stdout, stderr, code := runCLI(t, binary, env, test.args...)
+			if code != test.code {
+				t.Fatalf("expected exit code %d, got %d: %s", test.code, code, stderr)
+			}
+			if !strings.Contains(stdout, test.output) {
+				t.Errorf("expected output to contain %q, got %q", test.output, stdout)
+			}
+		})
+	}
+
+	t.Run("only the rendered output", func(t *testing.T) {
+		stdout, stderr, code := runCLI(t, binary, env, "export", "users", "-output", "json")
+		var users []map[string]interface{}
+		if code != 0 || json.Unmarshal([]byte(stdout), &users) != nil || len(users) != 2 {
+			t.Errorf("expected stdout to hold only the users, got %d %q %s", code, stdout, stderr)
+		}
+		stdout, stderr, code = runCLI(t, binary, env, "export", "instruments", "-output", "csv", "-columns", "instrument_name")
+		if want := "instrument_name\ninstr_1\ninstr_2\n"; code != 0 || stdout != want {
+			t.Errorf("expected stdout %q, got %d %q %s", want, code, stdout, stderr)
+		}
+	})
+
+	t.Run("profile", func(t *testing.T) {
+		tokenFile := filepath.Join(dir, "study.token")
+		if err := os.WriteFile(tokenFile, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		config := filepath.Join(dir, "config.json")
+		content := `{"default": "study", "profiles": {"study": {"url": "` + server.URL + `/api/", "token_file": "` + filepath.ToSlash(tokenFile) + `", "format": "csv"}}}`
+		if err := os.WriteFile(config, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		stdout, stderr, code := runCLI(t, binary, []string{"REDCAP_CONFIG=" + config}, "export", "arms")
+		if code != 0 || !strings.HasPrefix(stdout, "arm_num,name\n") {
+			t.Errorf("expected CSV arms through the default profile, got %d %q %s", code, stdout, stderr)
+		}
+		stdout, _, code = runCLI(t, binary, []string{"REDCAP_CONFIG=" + config}, "profiles")
+		if code != 0 || !strings.Contains(stdout, "study (default)") || strings.Contains(stdout, token) {
+			t.Errorf("unexpected profile listing %d %q", code, stdout)
+		}
+		if _, _, code = runCLI(t, binary, []string{"REDCAP_CONFIG=" + config}, "export", "arms", "-profile", "other"); code != 2 {
+			t.Errorf("expected an unknown profile to be a usage error, got %d", code)
+		}
+	})
+
+	t.Run("backup and restore", func(t *testing.T) {
+		archive := filepath.Join(dir, "backup.zip")
+		if _, stderr, code := runCLI(t, binary, env, "backup", "-out", archive); code != 0 {
+			t.Fatalf("backup failed with %d: %s", code, stderr)
+		}
+		stdout, stderr, code := runCLI(t, binary, nil, "restore", "-verify", archive)
+		if code != 0 || !strings.Contains(stdout, `"project_title": "API Testing"`) {
+			t.Errorf("unexpected verification %d %q %s", code, stdout, stderr)
+		}
+		if _, _, code := runCLI(t, binary, env, "backup"); code != 2 {
+			t.Errorf("expected a backup without -out to be a usage error, got %d", code)
+		}
+
+		superEnv := []string{"REDCAP_URL=" + server.URL + "/api/", "REDCAP_TOKEN=" + server.SuperToken}
+		stdout, stderr, code = runCLI(t, binary, superEnv, "restore", archive)
+		if code != 0 {
+			t.Fatalf("restore failed with %d: %s", code, stderr)
+		}
+		if restored := server.Project(strings.TrimSpace(stdout)); restored == nil || restored.Info.ProjectTitle != "API Testing" {
+			t.Errorf("expected the restored project under token %q", stdout)
+		}
+	})
+
+	t.Run("mirror", func(t *testing.T) {
+		db := filepath.Join(dir, "mirror.db")
+		stdout, stderr, code := runCLI(t, binary, env, "mirror", "-db", db)
+		if code != 0 || !strings.Contains(stdout, `"full": true`) {
+			t.Fatalf("unexpected first mirror %d %q %s", code, stdout, stderr)
+		}
+		stdout, stderr, code = runCLI(t, binary, env, "mirror", "-db", db)
+		if code != 0 || !strings.Contains(stdout, `"full": false`) {
+			t.Errorf("expected an incremental mirror, got %d %q %s", code, stdout, stderr)
+		}
+		if _, _, code := runCLI(t, binary, env, "mirror", "-db", db, "-timezone", "Nowhere/Else"); code != 2 {
+			t.Errorf("expected an unknown time zone to be a usage error, got %d", code)
+		}
+	})
+
+	if content, err := os.ReadFile(consent); err != nil || string(content) != "%PDF" {
+		t.Errorf("expected the exported file on disk, got %q, %v", content, err)
+	}
+	server.Lock()
+	if len(project.Records) != 3 || project.Records[2]["record_id"] != "4" {
+		t.Errorf("expected the imported record to be renamed, got %v", project.Records)
+	}
+	server.Unlock()
+
+	if stdout, stderr, code := runCLI(t, binary, env, "delete", "records", "-yes", "4"); code != 0 || !strings.Contains(stdout, `"count": 1`) {
+		t.Errorf("expected -yes to delete, got %d %q %s", code, stdout, stderr)
+	}
+	server.Lock()
+	if len(project.Records) != 2 {
+		t.Errorf("expected record 4 to be deleted, got %v", project.Records)
+	}
+	server.Unlock()
+}
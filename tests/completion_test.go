@@ -0,0 +1,84 @@
+package redcaptest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func TestSurveyCompletion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.PostForm.Get("content") {
+		case "metadata":
+			w.Write([]byte(`[
+				{"field_name":"study_id","form_name":"enrolment","field_type":"text"},
+				{"field_name":"agree","form_name":"consent","field_type":"yesno"},
+				{"field_name":"dose","form_name":"medications","field_type":"text"}
+			]`))
+		case "project":
+			w.Write([]byte(`{"project_id":1,"is_longitudinal":1}`))
+		case "formEventMapping":
+			w.Write([]byte(`[
+				{"arm_num":1,"unique_event_name":"baseline_arm_1","form":"enrolment"},
+				{"arm_num":1,"unique_event_name":"baseline_arm_1","form":"consent"},
+				{"arm_num":1,"unique_event_name":"baseline_arm_1","form":"medications"},
+				{"arm_num":1,"unique_event_name":"week_4_arm_1","form":"medications"}
+			]`))
+		case "record":
+			if req.PostForm.Get("exportSurveyFields") != "true" {
+				t.Errorf("expected survey fields to be exported: %v", req.PostForm)
+			}
+			w.Write([]byte("study_id,redcap_event_name,redcap_repeat_instrument,redcap_repeat_instance,redcap_survey_identifier,enrolment_complete,consent_timestamp,agree,consent_complete,dose,medications_complete\n" +
+				"1,baseline_arm_1,,,,2,2024-01-02 10:00:00,1,2,,\n" +
+				"1,baseline_arm_1,medications,1,,,,,,5mg,1\n" +
+				"1,baseline_arm_1,medications,2,,,,,,10mg,0\n" +
+				"2,baseline_arm_1,,,,1,[not completed],,0,,\n" +
+				"2,week_4_arm_1,medications,1,,,,,,5mg,2\n"))
+		case "participantList":
+			w.Write([]byte(`[{"email":"","record":"2","response_status":1}]`))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	matrix, err := client.SurveyCompletion(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(matrix.Records, ",") != "1,2" || strings.Join(matrix.Events, ",") != "baseline_arm_1,week_4_arm_1" || strings.Join(matrix.Instruments, ",") != "enrolment,consent,medications" {
+		t.Errorf("unexpected axes %v %v %v", matrix.Records, matrix.Events, matrix.Instruments)
+	}
+
+	consent := matrix.Lookup("1", "baseline_arm_1", "consent")
+	if len(consent) != 1 || consent[0].Status != redcap.StatusComplete || !consent[0].IsSurvey || consent[0].SurveyTimestamp != "2024-01-02 10:00:00" || consent[0].Partial {
+		t.Errorf("unexpected consent cell %+v", consent)
+	}
+	partial := matrix.Lookup("2", "baseline_arm_1", "consent")
+	if len(partial) != 1 || partial[0].Status != redcap.StatusIncomplete || !partial[0].Partial || partial[0].SurveyTimestamp != "" {
+		t.Errorf("unexpected partial cell %+v", partial)
+	}
+	medications := matrix.Lookup("1", "baseline_arm_1", "medications")
+	if len(medications) != 2 || medications[0].Status != redcap.StatusUnverified || medications[1].RepeatInstance != 2 || medications[1].Status != redcap.StatusIncomplete {
+		t.Errorf("unexpected medications cells %+v", medications)
+	}
+	if enrolment := matrix.Lookup("2", "baseline_arm_1", "enrolment"); len(enrolment) != 1 || enrolment[0].Status != redcap.StatusUnverified || enrolment[0].IsSurvey {
+		t.Errorf("unexpected enrolment cell %+v", enrolment)
+	}
+	if cells := matrix.Lookup("2", "baseline_arm_1", "medications"); len(cells) != 1 || cells[0].Status != redcap.StatusNotStarted {
+		t.Errorf("expected a not started medications cell, got %+v", cells)
+	}
+
+	var report bytes.Buffer
+	if err := matrix.WriteCSV(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "1,baseline_arm_1,medications,2,incomplete,false,,false\n") {
+		t.Errorf("unexpected CSV:\n%s", report.String())
+	}
+}
@@ -0,0 +1,236 @@
+package redcaptest
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func TestExportInstrumentPDF(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		if form.Get("record") == "missing" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"The record 'missing' does not exist"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.4"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	var pdf bytes.Buffer
+	written, err := client.ExportInstrumentPDF(&pdf, redcap.PDFOptions{
+		Record:         "1",
+		Event:          "baseline_arm_1",
+		Instrument:     "consent",
+		RepeatInstance: 3,
+		CompactDisplay: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 8 || pdf.String() != "%PDF-1.4" {
+		t.Errorf("unexpected PDF %q (%d bytes)", pdf.String(), written)
+	}
+	if form.Get("content") != "pdf" || form.Get("instrument") != "consent" || form.Get("repeat_instance") != "3" || form.Get("compactDisplay") != "true" {
+		t.Errorf("unexpected request %v", form)
+	}
+	if form.Has("allRecords") {
+		t.Errorf("allRecords should only be sent when requested: %v", form)
+	}
+
+	pdf.Reset()
+	_, err = client.ExportInstrumentPDF(&pdf, redcap.PDFOptions{Record: "missing"})
+	var apiErr *redcap.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "The record 'missing' does not exist" {
+		t.Errorf("expected an APIError, got %v", err)
+	}
+	if pdf.Len() != 0 {
+		t.Errorf("expected nothing written on error, got %q", pdf.String())
+	}
+}
+
+func TestExportLogging(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		w.Write([]byte(`[{"timestamp":"2021-03-04 09:15","username":"jdoe","action":"Update record 7","details":"age = '42'"}]`))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "csv"}
+
+	entries, err := client.ExportLogging(redcap.LoggingOptions{
+		LogType:   redcap.LogRecordEdit,
+		Record:    "7",
+		BeginTime: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2021, time.December, 31, 23, 59, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("beginTime") != "2021-01-01 00:00" || form.Get("endTime") != "2021-12-31 23:59" {
+		t.Errorf("unexpected time range %q - %q", form.Get("beginTime"), form.Get("endTime"))
+	}
+	if form.Get("logtype") != "record_edit" || form.Get("record") != "7" || form.Get("format") != "json" || form.Has("user") {
+		t.Errorf("unexpected request %v", form)
+	}
+	if len(entries) != 1 || entries[0].Username != "jdoe" || entries[0].Action != "Update record 7" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	logged, err := entries[0].Time(time.UTC)
+	if err != nil || !logged.Equal(time.Date(2021, time.March, 4, 9, 15, 0, 0, time.UTC)) {
+		t.Errorf("unexpected timestamp %v (%v)", logged, err)
+	}
+
+	if _, err := client.ExportLogging(redcap.LoggingOptions{LogType: "everything"}); err == nil {
+		t.Error("expected an invalid log type to be rejected")
+	}
+}
+
+func TestExportProjectXML(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8" ?><ODM></ODM>`))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	var odm bytes.Buffer
+	_, err := client.ExportProjectXML(&odm, redcap.ProjectXMLOptions{
+		Records:                []string{"1", "2"},
+		Fields:                 []string{"record_id", "consent_form"},
+		FilterLogic:            "[age] > 18",
+		ExportDataAccessGroups: true,
+		ExportFiles:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(odm.Bytes(), []byte("<ODM>")) {
+		t.Errorf("unexpected document %q", odm.String())
+	}
+	if form.Get("records[1]") != "2" || form.Get("fields[1]") != "consent_form" || form.Get("filterLogic") != "[age] > 18" {
+		t.Errorf("unexpected request %v", form)
+	}
+	if form.Get("exportFiles") != "true" || form.Get("exportDataAccessGroups") != "true" || form.Get("returnMetadataOnly") != "false" {
+		t.Errorf("unexpected flags %v", form)
+	}
+}
+
+func TestExportUserListAndArms(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.PostForm.Get("content") {
+		case "user":
+			w.Write([]byte(`[{"username":"alice","email":"alice@example.com","data_access_group":"","data_access_group_id":"","design":1,"user_rights":"0","api_export":1,"forms":{"demographics":1}},
+				{"username":"bob","data_access_group":"site_a","data_access_group_id":12,"design":0}]`))
+		case "arm":
+			w.Write([]byte(`[{"arm_num":1,"name":"Drug A"},{"arm_num":"2","name":"Drug B"}]`))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	users, err := client.ExportUserList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 2 || !users[0].Design || users[0].UserRights || !users[0].APIExport || users[0].DataAccessGroupID != "" {
+		t.Errorf("unexpected first user %+v", users)
+	}
+	if users[1].DataAccessGroup != "site_a" || users[1].DataAccessGroupID != "12" {
+		t.Errorf("unexpected second user %+v", users[1])
+	}
+
+	arms, err := client.ExportArmDefinitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(arms) != 2 || arms[1].ArmNum != "2" || arms[1].Name != "Drug B" {
+		t.Errorf("unexpected arms %+v", arms)
+	}
+}
+
+func TestExportByArm(t *testing.T) {
+	forms := make(map[string]url.Values)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		forms[req.PostForm.Get("content")] = req.PostForm
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	arms := []string{"1", "2&format=csv"}
+	for content, export := range map[string]func() error{
+		"formEventMapping": func() error {
+			_, err := client.ExportFormEventMappings(arms...)
+			return err
+		},
+		"event": func() error {
+			_, err := client.ExportEventDefinitions(arms...)
+			return err
+		},
+		"arm": func() error {
+			_, err := client.ExportArmDefinitions(arms...)
+			return err
+		},
+	} {
+		if err := export(); err != nil {
+			t.Fatalf("%s: %v", content, err)
+		}
+		form := forms[content]
+		if form.Get("arms[0]") != "1" || form.Get("arms[1]") != "2&format=csv" || form.Get("format") != "json" {
+			t.Errorf("%s: expected the arms to be escaped, got %v", content, form)
+		}
+	}
+
+	server.Close()
+	if _, err := client.ExportFormEventMappings(); err == nil || !strings.Contains(err.Error(), "exporting formEventMapping") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+	if _, err := client.ExportEventDefinitions(); err == nil || !strings.Contains(err.Error(), "exporting event") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+	if _, err := client.ExportArmDefinitions(); err == nil || !strings.Contains(err.Error(), "exporting arm") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+}
+
+func TestExportTransportErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	for content, export := range map[string]func() error{
+		"dag": func() error {
+			_, err := client.ExportDataAccessGroups()
+			return err
+		},
+		"user": func() error {
+			_, err := client.ExportUserList()
+			return err
+		},
+	} {
+		if err := export(); err == nil || !strings.Contains(err.Error(), "exporting "+content) {
+			t.Errorf("%s: expected the transport error to be returned, got %v", content, err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package redcaptest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func TestFileRepository(t *testing.T) {
+	files := map[string]string{"11": "protocol v2", "12": "site a roster"}
+	var uploaded string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseMultipartForm(1 << 20)
+		form := req.PostForm
+		if form.Get("content") != "fileRepository" {
+			t.Errorf("unexpected content %q", form.Get("content"))
+		}
+		switch form.Get("action") {
+		case "createFolder":
+			if form.Get("name") != "Site A" || form.Get("dag_id") != "4" || form.Has("role_id") {
+				t.Errorf("unexpected folder request %v", form)
+			}
+			w.Write([]byte(`[{"folder_id":"3"}]`))
+		case "list":
+			switch form.Get("folder_id") {
+			case "":
+				w.Write([]byte(`[{"folder_id":3,"name":"Site A"},{"doc_id":11,"name":"protocol.pdf"}]`))
+			case "3":
+				w.Write([]byte(`[{"doc_id":"12","name":"../roster.csv"}]`))
+			}
+		case "export":
+			w.Header().Set("Content-Type", "application/octet-stream; name=\"export.bin\"")
+			w.Write([]byte(files[form.Get("doc_id")]))
+		case "import":
+			file, header, err := req.FormFile("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, _ := io.ReadAll(file)
+			uploaded = form.Get("folder_id") + "/" + header.Filename + ":" + string(contents)
+		case "delete":
+			if form.Get("doc_id") != "11" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"The file does not exist"}`))
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	folderID, err := client.CreateRepositoryFolder("Site A", redcap.RepositoryFolderOptions{DagID: 4})
+	if err != nil || folderID != 3 {
+		t.Fatalf("unexpected folder %d (%v)", folderID, err)
+	}
+
+	items, err := client.ListRepository(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || !items[0].IsFolder() || items[1].IsFolder() || items[1].DocID != 11 {
+		t.Errorf("unexpected listing %+v", items)
+	}
+
+	dir := t.TempDir()
+	if err := client.MirrorRepository(0, dir); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"protocol.pdf": "protocol v2", "Site A/roster.csv": "site a roster"} {
+		contents, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil || string(contents) != expected {
+			t.Errorf("expected %s to contain %q, got %q (%v)", path, expected, contents, err)
+		}
+	}
+
+	if err := client.ImportRepositoryFile(3, "consent.pdf", strings.NewReader("%PDF")); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != "3/consent.pdf:%PDF" {
+		t.Errorf("unexpected upload %q", uploaded)
+	}
+	if err := client.DeleteRepositoryFile(11); err != nil {
+		t.Error(err)
+	}
+	if err := client.DeleteRepositoryFile(99); err == nil {
+		t.Error("expected deleting a missing file to fail")
+	}
+
+	server.Close()
+	if _, err := client.ListRepository(0); err == nil || !strings.Contains(err.Error(), "file repository list") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+}
+
+func TestImportRepositoryFileStreams(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ContentLength != -1 {
+			t.Errorf("expected the upload to be streamed, got a length of %d", req.ContentLength)
+		}
+		if err := req.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		file, _, err := req.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		contents, _ := io.ReadAll(file)
+		received = string(contents)
+	}))
+	defer server.Close()
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	if err := client.ImportRepositoryFile(0, "roster.csv", strings.NewReader("site a roster")); err != nil || received != "site a roster" {
+		t.Errorf("unexpected upload %q (%v)", received, err)
+	}
+	failing := io.MultiReader(strings.NewReader("site b"), iotest.ErrReader(errors.New("disk gone")))
+	if err := client.ImportRepositoryFile(0, "roster.csv", failing); err == nil || !strings.Contains(err.Error(), "disk gone") {
+		t.Errorf("expected the read error to end the upload, got %v", err)
+	}
+}
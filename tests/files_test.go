@@ -0,0 +1,110 @@
+package redcaptest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+func TestRecordFiles(t *testing.T) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseMultipartForm(1 << 20)
+		requests = append(requests, req.PostForm)
+		switch req.PostForm.Get("content") {
+		case "metadata":
+			w.Write([]byte(`[
+				{"field_name":"study_id","form_name":"enrolment","field_type":"text"},
+				{"field_name":"consent_pdf","form_name":"enrolment","field_type":"file"},
+				{"field_name":"scan","form_name":"imaging","field_type":"file"}
+			]`))
+		case "record":
+			w.Write([]byte("study_id,redcap_event_name,redcap_repeat_instrument,redcap_repeat_instance,consent_pdf,scan\n" +
+				"7,baseline_arm_1,,,consent.pdf,\n" +
+				"7,baseline_arm_1,imaging,1,,scan_1.dcm\n" +
+				"7,baseline_arm_1,imaging,2,,\n" +
+				"7,baseline_arm_1,imaging,3,,scan_3.dcm\n"))
+		case "file":
+			if req.PostForm.Get("action") == "import" {
+				file, header, err := req.FormFile("file")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				contents, _ := io.ReadAll(file)
+				if header.Filename != "scan_4.dcm" || string(contents) != "DICM" {
+					t.Errorf("unexpected upload %s %q", header.Filename, contents)
+				}
+			}
+			w.Write([]byte(req.PostForm.Get("repeat_instance")))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "csv"}
+
+	files, err := client.RecordFiles("7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Fatalf("expected 3 files, got %+v", files)
+	}
+	if files[0].Field != "consent_pdf" || files[0].RepeatInstance != 0 || files[0].Value != "consent.pdf" {
+		t.Errorf("unexpected file %+v", files[0])
+	}
+	if files[2].Field != "scan" || files[2].RepeatInstance != 3 || files[2].Event != "baseline_arm_1" {
+		t.Errorf("unexpected file %+v", files[2])
+	}
+	if requests[1].Get("fields[0]") != "study_id" || requests[1].Get("fields[2]") != "scan" || requests[1].Get("records[0]") != "7" {
+		t.Errorf("unexpected record request %v", requests[1])
+	}
+
+	body, err := client.ExportFile("7", "scan", "baseline_arm_1", 3)
+	if err != nil || string(body) != "3" {
+		t.Errorf("expected repeat_instance to be sent, got %q (%v)", body, err)
+	}
+	body, err = client.DeleteFile("7", "consent_pdf", "baseline_arm_1", 0)
+	if err != nil || string(body) != "" {
+		t.Errorf("expected no repeat_instance, got %q (%v)", body, err)
+	}
+	body, err = client.ImportFile("7", "scan", "baseline_arm_1", 4, "scan_4.dcm", strings.NewReader("DICM"))
+	if err != nil || string(body) != "4" {
+		t.Errorf("unexpected import response %q (%v)", body, err)
+	}
+	if last := requests[len(requests)-1]; last.Get("action") != "import" || last.Get("field") != "scan" {
+		t.Errorf("unexpected import request %v", last)
+	}
+}
+
+func TestImportFileStreams(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	project := newTestProject()
+	client := server.Client(server.AddProject(project))
+
+	failing := io.MultiReader(strings.NewReader("%PDF-part"), iotest.ErrReader(errors.New("disk gone")))
+	if _, err := client.ImportFile("1", "consent_form", "event_1_arm_1", 0, "signed.pdf", failing); err == nil || !strings.Contains(err.Error(), "disk gone") {
+		t.Errorf("expected the read error to end the upload, got %v", err)
+	}
+	key := redcaptest.FileKey{Record: "1", Field: "consent_form", Event: "event_1_arm_1"}
+	if file := project.Files[key]; string(file.Data) != "%PDF" {
+		t.Errorf("expected the failed upload to leave the file alone, got %q", file.Data)
+	}
+
+	large := strings.Repeat("%PDF", 1<<18)
+	if _, err := client.ImportFile("1", "consent_form", "event_1_arm_1", 0, "signed.pdf", strings.NewReader(large)); err != nil {
+		t.Fatal(err)
+	}
+	if file := project.Files[key]; string(file.Data) != large || file.Name != "signed.pdf" {
+		t.Errorf("expected the streamed file to arrive whole, got %d bytes named %q", len(file.Data), file.Name)
+	}
+}
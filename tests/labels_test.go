@@ -0,0 +1,98 @@
+package redcaptest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func TestProjectLabeler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.PostForm.Get("content") {
+		case "metadata":
+			w.Write([]byte(`[
+				{"field_name":"study_id","form_name":"enrolment","field_type":"text"},
+				{"field_name":"sex","form_name":"enrolment","field_type":"radio","select_choices_or_calculations":"0, Female | 1, Male"},
+				{"field_name":"race","form_name":"enrolment","field_type":"checkbox","select_choices_or_calculations":"1, White | 2, Black"},
+				{"field_name":"smoker","form_name":"enrolment","field_type":"yesno"},
+				{"field_name":"weight","form_name":"enrolment","field_type":"text"}
+			]`))
+		case "project":
+			w.Write([]byte(`{"project_id":1,"is_longitudinal":1,"missing_data_codes":"UNK, Unknown | NA, Not applicable"}`))
+		case "event":
+			w.Write([]byte(`[{"event_name":"Baseline","arm_num":1,"unique_event_name":"baseline_arm_1"}]`))
+		case "dag":
+			w.Write([]byte(`[{"data_access_group_name":"North Site","unique_group_name":"north_site","data_access_group_id":4}]`))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	labeler, err := client.ProjectLabeler()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := []string{"study_id", "redcap_event_name", "redcap_data_access_group", "sex", "race___1", "race___2", "smoker", "weight", "enrolment_complete"}
+	rows := []redcap.Record{
+		{"study_id": "1", "redcap_event_name": "baseline_arm_1", "redcap_data_access_group": "north_site", "sex": "1", "race___1": "1", "race___2": "0", "smoker": "0", "weight": "UNK", "enrolment_complete": "2"},
+	}
+
+	_, replaced := labeler.Translate(header, rows, redcap.ReplaceCodes)
+	expected := redcap.Record{"study_id": "1", "redcap_event_name": "Baseline", "redcap_data_access_group": "North Site", "sex": "Male", "race___1": "Checked", "race___2": "Unchecked", "smoker": "No", "weight": "Unknown", "enrolment_complete": "Complete"}
+	for column, value := range expected {
+		if replaced[0][column] != value {
+			t.Errorf("%s: expected %q, got %q", column, value, replaced[0][column])
+		}
+	}
+	if rows[0]["sex"] != "1" {
+		t.Error("Translate changed the input rows")
+	}
+
+	added, labelled := labeler.Translate(header, rows, redcap.AddLabelColumns)
+	if !strings.HasPrefix(strings.Join(added, ","), "study_id,redcap_event_name,redcap_event_name_label,redcap_data_access_group,redcap_data_access_group_label,sex,sex_label,race___1,race___1_label") {
+		t.Errorf("unexpected header %v", added)
+	}
+	if labelled[0]["sex"] != "1" || labelled[0]["sex_label"] != "Male" || labelled[0]["weight_label"] != "Unknown" {
+		t.Errorf("unexpected labelled row %v", labelled[0])
+	}
+	if labeler.Label("weight", "72") != "72" {
+		t.Error("expected free text to be left as is")
+	}
+}
+
+func TestProjectLabelerNamesArms(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.PostForm.Get("content") {
+		case "metadata":
+			w.Write([]byte(`[{"field_name":"study_id","form_name":"enrolment","field_type":"text"}]`))
+		case "project":
+			w.Write([]byte(`{"project_id":1,"is_longitudinal":1}`))
+		case "event":
+			w.Write([]byte(`[{"event_name":"Baseline","arm_num":1,"unique_event_name":"baseline_arm_1"},{"event_name":"Baseline","arm_num":2,"unique_event_name":"baseline_arm_2"}]`))
+		case "arm":
+			w.Write([]byte(`[{"arm_num":1,"name":"Drug A"},{"arm_num":2,"name":"Drug B"}]`))
+		case "dag":
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	labeler, err := client.ProjectLabeler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for event, expected := range map[string]string{"baseline_arm_1": "Baseline (Arm 1: Drug A)", "baseline_arm_2": "Baseline (Arm 2: Drug B)"} {
+		if label := labeler.Label(redcap.EventNameColumn, event); label != expected {
+			t.Errorf("%s: expected %q, got %q", event, expected, label)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package redcaptest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+const dataDictionaryCSV = `"Variable / Field Name","Form Name","Section Header","Field Type","Field Label","Choices, Calculations, OR Slider Labels","Field Note","Text Validation Type OR Show Slider Number","Text Validation Min","Text Validation Max","Identifier?","Branching Logic (Show field only if...)","Required Field?","Custom Alignment","Question Number (surveys only)","Matrix Group Name","Matrix Ranking?","Field Annotation"
+record_id,demographics,,text,"Record ID",,,,,,,,,,,,,
+dob,demographics,,text,"Date of birth",,,date_ymd,,,y,,y,,,,,
+sex,demographics,,radio,"Sex","0, Female | 1, Male",,,,,,,,,,,,
+`
+
+func TestParseDataDictionaryCSV(t *testing.T) {
+	dictionary, err := redcap.ParseDataDictionaryCSV(strings.NewReader(dataDictionaryCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dictionary) != 3 {
+		t.Fatalf("expected 3 fields, got %d", len(dictionary))
+	}
+	if dictionary[1].TextValidationTypeOrShowSliderNumber != "date_ymd" || dictionary[1].Identifier != "y" {
+		t.Errorf("unexpected dob field: %+v", dictionary[1])
+	}
+	if err := dictionary.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDataDictionaryValidate(t *testing.T) {
+	dictionary := redcap.DataDictionary{
+		{FieldName: "record_id", FormName: "demographics", FieldType: "radio", SelectChoicesOrCalculations: "1, Yes"},
+		{FieldName: "age", FormName: "demographics", FieldType: "text", TextValidationTypeOrShowSliderNumber: "int"},
+		{FieldName: "age", FormName: "demographics", FieldType: "text"},
+		{FieldName: "colour", FormName: "demographics", FieldType: "checkbox", SelectChoicesOrCalculations: "1, Red | Blue"},
+		{FieldName: "size", FormName: "demographics", FieldType: "dropdown", SelectChoicesOrCalculations: "1, Small | 1, Large"},
+	}
+
+	err := dictionary.Validate()
+	var dictionaryErr *redcap.DataDictionaryError
+	if !errors.As(err, &dictionaryErr) {
+		t.Fatalf("expected a DataDictionaryError, got %v", err)
+	}
+
+	expected := []string{"first field", "invalid validation type", "duplicate field name", "code, label", "duplicate choice code"}
+	for _, problem := range expected {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("expected %q in %q", problem, err)
+		}
+	}
+}
+
+func TestImportMetadata(t *testing.T) {
+	var requests int
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		req.ParseForm()
+		form = req.PostForm
+		w.Write([]byte("3"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	_, err := client.ImportMetadata(redcap.DataDictionary{{FieldName: "record_id", FormName: "demographics", FieldType: "notes"}})
+	if err == nil {
+		t.Error("expected an invalid dictionary to be rejected")
+	}
+	if requests != 0 {
+		t.Errorf("expected no request for an invalid dictionary, got %d", requests)
+	}
+
+	body, err := client.ImportMetadataCSV(strings.NewReader(dataDictionaryCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "3" {
+		t.Errorf("unexpected response %q", body)
+	}
+	if form["content"][0] != "metadata" || !strings.Contains(form["data"][0], `"field_name":"sex"`) {
+		t.Errorf("unexpected request %v", form)
+	}
+
+	server.Close()
+	if _, err := client.ImportMetadataCSV(strings.NewReader(dataDictionaryCSV)); err == nil || !strings.Contains(err.Error(), "importing metadata") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+}
+
+func TestExportDataDictionaryTransportError(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	if _, err := client.ExportDataDictionary(); err == nil || !strings.Contains(err.Error(), "exporting the data dictionary") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+}
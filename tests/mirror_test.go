@@ -0,0 +1,147 @@
+package redcaptest
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/mirror"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// queryRows runs a query and returns its rows as strings.
+func queryRows(t *testing.T, m *mirror.Mirror, query string) [][]string {
+	t.Helper()
+	rows, err := m.DB.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	columns, _ := rows.Columns()
+	var result [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		scanned := make([]*string, len(columns))
+		for i := range values {
+			values[i] = &scanned[i]
+		}
+		if err := rows.Scan(values...); err != nil {
+			t.Fatal(err)
+		}
+		row := make([]string, len(columns))
+		for i, value := range scanned {
+			if value == nil {
+				row[i] = "NULL"
+			} else {
+				row[i] = *value
+			}
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestMirror(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	clock := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	server.Now = func() time.Time { return clock }
+	project := newTestProject()
+	project.Records[0][redcap.DataAccessGroupColumn] = "api_testing_group"
+	client := server.Client(server.AddProject(project))
+	path := filepath.Join(t.TempDir(), "mirror.db")
+
+	m, err := mirror.OpenProject(path, &client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if !reflect.DeepEqual(m.Tables(), []string{"instr_1", "instr_2"}) {
+		t.Errorf("unexpected tables %v", m.Tables())
+	}
+	syncer := m.Syncer(&client)
+	syncer.Location, syncer.Now = time.UTC, func() time.Time { return clock }
+	if result, err := syncer.Sync(); err != nil || !result.Full {
+		t.Fatalf("unexpected first sync %+v, %v", result, err)
+	}
+
+	got := queryRows(t, m, `SELECT record_id, redcap_event_name, redcap_repeat_instance, redcap_data_access_group, name, consent_form, instr_1_complete FROM instr_1 ORDER BY record_id`)
+	want := [][]string{
+		{"1", "event_1_arm_1", "1", "api_testing_group", "Ada", "NULL", "2"},
+		{"2", "event_1_arm_1", "1", "NULL", "Grace", "NULL", "NULL"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("instr_1:\ngot  %v\nwant %v", got, want)
+	}
+	got = queryRows(t, m, `SELECT record_id, feedback, typeof(colour___1), colour___2, typeof(instr_2_complete) FROM instr_2`)
+	if want := [][]string{{"2", "Fine", "integer", "NULL", "integer"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("instr_2:\ngot  %v\nwant %v", got, want)
+	}
+
+	clock = clock.Add(time.Hour)
+	if _, err := client.ImportRecords([]redcap.Record{
+		{"record_id": "2", "redcap_event_name": "event_1_arm_1", "name": "Grace Hopper"},
+		{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger"},
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.DeleteRecords([]string{"1"}, redcap.DeleteRecordsOptions{Confirm: true}); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Hour)
+	if result, err := syncer.Sync(); err != nil || result.Full || len(result.Upserted) != 2 {
+		t.Fatalf("unexpected incremental sync %+v, %v", result, err)
+	}
+	got = queryRows(t, m, `SELECT record_id, name FROM instr_1 ORDER BY record_id`)
+	if want := [][]string{{"2", "Grace Hopper"}, {"3", "Edsger"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("instr_1 after the update: %v", got)
+	}
+	if got := queryRows(t, m, `SELECT record_id, feedback FROM instr_2`); !reflect.DeepEqual(got, [][]string{{"2", "Fine"}}) {
+		t.Errorf("instr_2 after the update: %v", got)
+	}
+	m.Close()
+
+	// A changed dictionary rebuilds the tables and forces a full sync.
+	dictionary, err := client.ExportDataDictionary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dictionary = append(dictionary, redcap.MetadataField{FieldName: "age", FormName: "instr_2", FieldType: "text", TextValidationTypeOrShowSliderNumber: "integer"})
+	if m, err = mirror.Open(path, dictionary, project.Mappings); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := m.Load(); err != nil || !state.LastSync.IsZero() {
+		t.Errorf("expected the mark to be cleared, got %+v, %v", state, err)
+	}
+	if got := queryRows(t, m, `SELECT count(*) FROM instr_2 WHERE age IS NULL`); got[0][0] != "0" {
+		t.Errorf("expected an empty rebuilt table, got %v", got)
+	}
+
+	// So do changed mappings, while reopening an unchanged mirror keeps the
+	// mark.
+	mark := redcap.SyncState{LastSync: clock}
+	if err := m.Save(mark); err != nil {
+		t.Fatal(err)
+	}
+	m.Close()
+	if m, err = mirror.Open(path, dictionary, project.Mappings); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := m.Load(); err != nil || !state.LastSync.Equal(clock) {
+		t.Errorf("expected the mark to be kept, got %+v, %v", state, err)
+	}
+	m.Close()
+	mappings := append([]redcap.FormEventMapping{{ArmNum: "1", UniqueEventName: "event_2_arm_1", Form: "instr_2"}}, project.Mappings...)
+	if m, err = mirror.Open(path, dictionary, mappings); err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if state, err := m.Load(); err != nil || !state.LastSync.IsZero() {
+		t.Errorf("expected changed mappings to clear the mark, got %+v, %v", state, err)
+	}
+}
@@ -0,0 +1,144 @@
+package redcaptest
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+const projectXML = `<?xml version="1.0" encoding="UTF-8" ?>
+<ODM xmlns="http://www.cdisc.org/ns/odm/v1.3" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:redcap="https://projectredcap.org" ODMVersion="1.3.2" FileOID="000-00-0000" FileType="Snapshot" Description="Consent Study" SourceSystem="REDCap" SourceSystemVersion="13.7.3">
+<Study OID="Project.ConsentStudy">
+<GlobalVariables>
+	<StudyName>Consent Study</StudyName>
+	<StudyDescription>This file contains the metadata, events, and data for REDCap project "Consent Study".</StudyDescription>
+	<ProtocolName>Consent Study</ProtocolName>
+	<redcap:RecordAutonumberingEnabled>1</redcap:RecordAutonumberingEnabled>
+	<redcap:SurveysEnabled>1</redcap:SurveysEnabled>
+	<redcap:DisplayTodayNowButton>1</redcap:DisplayTodayNowButton>
+	<redcap:RepeatingInstrumentsAndEvents>
+		<redcap:RepeatingInstruments>
+			<redcap:RepeatingInstrument redcap:UniqueEventName="baseline_arm_1" redcap:RepeatInstrument="medications" redcap:CustomLabel="[med_name]"/>
+		</redcap:RepeatingInstruments>
+		<redcap:RepeatingEvent redcap:UniqueEventName="follow_up_arm_1"/>
+	</redcap:RepeatingInstrumentsAndEvents>
+	<redcap:DataAccessGroupsGroup>
+		<redcap:DataAccessGroups redcap:group_name="Site A"/>
+		<redcap:DataAccessGroups redcap:group_name="Site B"/>
+	</redcap:DataAccessGroupsGroup>
+	<redcap:SurveysGroup>
+		<redcap:Surveys redcap:form_name="consent" redcap:title="Informed Consent" redcap:question_by_section="0"/>
+	</redcap:SurveysGroup>
+</GlobalVariables>
+<MetaDataVersion OID="Metadata.ConsentStudy_2024-01-01_1000" Name="Consent Study" redcap:RecordIdField="record_id">
+	<Protocol>
+		<StudyEventRef StudyEventOID="Event.baseline_arm_1" OrderNumber="1" Mandatory="No"/>
+	</Protocol>
+	<StudyEventDef OID="Event.baseline_arm_1" Name="Baseline" Type="Common" Repeating="No" redcap:EventName="Baseline" redcap:UniqueEventName="baseline_arm_1" redcap:ArmNum="1" redcap:ArmName="Arm 1">
+		<FormRef FormOID="Form.consent" OrderNumber="1" Mandatory="No" redcap:FormName="consent"/>
+	</StudyEventDef>
+	<FormDef OID="Form.consent" Name="Consent" Repeating="No" redcap:FormName="consent">
+		<ItemGroupRef ItemGroupOID="consent.record_id" Mandatory="No"/>
+	</FormDef>
+	<ItemGroupDef OID="consent.record_id" Name="Consent" Repeating="No">
+		<ItemRef ItemOID="record_id" Mandatory="No" redcap:Variable="record_id"/>
+		<ItemRef ItemOID="agree" Mandatory="No" redcap:Variable="agree"/>
+	</ItemGroupDef>
+	<ItemDef OID="record_id" Name="record_id" DataType="text" Length="999" redcap:Variable="record_id" redcap:FieldType="text">
+		<Question><TranslatedText>Record ID</TranslatedText></Question>
+	</ItemDef>
+	<ItemDef OID="agree" Name="agree" DataType="text" Length="1" redcap:Variable="agree" redcap:FieldType="radio" redcap:RequiredField="y">
+		<Question><TranslatedText>Do you agree?</TranslatedText></Question>
+		<CodeListRef CodeListOID="agree.choices"/>
+	</ItemDef>
+	<CodeList OID="agree.choices" Name="agree" DataType="text" redcap:Variable="agree">
+		<CodeListItem CodedValue="1"><Decode><TranslatedText>Yes</TranslatedText></Decode></CodeListItem>
+		<CodeListItem CodedValue="0"><Decode><TranslatedText>No</TranslatedText></Decode></CodeListItem>
+	</CodeList>
+</MetaDataVersion>
+</Study>
+<ClinicalData StudyOID="Project.ConsentStudy" MetaDataVersionOID="Metadata.ConsentStudy_2024-01-01_1000">
+	<SubjectData SubjectKey="1" redcap:RecordIdField="record_id">
+		<StudyEventData StudyEventOID="Event.baseline_arm_1" StudyEventRepeatKey="1" redcap:UniqueEventName="baseline_arm_1">
+			<FormData FormOID="Form.consent" FormRepeatKey="1">
+				<ItemGroupData ItemGroupOID="consent.record_id" ItemGroupRepeatKey="1">
+					<ItemData ItemOID="record_id" Value="1"/>
+					<ItemData ItemOID="agree" Value="1"/>
+					<ItemDataBase64Binary ItemOID="signature" redcap:DocName="signature.png" redcap:MimeType="image/png">aGVsbG8=</ItemDataBase64Binary>
+				</ItemGroupData>
+			</FormData>
+		</StudyEventData>
+	</SubjectData>
+</ClinicalData>
+</ODM>`
+
+func checkProjectXML(t *testing.T, odm *redcap.ODM) {
+	t.Helper()
+	global := odm.Study.GlobalVariables
+	if global.StudyName != "Consent Study" || global.RecordAutonumberingEnabled != "1" || global.SurveysEnabled != "1" {
+		t.Errorf("unexpected global variables %+v", global)
+	}
+	repeating := global.RepeatingInstrumentsAndEvents
+	if repeating == nil || len(repeating.RepeatingInstruments.RepeatingInstruments) != 1 || repeating.RepeatingInstruments.RepeatingInstruments[0].CustomLabel != "[med_name]" {
+		t.Errorf("unexpected repeating instruments %+v", repeating)
+	}
+	if repeating == nil || len(repeating.RepeatingEvents) != 1 || repeating.RepeatingEvents[0].UniqueEventName != "follow_up_arm_1" {
+		t.Errorf("unexpected repeating events %+v", repeating)
+	}
+	if global.DataAccessGroupsGroup == nil || len(global.DataAccessGroupsGroup.DataAccessGroups) != 2 || global.DataAccessGroupsGroup.DataAccessGroups[1].GroupName != "Site B" {
+		t.Errorf("unexpected DAGs %+v", global.DataAccessGroupsGroup)
+	}
+	if global.SurveysGroup == nil || global.SurveysGroup.Surveys[0].Title != "Informed Consent" || len(global.SurveysGroup.Surveys[0].Other) != 1 {
+		t.Errorf("unexpected surveys %+v", global.SurveysGroup)
+	}
+	if len(global.Other) != 1 || global.Other[0].XMLName.Local != "DisplayTodayNowButton" {
+		t.Errorf("expected unknown settings to be kept, got %+v", global.Other)
+	}
+
+	metadata := odm.Study.MetaDataVersion
+	if metadata.RecordIdField != "record_id" || len(metadata.StudyEventRefs) != 1 || metadata.StudyEventDefs[0].UniqueEventName != "baseline_arm_1" {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	agree := odm.ItemDef("agree")
+	if agree == nil || agree.FieldType != "radio" || agree.Question != "Do you agree?" || agree.CodeListRef == nil {
+		t.Fatalf("unexpected item %+v", agree)
+	}
+	choices := odm.CodeList(agree.CodeListRef.CodeListOID)
+	if choices == nil || len(choices.CodeListItems) != 2 || choices.CodeListItems[0].Decode != "Yes" {
+		t.Errorf("unexpected code list %+v", choices)
+	}
+	if form := odm.FormDef("consent"); form == nil || form.Name != "Consent" {
+		t.Errorf("unexpected form %+v", form)
+	}
+
+	if odm.ClinicalData == nil || len(odm.ClinicalData.SubjectData) != 1 {
+		t.Fatalf("unexpected clinical data %+v", odm.ClinicalData)
+	}
+	group := odm.ClinicalData.SubjectData[0].StudyEventData[0].FormData[0].ItemGroupData[0]
+	if len(group.ItemData) != 2 || group.ItemData[1].Value != "1" {
+		t.Errorf("unexpected item data %+v", group.ItemData)
+	}
+	if len(group.ItemDataBase64Binary) != 1 || group.ItemDataBase64Binary[0].DocName != "signature.png" || group.ItemDataBase64Binary[0].Value != "aGVsbG8=" {
+		t.Errorf("unexpected file data %+v", group.ItemDataBase64Binary)
+	}
+}
+
+func TestParseODM(t *testing.T) {
+	odm, err := redcap.ParseODM(strings.NewReader(projectXML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectXML(t, odm)
+
+	var written bytes.Buffer
+	if err := odm.Write(&written); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := redcap.ParseODM(&written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkProjectXML(t, reparsed)
+}
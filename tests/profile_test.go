@@ -0,0 +1,96 @@
+package redcaptest
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+// writeConfig writes a config file into a temporary directory.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProfileTokenSources(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "pilot.token")
+	if err := os.WriteFile(tokenFile, []byte("FILETOKEN\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("STUDY_TOKEN", "ENVTOKEN")
+
+	config, err := redcap.LoadConfig(writeConfig(t, `{
+		"default": "study",
+		"profiles": {
+			"study": {"url": "https://redcap.example.com/api/", "token_env": "STUDY_TOKEN"},
+			"pilot": {"url": "https://pilot.example.com/api/", "token_file": "`+filepath.ToSlash(tokenFile)+`", "format": "csv"},
+			"admin": {"url": "https://redcap.example.com/api/", "token_command": ["echo", "HELPERTOKEN"]}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := config.Client("")
+	if err != nil || client.Token != "ENVTOKEN" || client.URL != "https://redcap.example.com/api/" || client.ResponseFormat != redcap.JSON {
+		t.Errorf("default profile: %+v, %v", client, err)
+	}
+	client, err = config.Client("pilot")
+	if err != nil || client.Token != "FILETOKEN" || client.ResponseFormat != redcap.CSV {
+		t.Errorf("pilot profile: %+v, %v", client, err)
+	}
+	if runtime.GOOS != "windows" {
+		client, err = config.Client("admin")
+		if err != nil || client.Token != "HELPERTOKEN" {
+			t.Errorf("admin profile: %+v, %v", client, err)
+		}
+	}
+	if _, err := config.Client("missing"); err == nil {
+		t.Error("expected an unknown profile to fail")
+	}
+	if names := strings.Join(config.Names(), ","); names != "admin,pilot,study" {
+		t.Errorf("unexpected profile names %s", names)
+	}
+}
+
+func TestProfileRejectsUnsafeTokens(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not checked on Windows")
+	}
+	tokenFile := filepath.Join(t.TempDir(), "shared.token")
+	if err := os.WriteFile(tokenFile, []byte("TOKEN"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	profile := redcap.Profile{URL: "https://redcap.example.com/api/", TokenFile: tokenFile}
+	if _, err := profile.Token(); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Errorf("expected a group readable token file to be refused, got %v", err)
+	}
+
+	profile = redcap.Profile{URL: "https://redcap.example.com/api/", TokenEnv: "REDCAP_TEST_UNSET_TOKEN"}
+	if _, err := profile.Token(); err == nil {
+		t.Error("expected an unset environment variable to fail")
+	}
+}
+
+func TestLoadConfigValidatesProfiles(t *testing.T) {
+	for name, content := range map[string]string{
+		"literal token":   `{"profiles": {"a": {"url": "https://x/api/", "token": "ABC"}}}`,
+		"two sources":     `{"profiles": {"a": {"url": "https://x/api/", "token_env": "A", "token_file": "a.token"}}}`,
+		"no url":          `{"profiles": {"a": {"token_env": "A"}}}`,
+		"unknown default": `{"default": "b", "profiles": {"a": {"url": "https://x/api/", "token_env": "A"}}}`,
+		"bad format":      `{"profiles": {"a": {"url": "https://x/api/", "token_env": "A", "format": "yaml"}}}`,
+	} {
+		if _, err := redcap.LoadConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("%s: expected the config to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package redcaptest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func TestImportProject(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		w.Write([]byte("0123456789ABCDEF0123456789ABCDEF\n"))
+	}))
+	defer server.Close()
+
+	project := redcap.NewProject{
+		ProjectTitle:   "Site 4 Clone",
+		Purpose:        redcap.PurposeResearch,
+		PurposeOther:   "1,3",
+		IsLongitudinal: true,
+	}
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "0123456789ABCDEF0123456789ABCDEF", ResponseFormat: "json"}
+	if _, err := client.ImportProject(project, nil); err == nil {
+		t.Error("expected a project token to be rejected")
+	}
+
+	client.Token = strings.Repeat("F", 64)
+	if _, err := client.ImportProject(redcap.NewProject{ProjectTitle: "Missing purpose", Purpose: redcap.PurposeOther}, nil); err == nil {
+		t.Error("expected purpose_other to be required")
+	}
+
+	token, err := client.ImportProject(project, strings.NewReader("<ODM></ODM>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "0123456789ABCDEF0123456789ABCDEF" {
+		t.Errorf("unexpected token %q", token)
+	}
+	if form.Get("content") != "project" || form.Get("odm") != "<ODM></ODM>" {
+		t.Errorf("unexpected request %v", form)
+	}
+
+	var settings []map[string]interface{}
+	if err := json.Unmarshal([]byte(form.Get("data")), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if settings[0]["project_title"] != "Site 4 Clone" || settings[0]["purpose"] != 2.0 || settings[0]["is_longitudinal"] != 1.0 || settings[0]["surveys_enabled"] != 0.0 {
+		t.Errorf("unexpected project settings %v", settings[0])
+	}
+
+	server.Close()
+	if _, err := client.ImportProject(project, nil); err == nil || !strings.Contains(err.Error(), "importing the project") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+}
+
+func TestImportProjectSettings(t *testing.T) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		requests = append(requests, req.PostForm)
+		if req.PostForm.Get("content") == "project" {
+			w.Write([]byte(`{"project_id":42,"project_title":"Consent Study","in_production":0,"purpose":"2","purpose_other":"1","is_longitudinal":1,"has_repeating_instruments_or_events":1,"surveys_enabled":0,"secondary_unique_field":"","project_irb_number":"IRB-1","missing_data_codes":"UNK, Unknown | NA, Not applicable"}`))
+			return
+		}
+		w.Write([]byte("2"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "csv"}
+
+	info, err := client.ExportProjectInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProjectID != 42 || !info.IsLongitudinal || !info.HasRepeatingInstrumentsOrEvents || info.SurveysEnabled || info.Purpose != redcap.PurposeResearch {
+		t.Errorf("unexpected project info %+v", info)
+	}
+	if info.MissingDataCodes != "UNK, Unknown | NA, Not applicable" || info.ProjectIRBNumber != "IRB-1" {
+		t.Errorf("unexpected project info %+v", info)
+	}
+
+	changed, err := client.ImportProjectSettings(info, info)
+	if err != nil || changed != 0 || len(requests) != 1 {
+		t.Errorf("expected no request for unchanged settings, got %d (%v)", len(requests), err)
+	}
+
+	updated := info
+	updated.SurveysEnabled = true
+	updated.ProjectIRBNumber = "IRB-2"
+	changed, err = client.ImportProjectSettings(info, updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 2 {
+		t.Errorf("expected 2 changed settings, got %d", changed)
+	}
+
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(requests[1].Get("data")), &settings); err != nil {
+		t.Fatal(err)
+	}
+	if len(settings) != 2 || settings["surveys_enabled"] != 1.0 || settings["project_irb_number"] != "IRB-2" {
+		t.Errorf("expected only the changed settings, got %v", settings)
+	}
+
+	server.Close()
+	if _, err := client.ExportProjectInfo(); err == nil || !strings.Contains(err.Error(), "exporting project") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+	if _, err := client.ImportProjectSettings(info, updated); err == nil || !strings.Contains(err.Error(), "importing project settings") {
+		t.Errorf("expected the transport error to be returned, got %v", err)
+	}
+}
@@ -0,0 +1,401 @@
+package redcaptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// recordSession runs the calls whose golden file is checked below.
+func recordSession(client redcap.RedCapClient) ([]redcap.Record, []byte, error) {
+	if _, err := client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Katherine"}}, false); err != nil {
+		return nil, nil, err
+	}
+	_, rows, err := client.ExportRecordRows(redcap.RecordsOptions{Fields: []string{"record_id", "name"}})
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := client.ExportFile("1", "consent_form", "event_1_arm_1", 0)
+	return rows, file, err
+}
+
+func TestRecorderRecordsAndReplays(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "testdata", "session.json")
+
+	server := redcaptest.NewServer()
+	token := server.AddProject(newTestProject())
+	recorder, err := redcaptest.NewRecorder(golden, redcaptest.Record, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := server.Client(token)
+	client.HTTPClient = recorder.Client()
+	recordedRows, recordedFile, err := recordSession(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	content, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token, "Ada", "Grace", "Katherine"} {
+		if strings.Contains(string(content), secret) {
+			t.Errorf("golden file contains %q", secret)
+		}
+	}
+	if recordedRows[0]["name"] != redcaptest.Redacted {
+		t.Errorf("expected the recorded export to be scrubbed, got %v", recordedRows[0])
+	}
+
+	replayer, err := redcaptest.NewRecorder(golden, redcaptest.Replay, "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = redcap.RedCapClient{URL: "http://redcap.invalid/api/", Token: "0123456789ABCDEF0123456789ABCDEF", ResponseFormat: redcap.JSON, HTTPClient: replayer.Client()}
+	rows, file, err := recordSession(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(recordedRows) || rows[2]["record_id"] != "3" || string(file) != string(recordedFile) {
+		t.Errorf("replay differs from recording: %v %q", rows, file)
+	}
+	if unused := replayer.Unused(); unused != 0 {
+		t.Errorf("expected every interaction to be replayed, %d left", unused)
+	}
+}
+
+func TestRecorderRejectsUnrecordedRequests(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "session.json")
+	if err := os.WriteFile(golden, []byte(`[{"request": {"form": {"content": ["version"], "token": ["[REDACTED]"]}}, "response": {"status_code": 200, "body": "14.0.0"}}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := redcaptest.NewRecorder(golden, redcaptest.Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("POST", "http://redcap.invalid/api/", strings.NewReader("token=0123456789ABCDEF0123456789ABCDEF&content=arm&format=json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := replayer.RoundTrip(req); err == nil {
+		t.Error("expected a request missing from the golden file to fail")
+	}
+}
+
+func TestRecorderScrubsXML(t *testing.T) {
+	responses := map[string]string{
+		"record":  `<?xml version="1.0" encoding="UTF-8" ?><records><item><record_id><![CDATA[1]]></record_id><name><![CDATA[Ada]]></name><feedback><![CDATA[]]></feedback><colour___2><![CDATA[1]]></colour___2></item></records>`,
+		"project": `<ODM><ClinicalData><SubjectData SubjectKey="1"><ItemData ItemOID="name" Value="Ada"/><ItemData Value="1" ItemOID="record_id"/><ItemData ItemOID="colour___2" Value="1"/></SubjectData></ClinicalData></ODM>`,
+		"broken":  `{"name": "Ada"`,
+		"html":    "record_id,name\n1,Ada\n",
+		"untyped": "record_id,name\n1,Ada\n",
+		"version": "14.0.0",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		content := req.PostForm.Get("content")
+		switch content {
+		case "broken":
+			w.Header().Set("Content-Type", "application/json")
+		case "html", "version":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		case "untyped":
+			w.Header()["Content-Type"] = nil
+		default:
+			w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		}
+		w.Write([]byte(responses[content]))
+	}))
+	defer server.Close()
+
+	recorder, err := redcaptest.NewRecorder(filepath.Join(t.TempDir(), "session.json"), redcaptest.Record, "name", "feedback", "colour")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redcap.RedCapClient{URL: server.URL, Token: "0123456789ABCDEF0123456789ABCDEF", HTTPClient: recorder.Client()}
+	for content, want := range map[string]string{
+		"record":  `<name>[REDACTED]</name><feedback><![CDATA[]]></feedback><colour___2>[REDACTED]</colour___2>`,
+		"project": `<ItemData ItemOID="name" Value="[REDACTED]"/><ItemData Value="1" ItemOID="record_id"/><ItemData ItemOID="colour___2" Value="[REDACTED]"/>`,
+	} {
+		body, err := client.ExportContent(content, redcap.XML)
+		if err != nil || !strings.Contains(string(body), want) || strings.Contains(string(body), "Ada") {
+			t.Errorf("%s: expected the XML to be scrubbed, got %s, %v", content, body, err)
+		}
+	}
+	if body, err := client.ExportContent("version", redcap.JSON); err != nil || string(body) != "14.0.0" {
+		t.Errorf("expected a single value to be recorded as it is, got %s, %v", body, err)
+	}
+	for name, form := range map[string]string{
+		"broken response":  "token=0123456789ABCDEF0123456789ABCDEF&content=broken&format=json",
+		"html response":    "token=0123456789ABCDEF0123456789ABCDEF&content=html&format=csv",
+		"untyped response": "token=0123456789ABCDEF0123456789ABCDEF&content=untyped&format=csv",
+		"malformed import": "token=0123456789ABCDEF0123456789ABCDEF&content=record&format=xml&data=" + url.QueryEscape("<records><item><name>Ada</item></records>"),
+	} {
+		req, err := http.NewRequest("POST", server.URL, strings.NewReader(form))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if _, err := recorder.RoundTrip(req); err == nil {
+			t.Errorf("%s: expected data that cannot be scrubbed to fail the request", name)
+		}
+	}
+}
+
+var recordGolden = flag.Bool("record", false, "record the golden API session under testdata against the fake server")
+
+// apiCall is one client method called in the golden API session. Calls
+// share the state of the session through it.
+type apiCall struct {
+	name string
+	call func(s *apiSession) (interface{}, error)
+}
+
+type apiSession struct {
+	client, super redcap.RedCapClient
+	dir           string
+	info          redcap.ProjectInfo
+	metadataCSV   []byte
+}
+
+// apiCalls runs every Export and Import method the fake server answers, in
+// an order that leaves the project usable for the calls that follow.
+var apiCalls = []apiCall{
+	{"ExportArms", func(s *apiSession) (interface{}, error) { return s.client.ExportArms() }},
+	{"ExportArmDefinitions", func(s *apiSession) (interface{}, error) { return s.client.ExportArmDefinitions("1") }},
+	{"ExportContent", func(s *apiSession) (interface{}, error) {
+		var err error
+		s.metadataCSV, err = s.client.ExportContent("metadata", redcap.CSV)
+		return s.metadataCSV, err
+	}},
+	{"ExportDags", func(s *apiSession) (interface{}, error) { return s.client.ExportDags() }},
+	{"ExportDagMaps", func(s *apiSession) (interface{}, error) { return s.client.ExportDagMaps() }},
+	{"ExportDataAccessGroups", func(s *apiSession) (interface{}, error) { return s.client.ExportDataAccessGroups() }},
+	{"ExportDataDictionary", func(s *apiSession) (interface{}, error) { return s.client.ExportDataDictionary() }},
+	{"ExportEvents", func(s *apiSession) (interface{}, error) { return s.client.ExportEvents() }},
+	{"ExportEventDefinitions", func(s *apiSession) (interface{}, error) { return s.client.ExportEventDefinitions("1") }},
+	{"ExportFieldNames", func(s *apiSession) (interface{}, error) { return s.client.ExportFieldNames("colour") }},
+	{"ExportFile", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportFile("1", "consent_form", "event_1_arm_1", 0)
+	}},
+	{"ExportForStatPackage", func(s *apiSession) (interface{}, error) {
+		if err := s.client.ExportForStatPackage(s.dir, "study", redcap.Stata, redcap.RecordsOptions{}); err != nil {
+			return nil, err
+		}
+		files := make(map[string]string)
+		for _, name := range []string{"study.csv", "study.do"} {
+			content, err := os.ReadFile(filepath.Join(s.dir, name))
+			if err != nil {
+				return nil, err
+			}
+			files[name] = string(content)
+		}
+		return files, nil
+	}},
+	{"ExportFormEventMappings", func(s *apiSession) (interface{}, error) { return s.client.ExportFormEventMappings("1") }},
+	{"ExportInstrumentEventMaps", func(s *apiSession) (interface{}, error) { return s.client.ExportInstrumentEventMaps() }},
+	{"ExportInstrumentPDF", func(s *apiSession) (interface{}, error) {
+		var pdf bytes.Buffer
+		_, err := s.client.ExportInstrumentPDF(&pdf, redcap.PDFOptions{Record: "1", Event: "event_1_arm_1", Instrument: "instr_1"})
+		return pdf.Bytes(), err
+	}},
+	{"ExportInstrumentTables", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportInstrumentTables(redcap.RecordsOptions{})
+	}},
+	{"ExportInstruments", func(s *apiSession) (interface{}, error) { return s.client.ExportInstruments() }},
+	{"ExportMetadata", func(s *apiSession) (interface{}, error) { return s.client.ExportMetadata() }},
+	{"ExportProject", func(s *apiSession) (interface{}, error) { return s.client.ExportProject() }},
+	{"ExportProjectInfo", func(s *apiSession) (interface{}, error) {
+		var err error
+		s.info, err = s.client.ExportProjectInfo()
+		return s.info, err
+	}},
+	{"ExportProjectXML", func(s *apiSession) (interface{}, error) {
+		var odm bytes.Buffer
+		_, err := s.client.ExportProjectXML(&odm, redcap.ProjectXMLOptions{})
+		return odm.Bytes(), err
+	}},
+	{"ExportRecordRows", func(s *apiSession) (interface{}, error) {
+		header, rows, err := s.client.ExportRecordRows(redcap.RecordsOptions{Fields: []string{"record_id", "name"}})
+		return map[string]interface{}{"header": header, "rows": rows}, err
+	}},
+	{"ExportRecords", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportRecords(redcap.RecordsOptions{Records: []string{"2"}, DateRangeBegin: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)})
+	}},
+	{"ExportRedcapVersion", func(s *apiSession) (interface{}, error) { return s.client.ExportRedcapVersion() }},
+	{"ExportReports", func(s *apiSession) (interface{}, error) { return s.client.ExportReports("1") }},
+	{"ExportSurveyLink", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportSurveyLink("1", "instr_2", "event_1_arm_1")
+	}},
+	{"ExportSurveyParticipantList", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportSurveyParticipantList("instr_2", "event_1_arm_1")
+	}},
+	{"ExportSurveyParticipants", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportSurveyParticipants("instr_2", "event_1_arm_1")
+	}},
+	{"ExportSurveyQueueLink", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportSurveyQueueLink("1", "instr_2", "event_1_arm_1")
+	}},
+	{"ExportSurveyReturnCode", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportSurveyReturnCode("1", "instr_2", "event_1_arm_1")
+	}},
+	{"ExportUserList", func(s *apiSession) (interface{}, error) { return s.client.ExportUserList() }},
+	{"ExportUserRoles", func(s *apiSession) (interface{}, error) { return s.client.ExportUserRoles() }},
+	{"ExportUsers", func(s *apiSession) (interface{}, error) { return s.client.ExportUsers() }},
+	{"ImportArms", func(s *apiSession) (interface{}, error) { return s.client.ImportArms() }},
+	{"ImportContent", func(s *apiSession) (interface{}, error) {
+		return s.client.ImportContent("record", redcap.CSV, []byte("record_id,redcap_event_name,name\n4,event_1_arm_1,Barbara\n"), false)
+	}},
+	{"ImportDags", func(s *apiSession) (interface{}, error) { return s.client.ImportDags() }},
+	{"ImportEvents", func(s *apiSession) (interface{}, error) { return s.client.ImportEvents() }},
+	{"ImportFile", func(s *apiSession) (interface{}, error) {
+		return s.client.ImportFile("1", "consent_form", "event_1_arm_1", 0, "signed.pdf", strings.NewReader("%PDF-signed"))
+	}},
+	{"ImportInstrumentEventMaps", func(s *apiSession) (interface{}, error) { return s.client.ImportInstrumentEventMaps() }},
+	{"ImportMetadataCSV", func(s *apiSession) (interface{}, error) {
+		return s.client.ImportMetadataCSV(bytes.NewReader(s.metadataCSV))
+	}},
+	{"ImportMetadata", func(s *apiSession) (interface{}, error) {
+		dictionary, err := s.client.ExportDataDictionary()
+		if err != nil {
+			return nil, err
+		}
+		return s.client.ImportMetadata(dictionary)
+	}},
+	{"ImportProject", func(s *apiSession) (interface{}, error) {
+		return s.super.ImportProject(redcap.NewProject{ProjectTitle: "Created", Purpose: redcap.PurposePractice}, nil)
+	}},
+	{"ImportProjectSettings", func(s *apiSession) (interface{}, error) {
+		updated := s.info
+		updated.ProjectTitle = "API Testing v2"
+		return s.client.ImportProjectSettings(s.info, updated)
+	}},
+	{"ImportRecords", func(s *apiSession) (interface{}, error) {
+		return s.client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Katherine", "feedback": "Kind"}}, false)
+	}},
+	{"ImportUserDagMaps", func(s *apiSession) (interface{}, error) { return s.client.ImportUserDagMaps() }},
+	{"ImportUserRoles", func(s *apiSession) (interface{}, error) { return s.client.ImportUserRoles() }},
+	{"ImportUsers", func(s *apiSession) (interface{}, error) { return s.client.ImportUsers() }},
+	{"ExportLogging", func(s *apiSession) (interface{}, error) {
+		return s.client.ExportLogging(redcap.LoggingOptions{BeginTime: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)})
+	}},
+}
+
+// run makes every call of the session and returns their results, keyed by
+// method, with byte slices as text.
+func (s *apiSession) run() (map[string]interface{}, error) {
+	results := make(map[string]interface{})
+	for _, call := range apiCalls {
+		result, err := call.call(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", call.name, err)
+		}
+		if body, ok := result.([]byte); ok {
+			result = string(body)
+		}
+		results[call.name] = result
+	}
+	return results, nil
+}
+
+// TestAPISessionReplay pins the request each Export and Import method
+// sends, and what it makes of REDCap's answer, against the golden session
+// in testdata. Run with -record to record it again from the fake server.
+func TestAPISessionReplay(t *testing.T) {
+	golden := filepath.Join("testdata", "api_session.json")
+	want := filepath.Join("testdata", "api_results.json")
+	phi := []string{"name", "feedback"}
+
+	if *recordGolden {
+		server := redcaptest.NewServer()
+		defer server.Close()
+		server.Now = func() time.Time { return time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC) }
+		recorder, err := redcaptest.NewRecorder(golden, redcaptest.Record, phi...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session := &apiSession{client: server.Client(server.AddProject(newTestProject())), dir: t.TempDir()}
+		session.client.HTTPClient = recorder.Client()
+		session.super = redcap.RedCapClient{URL: session.client.URL, Token: server.SuperToken, ResponseFormat: redcap.JSON, HTTPClient: recorder.Client()}
+		results, err := session.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recorder.Save(); err != nil {
+			t.Fatal(err)
+		}
+		content, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(want, append(content, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replayer, err := redcaptest.NewRecorder(golden, redcaptest.Replay, phi...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redcap.RedCapClient{URL: "http://redcap.invalid/api/", Token: "0123456789ABCDEF0123456789ABCDEF", ResponseFormat: redcap.JSON, HTTPClient: replayer.Client()}
+	super := client
+	super.Token = strings.Repeat("0123456789ABCDEF", 4)
+	session := &apiSession{client: client, super: super, dir: t.TempDir()}
+	results, err := session.run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unused := replayer.Unused(); unused != 0 {
+		t.Errorf("expected every interaction to be replayed, %d left", unused)
+	}
+
+	got, err := json.Marshal(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected, actual map[string]interface{}
+	if err := json.Unmarshal(content, &expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(got, &actual); err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range apiCalls {
+		if !reflect.DeepEqual(actual[call.name], expected[call.name]) {
+			t.Errorf("%s: got %v, want %v", call.name, actual[call.name], expected[call.name])
+		}
+	}
+
+	recorded, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"Ada", "Grace", "Katherine", "Barbara", "Fine", "Kind"} {
+		if strings.Contains(string(recorded), secret) {
+			t.Errorf("golden session contains %q", secret)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package redcaptest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+func TestDeleteRecords(t *testing.T) {
+	var requests []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		requests = append(requests, req.PostForm)
+		if req.PostForm.Get("action") == "delete" {
+			w.Write([]byte("2"))
+			return
+		}
+		if req.PostForm.Get("content") == "metadata" {
+			w.Write([]byte(`[{"field_name": "study_id", "form_name": "enrolment", "field_type": "text"}, {"field_name": "med_name", "form_name": "medications", "field_type": "text"}]`))
+			return
+		}
+		w.Write([]byte("study_id,redcap_event_name,redcap_repeat_instrument,redcap_repeat_instance,med_name\n" +
+			"1,baseline_arm_1,medications,1,aspirin\n" +
+			"1,baseline_arm_1,medications,2,ibuprofen\n" +
+			"2,baseline_arm_2,medications,2,paracetamol\n"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	records := []string{"1", "2"}
+
+	if _, _, err := client.DeleteRecords(records, redcap.DeleteRecordsOptions{}); err == nil {
+		t.Error("expected a deletion without Confirm or DryRun to be rejected")
+	}
+	if len(requests) != 0 {
+		t.Fatalf("expected no requests, got %d", len(requests))
+	}
+
+	count, plan, err := client.DeleteRecords(records, redcap.DeleteRecordsOptions{
+		Arm:            "1",
+		Instrument:     "medications",
+		RepeatInstance: 2,
+		DryRun:         true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || len(plan) != 1 || plan[0].Record != "1" || plan[0].RepeatInstance != "2" || plan[0].Event != "baseline_arm_1" {
+		t.Errorf("unexpected dry run %d %+v", count, plan)
+	}
+	if requests[0].Get("action") != "" || requests[0].Get("forms[0]") != "medications" || requests[0].Get("records[1]") != "2" {
+		t.Errorf("unexpected dry run request %v", requests[0])
+	}
+
+	count, plan, err = client.DeleteRecords(records, redcap.DeleteRecordsOptions{
+		Event:         "baseline_arm_1",
+		Instrument:    "medications",
+		DeleteLogging: true,
+		Confirm:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 || plan != nil {
+		t.Errorf("unexpected deletion %d %+v", count, plan)
+	}
+	deletion := requests[2]
+	if deletion.Get("records[0]") != "1" || deletion.Get("records[1]") != "2" || deletion.Get("event") != "baseline_arm_1" || deletion.Get("delete_logging") != "1" {
+		t.Errorf("unexpected delete request %v", deletion)
+	}
+	if deletion.Has("arm") || deletion.Has("repeat_instance") {
+		t.Errorf("unset options should not be sent: %v", deletion)
+	}
+}
+
+func TestDeleteRecordsDryRunSkipsRecordsWithoutInstrumentData(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	project := newTestProject()
+	// Record 3 only has an unchecked box and an unstarted status on instr_2.
+	project.Records = append(project.Records, redcap.Record{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger", "colour___1": "0", "colour___2": "0", "instr_2_complete": "0"})
+	client := server.Client(server.AddProject(project))
+
+	count, plan, err := client.DeleteRecords([]string{"1", "2", "3"}, redcap.DeleteRecordsOptions{Instrument: "instr_2", DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []redcap.RecordDeletion{{Record: "2", Event: "event_1_arm_1"}}; count != 1 || !reflect.DeepEqual(plan, want) {
+		t.Errorf("expected only record 2 to have instr_2 data, got %d %+v", count, plan)
+	}
+	if count, _, err := client.DeleteRecords([]string{"1", "3"}, redcap.DeleteRecordsOptions{Instrument: "instr_1", DryRun: true}); err != nil || count != 2 {
+		t.Errorf("expected both records to have instr_1 data, got %d, %v", count, err)
+	}
+}
+
+func TestDeleteRecordsRejectsArmOfClassicProject(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("study_id,name\n1,Ada\n"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	if _, plan, err := client.DeleteRecords([]string{"1"}, redcap.DeleteRecordsOptions{Arm: "1", DryRun: true}); err == nil {
+		t.Errorf("expected an arm of a classic project to be rejected, got %+v", plan)
+	}
+}
+
+func TestRenameRecordAndSwitchDag(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		form = req.PostForm
+		w.Write([]byte("1"))
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+	if _, err := client.RenameRecord("1&arm=2", "", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("action") != "rename" || form.Get("record") != "1&arm=2" || form.Get("new_record_name") != "10" || form.Has("arm") {
+		t.Errorf("unexpected rename request %v", form)
+	}
+	if _, err := client.RenameRecord("1", "2", "10"); err != nil || form.Get("arm") != "2" {
+		t.Errorf("expected the arm to be sent, got %v (%v)", form, err)
+	}
+
+	if _, err := client.SwitchDag("site_a&format=csv"); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("action") != "switch" || form.Get("dag") != "site_a&format=csv" || form.Get("format") != "json" {
+		t.Errorf("unexpected switch request %v", form)
+	}
+
+	server.Close()
+	if _, err := client.RenameRecord("1", "", "10"); err == nil {
+		t.Error("expected the rename transport error to be returned")
+	}
+	if _, err := client.SwitchDag("site_a"); err == nil {
+		t.Error("expected the switch transport error to be returned")
+	}
+}
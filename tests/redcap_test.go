@@ -1,351 +1,212 @@
 package redcaptest
 
 import (
-	"encoding/csv"
 	"io"
-	"log"
-	"net/http"
-	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
 )
 
-type testConfig struct {
-	ProjectName string
-	Token       string
-	ServerURL   string
-	PID         int
-	Server      string
-	ReadOnly    bool
-	Operational bool
-	Notes       string
+// newTestProject builds a longitudinal project with two arms, a survey, a
+// data access group and two records, which every client method can be
+// pointed at.
+func newTestProject() *redcaptest.Project {
+	project := redcaptest.NewProject("API Testing", redcap.DataDictionary{
+		{FieldName: "record_id", FormName: "instr_1", FieldType: "text", FieldLabel: "Record ID"},
+		{FieldName: "name", FormName: "instr_1", FieldType: "text", FieldLabel: "Name"},
+		{FieldName: "consent_form", FormName: "instr_1", FieldType: "file", FieldLabel: "Consent form"},
+		{FieldName: "feedback", FormName: "instr_2", FieldType: "notes", FieldLabel: "Feedback"},
+		{FieldName: "colour", FormName: "instr_2", FieldType: "checkbox", FieldLabel: "Colour", SelectChoicesOrCalculations: "1, Red | 2, Blue"},
+	})
+	project.Info.IsLongitudinal = true
+	project.Info.SurveysEnabled = true
+	project.Arms = []redcaptest.Row{{"arm_num": "1", "name": "Arm 1"}, {"arm_num": "2", "name": "Arm 2"}}
+	project.Events = []redcaptest.Row{
+		{"event_name": "Event 1", "arm_num": "1", "unique_event_name": "event_1_arm_1"},
+		{"event_name": "Event 2", "arm_num": "2", "unique_event_name": "event_2_arm_1"},
+	}
+	project.Mappings = []redcap.FormEventMapping{
+		{ArmNum: "1", UniqueEventName: "event_1_arm_1", Form: "instr_1"},
+		{ArmNum: "1", UniqueEventName: "event_1_arm_1", Form: "instr_2"},
+	}
+	project.Records = []redcap.Record{
+		{"record_id": "1", "redcap_event_name": "event_1_arm_1", "name": "Ada", "instr_1_complete": "2"},
+		{"record_id": "2", "redcap_event_name": "event_1_arm_1", "name": "Grace", "feedback": "Fine", "colour___1": "1", "instr_2_complete": "1"},
+	}
+	project.Files[redcaptest.FileKey{Record: "1", Field: "consent_form", Event: "event_1_arm_1"}] = redcaptest.File{Name: "consent.pdf", ContentType: "application/pdf", Data: []byte("%PDF")}
+	project.DAGs = []redcaptest.Row{{"data_access_group_name": "API testing group", "unique_group_name": "api_testing_group", "data_access_group_id": "1"}}
+	project.Users = []redcaptest.Row{{"username": "testuser"}, {"username": "user1"}}
+	project.UserRoles = []redcaptest.Row{{"unique_role_name": "U-ADMIN", "role_label": "Admin"}}
+	project.Surveys["instr_2"] = []redcap.SurveyParticipant{{Email: "ada@example.com", Record: "1"}}
+	project.Reports["1"] = []redcaptest.Row{{"record_id": "1", "name": "Ada"}}
+	return project
 }
 
-func initConfig() []testConfig {
-	// Send a get request to get some test data
-	resp, err := http.Get("https://raw.githubusercontent.com/redcap-tools/redcap-test-datasets/master/connections/oklahoma-bbmc.csv")
+// expectOK fails the test if a call errored or REDCap answered with an error
+// the client passed through as the response body.
+func expectOK(t *testing.T, call string, body []byte, err error) {
+	t.Helper()
 	if err != nil {
-		log.Fatal("Error fetching CSV:", err)
+		t.Errorf("%s: %v", call, err)
+	} else if strings.Contains(string(body), `"error"`) {
+		t.Errorf("%s: %s", call, body)
 	}
-	defer resp.Body.Close()
-
-	reader := csv.NewReader(resp.Body)
-	reader.Comma = ',' // CSV field delimiter
-
-	_, err = reader.Read()
-	if err != nil {
-		log.Fatal("Error reading headers:", err)
-	}
-
-	var configs []testConfig
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatal("Error reading record:", err)
-		}
-
-		if len(record) < 8 {
-			log.Fatal("Record does not contain enough data")
-		}
-
-		pid, err := strconv.Atoi(record[3])
-		if err != nil {
-			log.Fatalf("Invalid PID: %v", err)
-		}
-
-		readOnly, err := strconv.ParseBool(strings.ToLower(record[5]))
-		if err != nil {
-			log.Fatalf("Invalid ReadOnly value: %v", err)
-		}
-
-		operational, err := strconv.ParseBool(strings.ToLower(record[6]))
-		if err != nil {
-			log.Fatalf("Invalid Operational value: %v", err)
-		}
-
-		config := testConfig{
-			ProjectName: record[0],
-			Token:       record[1],
-			ServerURL:   record[2],
-			PID:         pid,
-			Server:      record[4],
-			ReadOnly:    readOnly,
-			Operational: operational,
-			Notes:       record[7],
-		}
-		configs = append(configs, config)
-	}
-	return configs
 }
-func TestRedcap(t *testing.T) {
-	config := initConfig()	
-
-	var err error
-
-	client := redcap.RedCapClient{
-		URL:            config[1].ServerURL,
-		Token:          config[1].Token,
-		ResponseFormat: "json",
-	}
-
-	var testArr = []string{"1", "2"}
-
-	_, err = client.DeleteArms(testArr)
-
-	if err != nil {
-		t.Error("\n", err)
-	}
-	
-	var testDags = []string{"group_api", "group_api2"}
-	_, err = client.DeleteDags(testDags)
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	var testEvents = []string{"event_1", "event_2"}
-	_, err = client.DeleteEvents(testEvents)
-
-	if err != nil {
-		t.Error(err)
-	}
-
-
-	_, err = client.DeleteFile("1", "test_field", "test_event")
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	_, err = client.DeleteRecords("1", "example_field", "example_event")
-
-	if err != nil {
-		t.Error(err)
-	}
-	var testUserRoles = []string{"admin", "user"}
-	_, err = client.DeleteUserRoles(testUserRoles)
-
-	if err != nil {
-		t.Error(err)
-	}
-	var testUsers = []string{"user1", "user2"}
-	_, err = client.DeleteUsers(testUsers)
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	_, err = client.ExportArms()
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	_, err = client.ExportDags()
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	_, err = client.ExportEvents()
-
-	if err != nil {
-		t.Error(err)
-	}
-
-	_, err = client.ExportFieldNames("some_field")
-
-	if err != nil {
-		t.Error(err)
-	}
 
-	_, err = client.ExportFile("1", "some_field", "some_event")
+func TestRedcap(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	server.Now = func() time.Time { return time.Date(2021, time.June, 1, 12, 0, 0, 0, time.UTC) }
 
-	if err != nil {
-		t.Error(err)
-	}
+	project := newTestProject()
+	client := server.Client(server.AddProject(project))
 
-	_, err = client.ExportInstrumentEventMaps()
+	var body []byte
+	var err error
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportArms()
+	expectOK(t, "ExportArms", body, err)
 
-	_, err = client.ExportInstrumentPDF()
+	body, err = client.ExportDags()
+	expectOK(t, "ExportDags", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportEvents()
+	expectOK(t, "ExportEvents", body, err)
 
-	_, err = client.ExportInstruments()
+	body, err = client.ExportFieldNames("name")
+	expectOK(t, "ExportFieldNames", body, err)
 
-	if err != nil {
-		t.Error(err)
+	body, err = client.ExportFile("1", "consent_form", "event_1_arm_1", 0)
+	expectOK(t, "ExportFile", body, err)
+	if string(body) != "%PDF" {
+		t.Errorf("ExportFile: unexpected file %q", body)
 	}
 
-	_, err = client.ExportLogging(time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC), time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC))
+	body, err = client.ExportInstrumentEventMaps()
+	expectOK(t, "ExportInstrumentEventMaps", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	_, err = client.ExportInstrumentPDF(io.Discard, redcap.PDFOptions{})
+	expectOK(t, "ExportInstrumentPDF", nil, err)
 
-	_, err = client.ExportMetadata()
+	body, err = client.ExportInstruments()
+	expectOK(t, "ExportInstruments", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportMetadata()
+	expectOK(t, "ExportMetadata", body, err)
 
-	_, err = client.ExportProjectXML()
+	_, err = client.ExportProjectXML(io.Discard, redcap.ProjectXMLOptions{})
+	expectOK(t, "ExportProjectXML", nil, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportProject()
+	expectOK(t, "ExportProject", body, err)
 
-	_, err = client.ExportProject()
+	body, err = client.ExportRecords(redcap.RecordsOptions{})
+	expectOK(t, "ExportRecords", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportRedcapVersion()
+	expectOK(t, "ExportRedcapVersion", body, err)
 
-	_, err = client.ExportRecords()
+	body, err = client.ExportReports("1")
+	expectOK(t, "ExportReports", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportSurveyLink("1", "instr_2", "event_1_arm_1")
+	expectOK(t, "ExportSurveyLink", body, err)
 
-	_, err = client.ExportRedcapVersion()
+	body, err = client.ExportSurveyParticipants("instr_2", "event_1_arm_1")
+	expectOK(t, "ExportSurveyParticipants", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportSurveyQueueLink("1", "instr_2", "event_1_arm_1")
+	expectOK(t, "ExportSurveyQueueLink", body, err)
 
-	_, err = client.ExportReports("1")
+	body, err = client.ExportSurveyReturnCode("1", "instr_2", "event_1_arm_1")
+	expectOK(t, "ExportSurveyReturnCode", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportDagMaps()
+	expectOK(t, "ExportDagMaps", body, err)
 
-	_, err = client.ExportSurveyLink("1", "some_instrument", "some_event")
+	body, err = client.ExportUserRoles()
+	expectOK(t, "ExportUserRoles", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ExportUsers()
+	expectOK(t, "ExportUsers", body, err)
 
-	_, err = client.ExportSurveyParticipants("some_instrument", "some_event")
+	body, err = client.ImportArms()
+	expectOK(t, "ImportArms", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ImportDags()
+	expectOK(t, "ImportDags", body, err)
 
-	_, err = client.ExportSurveyQueueLink("1", "some_instrument", "some_event")
+	body, err = client.ImportEvents()
+	expectOK(t, "ImportEvents", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ImportFile("1", "consent_form", "event_1_arm_1", 0, "signed.pdf", strings.NewReader("%PDF-signed"))
+	expectOK(t, "ImportFile", body, err)
 
-	_, err = client.ExportSurveyReturnCode("1", "some_instrument", "some_event")
+	body, err = client.ImportInstrumentEventMaps()
+	expectOK(t, "ImportInstrumentEventMaps", body, err)
 
-	if err != nil {
-		t.Error(err)
+	super := redcap.RedCapClient{URL: client.URL, Token: server.SuperToken, ResponseFormat: redcap.JSON}
+	token, err := super.ImportProject(redcap.NewProject{ProjectTitle: "Created", Purpose: redcap.PurposePractice}, nil)
+	expectOK(t, "ImportProject", nil, err)
+	if server.Project(token) == nil {
+		t.Errorf("ImportProject: no project for token %q", token)
 	}
 
-	_, err = client.ExportDagMaps()
+	body, err = client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Katherine"}}, false)
+	expectOK(t, "ImportRecords", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
+	body, err = client.ImportUserDagMaps()
+	expectOK(t, "ImportUserDagMaps", body, err)
 
-	_, err = client.ExportUserRoles()
+	body, err = client.ImportUserRoles()
+	expectOK(t, "ImportUserRoles", body, err)
 
-	if err != nil {
-		t.Error(err)
-	}
-
-	_, err = client.ExportUsers()
+	body, err = client.ImportUsers()
+	expectOK(t, "ImportUsers", body, err)
 
-	if err != nil {
-		t.Error(err)
+	entries, err := client.ExportLogging(redcap.LoggingOptions{
+		BeginTime: time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC),
+	})
+	expectOK(t, "ExportLogging", nil, err)
+	if len(entries) == 0 {
+		t.Error("ExportLogging: expected the imports to be logged")
 	}
 
-	// _, err = client.ImportArms()
-
-	// if err != nil {
-	// 	t.Error(err)
-	// }
-
-	// _, err = client.ImportDags()
-
-	// if err != nil {
-	// 	t.Error(err)
-	// }
+	body, err = client.RenameRecord("1", "1", "10")
+	expectOK(t, "RenameRecord", body, err)
 
-	// _, err = client.ImportEvents()
+	body, err = client.SwitchDag("api_testing_group")
+	expectOK(t, "SwitchDag", body, err)
 
-	// if err != nil {
-	// 	t.Error(err)
-	// }
+	body, err = client.DeleteEvents([]string{"event_2_arm_1"})
+	expectOK(t, "DeleteEvents", body, err)
 
-	// _, err = client.ImportFile()
+	body, err = client.DeleteArms([]string{"2"})
+	expectOK(t, "DeleteArms", body, err)
 
-	// if err != nil {
-	// 	t.Error(err)
-	// }
+	body, err = client.DeleteDags([]string{"group_api"})
+	expectOK(t, "DeleteDags", body, err)
 
-	// _, err = client.ImportInstrumentEventMaps()
+	body, err = client.DeleteFile("10", "consent_form", "event_1_arm_1", 0)
+	expectOK(t, "DeleteFile", body, err)
 
-	// if err != nil {
-	// 	t.Error(err)
-	// }
+	_, _, err = client.DeleteRecords([]string{"2"}, redcap.DeleteRecordsOptions{Instrument: "instr_2", Event: "event_1_arm_1", Confirm: true})
+	expectOK(t, "DeleteRecords", nil, err)
 
-	// _, err = client.ImportProject()
+	body, err = client.DeleteUserRoles([]string{"U-ADMIN"})
+	expectOK(t, "DeleteUserRoles", body, err)
 
-	// if err != nil {
-	// 	t.Error(err)
-	// }
+	body, err = client.DeleteUsers([]string{"user1", "test_user_47"})
+	expectOK(t, "DeleteUsers", body, err)
 
-	// _, err = client.ImportRecords()
-
-	// if err != nil {
-	// 	t.Error(err)
-	// }
-
-	// _, err = client.ImportUserDagMaps()
-
-	// if err != nil {
-	// 	t.Error(err)
-	// }
-
-	// _, err = client.ImportUserRoles()
-
-	// if err != nil {
-	// 	t.Error(err)
-	// }
-
-	// _, err = client.ImportUsers()
-
-	// if err != nil {
-	// 	t.Error(err)
-	// }
-
-	_, err = client.RenameRecord(
-		"1",
-		"some_field",
-		"2",
-	)
-
-	if err != nil {
-		t.Error(err)
+	if len(project.Arms) != 1 || len(project.Events) != 1 || len(project.DAGs) != 1 || len(project.Users) != 1 || len(project.UserRoles) != 1 {
+		t.Errorf("unexpected project after deletes: arms %v, events %v, dags %v, users %v, roles %v", project.Arms, project.Events, project.DAGs, project.Users, project.UserRoles)
 	}
-
-	_, err = client.SwitchDag("some_dag")
-
-	if err != nil {
-		t.Error(err)
+	if _, ok := project.Records[1]["feedback"]; ok || project.Records[1]["name"] != "Grace" {
+		t.Errorf("expected only instr_2 of record 2 to be deleted, got %v", project.Records[1])
 	}
 }
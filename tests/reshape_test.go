@@ -0,0 +1,92 @@
+package redcaptest
+
+import (
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+var reshapeDictionary = redcap.DataDictionary{
+	{FieldName: "study_id", FormName: "enrolment", FieldType: "text"},
+	{FieldName: "race", FormName: "enrolment", FieldType: "checkbox", SelectChoicesOrCalculations: "1, White | -1, Unknown"},
+	{FieldName: "dose", FormName: "medications", FieldType: "text"},
+}
+
+var reshapeMappings = []redcap.FormEventMapping{
+	{ArmNum: "1", UniqueEventName: "baseline_arm_1", Form: "enrolment"},
+	{ArmNum: "1", UniqueEventName: "baseline_arm_1", Form: "medications"},
+	{ArmNum: "1", UniqueEventName: "week_4_arm_1", Form: "medications"},
+}
+
+var reshapeRows = []redcap.Record{
+	{"study_id": "1", "redcap_event_name": "baseline_arm_1", "redcap_repeat_instrument": "", "redcap_repeat_instance": "", "race___1": "1", "race____1": "0", "enrolment_complete": "2", "dose": "", "medications_complete": ""},
+	{"study_id": "1", "redcap_event_name": "baseline_arm_1", "redcap_repeat_instrument": "medications", "redcap_repeat_instance": "1", "race___1": "", "race____1": "", "enrolment_complete": "", "dose": "5mg", "medications_complete": "1"},
+	{"study_id": "1", "redcap_event_name": "week_4_arm_1", "redcap_repeat_instrument": "medications", "redcap_repeat_instance": "1", "race___1": "", "race____1": "", "enrolment_complete": "", "dose": "10mg", "medications_complete": "0"},
+}
+
+func TestSplitByInstrument(t *testing.T) {
+	tables := redcap.SplitByInstrument(reshapeRows, reshapeDictionary, reshapeMappings)
+	if len(tables) != 2 {
+		t.Fatalf("expected 2 tables, got %d", len(tables))
+	}
+
+	enrolment, medications := tables[0], tables[1]
+	if strings.Join(enrolment.Columns, ",") != "study_id,redcap_event_name,redcap_repeat_instance,race___1,race____1,enrolment_complete" {
+		t.Errorf("unexpected enrolment columns %v", enrolment.Columns)
+	}
+	if len(enrolment.Rows) != 1 || enrolment.Rows[0]["race___1"] != "1" {
+		t.Errorf("unexpected enrolment rows %v", enrolment.Rows)
+	}
+	if len(medications.Rows) != 2 || medications.Rows[1]["redcap_event_name"] != "week_4_arm_1" || medications.Rows[1]["dose"] != "10mg" {
+		t.Errorf("unexpected medications rows %v", medications.Rows)
+	}
+	if _, ok := medications.Rows[0]["race___1"]; ok {
+		t.Errorf("medications table has enrolment columns: %v", medications.Rows[0])
+	}
+}
+
+func TestFlatToLong(t *testing.T) {
+	long := redcap.FlatToLong(reshapeRows, reshapeDictionary)
+	if len(long) != 5 {
+		t.Fatalf("expected 5 values, got %d: %+v", len(long), long)
+	}
+	if long[0] != (redcap.LongRow{Record: "1", Event: "baseline_arm_1", Instrument: "enrolment", Field: "race", Value: "1"}) {
+		t.Errorf("unexpected checkbox value %+v", long[0])
+	}
+	if long[4] != (redcap.LongRow{Record: "1", Event: "week_4_arm_1", Instrument: "medications", RepeatInstance: "1", Field: "dose", Value: "10mg"}) {
+		t.Errorf("unexpected last value %+v", long[4])
+	}
+}
+
+func TestEAVRoundTrip(t *testing.T) {
+	eav := redcap.FlatToEAV(reshapeRows, reshapeDictionary)
+	if len(eav) != 5 || eav[0].FieldName != "race" || eav[0].Value != "1" || eav[2].RepeatInstrument != "medications" {
+		t.Fatalf("unexpected EAV rows %+v", eav)
+	}
+
+	eav = append(eav, redcap.EAVRow{Record: "2", Event: "baseline_arm_1", FieldName: "race", Value: "-1"})
+	header, flat, err := redcap.EAVToFlat(eav, reshapeDictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(header, ",") != "study_id,redcap_event_name,redcap_repeat_instrument,redcap_repeat_instance,race___1,race____1,enrolment_complete,dose,medications_complete" {
+		t.Errorf("unexpected header %v", header)
+	}
+	if len(flat) != 4 {
+		t.Fatalf("expected 4 flat rows, got %d", len(flat))
+	}
+	if flat[0]["race___1"] != "1" || flat[0]["race____1"] != "0" || flat[0]["enrolment_complete"] != "2" {
+		t.Errorf("unexpected first row %v", flat[0])
+	}
+	if flat[2]["dose"] != "10mg" || flat[2]["redcap_event_name"] != "week_4_arm_1" {
+		t.Errorf("unexpected third row %v", flat[2])
+	}
+	if flat[3]["study_id"] != "2" || flat[3]["race____1"] != "1" {
+		t.Errorf("unexpected fourth row %v", flat[3])
+	}
+
+	if _, _, err := redcap.EAVToFlat([]redcap.EAVRow{{Record: "1", FieldName: "weight", Value: "80"}}, reshapeDictionary); err == nil {
+		t.Error("expected an unknown field to be rejected")
+	}
+}
@@ -0,0 +1,94 @@
+package redcaptest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+func TestSurveyLinksReport(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	var listed url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		content, record := req.PostForm.Get("content"), req.PostForm.Get("record")
+		mu.Lock()
+		requests[content]++
+		mu.Unlock()
+
+		if record == "3" && content != "participantList" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"The record '3' does not exist"}`))
+			return
+		}
+		if record == "4" && content == "surveyReturnCode" {
+			panic(http.ErrAbortHandler)
+		}
+		switch content {
+		case "participantList":
+			listed = req.PostForm
+			w.Write([]byte(`[
+				{"email":"ann@example.org","email_occurrence":1,"identifier":"ann","record":"1","invitation_sent_status":1,"response_status":2,"survey_access_code":"ABC","survey_link":"https://redcap.example.org/surveys/?s=ABC","survey_queue_link":""},
+				{"email":"bob@example.org","email_occurrence":1,"identifier":"","record":"","invitation_sent_status":"0","response_status":"0","survey_access_code":"DEF","survey_link":"https://redcap.example.org/surveys/?s=DEF","survey_queue_link":""}
+			]`))
+		case "surveyLink":
+			w.Write([]byte("https://redcap.example.org/surveys/?s=" + record))
+		case "surveyQueueLink":
+			w.Write([]byte("https://redcap.example.org/surveys/?sq=" + record))
+		case "surveyReturnCode":
+			w.Write([]byte("RC" + record + "\n"))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	participants, err := client.ExportSurveyParticipantList("consent", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(participants) != 2 || participants[0].ResponseStatus != redcap.ResponseCompleted || !participants[0].InvitationSentStatus || participants[1].InvitationSentStatus {
+		t.Errorf("unexpected participants %+v", participants)
+	}
+
+	if listed.Get("instrument") != "consent" || listed.Has("event") {
+		t.Errorf("unexpected participant list request %v", listed)
+	}
+	if _, err := client.ExportSurveyParticipantList("consent & more", "visit_1&format=csv"); err != nil {
+		t.Fatal(err)
+	}
+	if listed.Get("instrument") != "consent & more" || listed.Get("event") != "visit_1&format=csv" || listed.Get("format") != "json" {
+		t.Errorf("expected the instrument and event to be escaped, got %v", listed)
+	}
+
+	report, err := client.SurveyLinksReport([]string{"1", "2", "3", "4", "5&format=csv"}, "consent", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(report))
+	}
+	if report[0].Record != "1" || report[0].Email != "ann@example.org" || report[0].Link != "https://redcap.example.org/surveys/?s=1" || report[0].ReturnCode != "RC1" || report[0].Error != nil {
+		t.Errorf("unexpected entry %+v", report[0])
+	}
+	if report[1].Email != "" || report[1].QueueLink != "https://redcap.example.org/surveys/?sq=2" {
+		t.Errorf("unexpected entry %+v", report[1])
+	}
+	if report[2].Error == nil || report[2].Link != "" {
+		t.Errorf("expected record 3 to fail, got %+v", report[2])
+	}
+	if report[3].Error == nil || report[3].Link != "https://redcap.example.org/surveys/?s=4" || report[3].ReturnCode != "" {
+		t.Errorf("expected the dropped return code request to be reported, got %+v", report[3])
+	}
+	if report[4].Error != nil || report[4].ReturnCode != "RC5&format=csv" {
+		t.Errorf("expected the record to be escaped, got %+v", report[4])
+	}
+	if requests["surveyLink"] != 5 || requests["surveyQueueLink"] != 5 || requests["surveyReturnCode"] != 5 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+}
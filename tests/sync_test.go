@@ -0,0 +1,155 @@
+package redcaptest
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// memorySink keeps synced rows by record.
+type memorySink struct {
+	records map[string][]redcap.Record
+	resets  int
+}
+
+func (m *memorySink) Delete(records []string) error {
+	for _, record := range records {
+		delete(m.records, record)
+	}
+	return nil
+}
+
+func (m *memorySink) Upsert(recordIDField string, rows []redcap.Record) error {
+	replaced := make(map[string]bool)
+	for _, row := range rows {
+		id := row[recordIDField]
+		if !replaced[id] {
+			replaced[id] = true
+			m.records[id] = nil
+		}
+		m.records[id] = append(m.records[id], row)
+	}
+	return nil
+}
+
+func (m *memorySink) Reset() error {
+	m.resets++
+	m.records = make(map[string][]redcap.Record)
+	return nil
+}
+
+func (m *memorySink) names() []string {
+	var names []string
+	for name := range m.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestSync(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	clock := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	server.Now = func() time.Time { return clock }
+	client := server.Client(server.AddProject(newTestProject()))
+
+	store := redcap.FileSyncStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	sink := &memorySink{}
+	syncer := redcap.Syncer{Client: &client, Store: store, Sink: sink, Location: time.UTC, Now: func() time.Time { return clock }}
+
+	result, err := syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Full || sink.resets != 1 || !reflect.DeepEqual(sink.names(), []string{"1", "2"}) {
+		t.Fatalf("unexpected first sync %+v, sink holds %v", result, sink.names())
+	}
+	if state, _ := store.Load(); !state.LastSync.Equal(clock) {
+		t.Errorf("expected the mark at %v, got %v", clock, state.LastSync)
+	}
+
+	clock = clock.Add(time.Hour)
+	if _, err := client.ImportRecords([]redcap.Record{
+		{"record_id": "2", "redcap_event_name": "event_1_arm_1", "name": "Grace Hopper"},
+		{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger"},
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.DeleteRecords([]string{"1"}, redcap.DeleteRecordsOptions{Confirm: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(time.Hour)
+	result, err = syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Full || !reflect.DeepEqual(result.Upserted, []string{"2", "3"}) || !reflect.DeepEqual(result.Deleted, []string{"1"}) {
+		t.Errorf("unexpected incremental sync %+v", result)
+	}
+	if !reflect.DeepEqual(sink.names(), []string{"2", "3"}) || sink.records["2"][0]["name"] != "Grace Hopper" || sink.resets != 1 {
+		t.Errorf("unexpected sink %v", sink.records)
+	}
+
+	clock = clock.Add(time.Hour)
+	if result, err = syncer.Sync(); err != nil || len(result.Upserted) != 0 || len(result.Deleted) != 0 {
+		t.Errorf("expected no changes, got %+v, %v", result, err)
+	}
+}
+
+func TestSyncOverlapsTheMark(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	clock := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	server.Now = func() time.Time { return clock }
+	client := server.Client(server.AddProject(newTestProject()))
+
+	// The local clock runs three minutes ahead of the server's, so the mark
+	// is later than changes the server logs just after the sync.
+	store := redcap.FileSyncStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	sink := &memorySink{}
+	syncer := redcap.Syncer{Client: &client, Store: store, Sink: sink, Location: time.UTC, Now: func() time.Time { return clock.Add(3 * time.Minute) }}
+	if _, err := syncer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(time.Minute)
+	if _, err := client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	result, err := syncer.Sync()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Upserted, []string{"3"}) || !result.Since.Equal(clock.Add(-3*time.Minute)) {
+		t.Errorf("expected the overlap to catch record 3, got %+v", result)
+	}
+
+	// Without the overlap the change is lost.
+	if err := store.Save(redcap.SyncState{LastSync: clock.Add(2 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	syncer.Overlap = -1
+	if result, err := syncer.Sync(); err != nil || len(result.Upserted) != 0 {
+		t.Errorf("expected nothing newer than the mark, got %+v, %v", result, err)
+	}
+}
+
+func TestDeletedRecord(t *testing.T) {
+	for action, want := range map[string]string{
+		"Deleted Record 3":                   "3",
+		"Deleted Record 1-12 (Arm 2: Arm 2)": "1-12",
+		"Updated Record 3":                   "",
+	} {
+		got, ok := redcap.DeletedRecord(redcap.LogEntry{Action: action})
+		if got != want || ok != (want != "") {
+			t.Errorf("%q: got %q, %v", action, got, ok)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package redcaptest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+)
+
+var syntaxDictionary = redcap.DataDictionary{
+	{FieldName: "study_id", FormName: "enrolment", FieldType: "text", FieldLabel: "Study ID"},
+	{FieldName: "dob", FormName: "enrolment", FieldType: "text", FieldLabel: "<b>Date</b> of birth", TextValidationTypeOrShowSliderNumber: "date_ymd"},
+	{FieldName: "sex", FormName: "enrolment", FieldType: "radio", FieldLabel: "Sex", SelectChoicesOrCalculations: "0, Female | 1, Male"},
+	{FieldName: "race", FormName: "enrolment", FieldType: "checkbox", FieldLabel: "Race", SelectChoicesOrCalculations: "1, White | 2, Black"},
+	{FieldName: "site", FormName: "enrolment", FieldType: "dropdown", FieldLabel: "Site's name", SelectChoicesOrCalculations: "a, North | b, South"},
+}
+
+var syntaxHeader = []string{"study_id", "redcap_event_name", "dob", "sex", "race___1", "race___2", "site", "enrolment_complete"}
+
+func TestWriteSyntax(t *testing.T) {
+	expected := map[redcap.StatPackage][]string{
+		redcap.R: {
+			`data <- read.csv("records.csv"`,
+			`data$dob <- as.Date(data$dob)`,
+			`attr(data$dob, "label") <- "Date of birth"`,
+			`attr(data$race___2, "label") <- "Race (choice=Black)"`,
+			`data$sex.factor <- factor(data$sex, levels = c("0", "1"), labels = c("Female", "Male"))`,
+		},
+		redcap.SAS: {
+			`value sex_ 0='Female' 1='Male';`,
+			`value $site_ 'a'='North' 'b'='South';`,
+			`informat dob yymmdd10.;`,
+			`label site='Site''s name';`,
+			`format enrolment_complete enrolment_complete_.;`,
+		},
+		redcap.SPSS: {
+			`/FILE="records.csv"`,
+			"dob SDATE10",
+			"sex F8.2",
+			`/redcap_event_name "Event Name"`,
+			`/site "a" "North" "b" "South"`,
+		},
+		redcap.Stata: {
+			`import delimited using "records.csv"`,
+			`generate _temp = date(dob, "YMD")`,
+			`label define sex_ 0 "Female" 1 "Male"`,
+			`label values race___1 race___1_`,
+			`label variable dob "Date of birth"`,
+		},
+	}
+
+	for pkg, lines := range expected {
+		var script bytes.Buffer
+		if err := redcap.WriteSyntax(&script, pkg, syntaxDictionary, syntaxHeader, "records.csv"); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if !strings.Contains(script.String(), line) {
+				t.Errorf("%s: expected %q in\n%s", pkg, line, script.String())
+			}
+		}
+	}
+
+	if strings.Contains(stataScript(t), "label define site_") {
+		t.Error("Stata value labels must not be defined for string codes")
+	}
+	if err := redcap.WriteSyntax(&bytes.Buffer{}, "excel", syntaxDictionary, syntaxHeader, "records.csv"); err == nil {
+		t.Error("expected an unknown package to be rejected")
+	}
+}
+
+func TestWriteSyntaxLongNames(t *testing.T) {
+	prefix := strings.Repeat("x", 31)
+	label := strings.Repeat("é", 79) + `"ü"`
+	dictionary := redcap.DataDictionary{
+		{FieldName: "study_id", FormName: "enrolment", FieldType: "text", FieldLabel: "Study ID"},
+		{FieldName: prefix + "_first", FormName: "enrolment", FieldType: "yesno", FieldLabel: label},
+		{FieldName: prefix + "_second", FormName: "enrolment", FieldType: "yesno", FieldLabel: "Second"},
+	}
+	header := []string{"study_id", prefix + "_first", prefix + "_second"}
+
+	for pkg, lines := range map[redcap.StatPackage][]string{
+		redcap.SAS: {
+			"value " + prefix[:29] + "_1_ 1='Yes' 0='No';",
+			"value " + prefix[:29] + "_2_ 1='Yes' 0='No';",
+			"format " + prefix + "_second " + prefix[:29] + "_2_.;",
+		},
+		redcap.Stata: {
+			"label values " + prefix + "_first " + prefix[:29] + "_1_",
+			"label values " + prefix + "_second " + prefix[:29] + "_2_",
+			"label variable " + prefix + "_first \"" + strings.Repeat("é", 79) + "'\"",
+		},
+	} {
+		var script bytes.Buffer
+		if err := redcap.WriteSyntax(&script, pkg, dictionary, header, "records.csv"); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range lines {
+			if !strings.Contains(script.String(), line) {
+				t.Errorf("%s: expected %q in\n%s", pkg, line, script.String())
+			}
+		}
+		if !utf8.Valid(script.Bytes()) {
+			t.Errorf("%s: the script is not valid UTF-8", pkg)
+		}
+	}
+}
+
+func stataScript(t *testing.T) string {
+	var script bytes.Buffer
+	if err := redcap.WriteSyntax(&script, redcap.Stata, syntaxDictionary, syntaxHeader, "records.csv"); err != nil {
+		t.Fatal(err)
+	}
+	return script.String()
+}
+
+func TestExportForStatPackage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.PostForm.Get("content") {
+		case "metadata":
+			w.Write([]byte(`[{"field_name":"study_id","form_name":"enrolment","field_type":"text","field_label":"Study ID"}]`))
+		case "record":
+			if req.PostForm.Get("rawOrLabel") != "raw" || req.PostForm.Get("format") != "csv" {
+				t.Errorf("unexpected record request %v", req.PostForm)
+			}
+			w.Write([]byte("study_id,enrolment_complete\n1,2\n"))
+		}
+	}))
+	defer server.Close()
+
+	client := redcap.RedCapClient{URL: server.URL, Token: "token", ResponseFormat: "json"}
+
+	dir := t.TempDir()
+	if err := client.ExportForStatPackage(dir, "study", redcap.SPSS, redcap.RecordsOptions{RawOrLabel: "label"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "study.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "study_id,enrolment_complete\n1,2\n" {
+		t.Errorf("unexpected data %q", data)
+	}
+	script, err := os.ReadFile(filepath.Join(dir, "study.sps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(script), `/FILE="study.csv"`) {
+		t.Errorf("unexpected script %s", script)
+	}
+}
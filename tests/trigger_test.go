@@ -0,0 +1,144 @@
+package redcaptest
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// postTrigger sends a Data Entry Trigger from an address and returns the
+// response code.
+func postTrigger(handler http.Handler, target string, remote string, form url.Values, forwarded string) int {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.RemoteAddr = remote
+	if forwarded != "" {
+		request.Header.Set("X-Forwarded-For", forwarded)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestTriggerHandler(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	client := server.Client(server.AddProject(newTestProject()))
+
+	var logged bytes.Buffer
+	handler := &redcap.TriggerHandler{
+		Secret:          "s3cret",
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+		TrustedProxies:  []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		ProjectID:       "15",
+		Client:          &client,
+		ErrorLog:        log.New(&logged, "", 0),
+	}
+	var all, first []*redcap.TriggerEvent
+	handler.Handle("", func(event *redcap.TriggerEvent) error {
+		all = append(all, event)
+		return nil
+	})
+	handler.Handle("instr_1", func(event *redcap.TriggerEvent) error {
+		first = append(first, event)
+		return nil
+	})
+
+	form := url.Values{
+		"redcap_url":        {"https://redcap.example.com/"},
+		"project_id":        {"15"},
+		"username":          {"testuser"},
+		"record":            {"1"},
+		"instrument":        {"instr_1"},
+		"redcap_event_name": {"event_1_arm_1"},
+		"instr_1_complete":  {"2"},
+	}
+	if code := postTrigger(handler, "/det?secret=s3cret", "10.1.2.3:4000", form, ""); code != http.StatusOK {
+		t.Fatalf("expected the trigger to be accepted, got %d: %s", code, logged.String())
+	}
+	if len(all) != 1 || len(first) != 1 {
+		t.Fatalf("expected both handlers to run once, got %d and %d", len(all), len(first))
+	}
+	event := first[0]
+	if event.Record != "1" || event.Event != "event_1_arm_1" || event.Status != redcap.StatusComplete || event.Username != "testuser" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if len(event.Rows) != 1 || event.Rows[0]["name"] != "Ada" {
+		t.Errorf("expected the record to be fetched, got %v", event.Rows)
+	}
+
+	other := url.Values{"project_id": {"15"}, "record": {"2"}, "instrument": {"instr_2"}}
+	if code := postTrigger(handler, "/det?secret=s3cret", "127.0.0.1:4000", other, "192.0.2.9, 10.1.0.7"); code != http.StatusOK {
+		t.Errorf("expected a trigger through the trusted proxy to be accepted, got %d: %s", code, logged.String())
+	}
+	if len(all) != 2 || len(first) != 1 || all[1].Status != redcap.StatusNotStarted {
+		t.Errorf("expected only the catch-all handler to see instr_2, got %d and %d", len(all), len(first))
+	}
+
+	for name, test := range map[string]struct {
+		target, remote, forwarded string
+		form                      url.Values
+		code                      int
+	}{
+		"wrong secret":      {"/det?secret=guess", "10.1.2.3:4000", "", form, http.StatusForbidden},
+		"no secret":         {"/det", "10.1.2.3:4000", "", form, http.StatusForbidden},
+		"outside network":   {"/det?secret=s3cret", "192.0.2.9:4000", "", form, http.StatusForbidden},
+		"untrusted proxy":   {"/det?secret=s3cret", "192.0.2.9:4000", "10.1.2.3", form, http.StatusForbidden},
+		"forwarded outside": {"/det?secret=s3cret", "127.0.0.1:4000", "192.0.2.9", form, http.StatusForbidden},
+		"other project":     {"/det?secret=s3cret", "10.1.2.3:4000", "", url.Values{"project_id": {"16"}, "record": {"1"}, "instrument": {"instr_1"}}, http.StatusForbidden},
+		"no record":         {"/det?secret=s3cret", "10.1.2.3:4000", "", url.Values{"project_id": {"15"}, "instrument": {"instr_1"}}, http.StatusBadRequest},
+	} {
+		if code := postTrigger(handler, test.target, test.remote, test.form, test.forwarded); code != test.code {
+			t.Errorf("%s: expected %d, got %d", name, test.code, code)
+		}
+	}
+	if len(all) != 2 {
+		t.Errorf("expected refused triggers not to reach the handlers, got %d events", len(all))
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/det?secret=s3cret", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected GET to be refused, got %d", recorder.Code)
+	}
+
+	handler.Handle("instr_1", func(event *redcap.TriggerEvent) error {
+		return errors.New("queue full")
+	})
+	if code := postTrigger(handler, "/det?secret=s3cret", "10.1.2.3:4000", form, ""); code != http.StatusInternalServerError {
+		t.Errorf("expected a failing handler to answer 500, got %d", code)
+	}
+	if !strings.Contains(logged.String(), "queue full") {
+		t.Errorf("expected the failure to be logged, got %q", logged.String())
+	}
+}
+
+func TestTriggerHandlerWithREDCapDown(t *testing.T) {
+	server := redcaptest.NewServer()
+	client := server.Client(server.AddProject(newTestProject()))
+	server.Close()
+
+	var logged bytes.Buffer
+	handler := &redcap.TriggerHandler{Client: &client, ErrorLog: log.New(&logged, "", 0)}
+	called := false
+	handler.Handle("", func(event *redcap.TriggerEvent) error {
+		called = true
+		return nil
+	})
+	form := url.Values{"project_id": {"15"}, "record": {"1"}, "instrument": {"instr_1"}}
+	if code := postTrigger(handler, "/det", "10.1.2.3:4000", form, ""); code != http.StatusBadGateway {
+		t.Errorf("expected 502 when REDCap is down, got %d", code)
+	}
+	if called || !strings.Contains(logged.String(), "fetching record 1") {
+		t.Errorf("expected the failed fetch to be logged and not dispatched, got %q", logged.String())
+	}
+}
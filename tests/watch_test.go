@@ -0,0 +1,205 @@
+package redcaptest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	redcap "github.com/tkruer/go-redcap/pkg"
+	"github.com/tkruer/go-redcap/pkg/redcaptest"
+)
+
+// kinds lists the kind and record or user of each event.
+func kinds(events []redcap.ChangeEvent) []string {
+	var listed []string
+	for _, event := range events {
+		listed = append(listed, string(event.Kind)+" "+event.Record+event.User)
+	}
+	return listed
+}
+
+func TestWatcherPoll(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	clock := time.Date(2024, 3, 1, 9, 0, 20, 0, time.UTC)
+	server.Now = func() time.Time { return clock }
+	project := newTestProject()
+	client := server.Client(server.AddProject(project))
+
+	if _, err := client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ImportFile("3", "consent_form", "event_1_arm_1", 0, "signed.pdf", strings.NewReader("%PDF")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ImportContent("user", redcap.JSON, []byte(`[{"username": "testuser", "design": "1"}]`), false); err != nil {
+		t.Fatal(err)
+	}
+
+	watcher := redcap.Watcher{Client: &client, Location: time.UTC, Dictionary: project.Metadata}
+	events, err := watcher.Poll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"record_created 3", "record_updated 3", "file_uploaded 3", "user_rights testuser"}
+	if !reflect.DeepEqual(kinds(events), want) {
+		t.Fatalf("got events %v, want %v", kinds(events), want)
+	}
+	if events[0].Values["name"] != "Edsger" || events[2].Field != "consent_form" || !events[0].Time.Equal(clock.Truncate(time.Minute)) {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	// Resuming from a saved cursor delivers what followed it, including the
+	// rest of an entry whose events were not all handled.
+	saved, err := json.Marshal(events[1].Cursor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := redcap.Watcher{Client: &client, Location: time.UTC, Dictionary: project.Metadata}
+	if err := json.Unmarshal(saved, &resumed.Cursor); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := resumed.Poll(); err != nil || !reflect.DeepEqual(kinds(events), want[1:]) {
+		t.Errorf("resumed with %v, %v", kinds(events), err)
+	}
+
+	if events, err := watcher.Poll(); err != nil || len(events) != 0 {
+		t.Errorf("expected no events on a second poll, got %v, %v", kinds(events), err)
+	}
+	clock = clock.Add(10 * time.Second)
+	if _, err := client.ImportRecords([]redcap.Record{{"record_id": "2", "redcap_event_name": "event_1_arm_1", "name": "Grace Hopper"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	clock = clock.Add(time.Minute)
+	if _, _, err := client.DeleteRecords([]string{"3"}, redcap.DeleteRecordsOptions{Confirm: true}); err != nil {
+		t.Fatal(err)
+	}
+	events, err = watcher.Poll()
+	if want := []string{"record_updated 2", "record_deleted 3"}; err != nil || !reflect.DeepEqual(kinds(events), want) {
+		t.Errorf("expected only the new changes, got %v, %v", kinds(events), err)
+	}
+}
+
+func TestWatcherOrdersNewestFirstLogging(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	project := newTestProject()
+	project.Logging = []redcap.LogEntry{
+		{Timestamp: "2024-03-01 09:05", Username: "admin", Action: "Deleted Record 1 (Arm 2: Arm 2)"},
+		{Timestamp: "2024-03-01 09:01", Username: "admin", Action: "Data export"},
+		{Timestamp: "2024-03-01 09:00", Username: "admin", Action: "Assign user to role", Details: "user = 'alice', role = 'Admin'"},
+	}
+	client := server.Client(server.AddProject(project))
+
+	watcher := redcap.Watcher{Client: &client, Location: time.UTC}
+	events, err := watcher.Poll()
+	if want := []string{"user_rights alice", "record_deleted 1"}; err != nil || !reflect.DeepEqual(kinds(events), want) {
+		t.Errorf("got %v, %v, want %v", kinds(events), err, want)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	client := server.Client(server.AddProject(newTestProject()))
+
+	watcher := redcap.Watcher{Client: &client, Interval: 10 * time.Millisecond, Cursor: redcap.LogCursor{Time: time.Now().Add(-time.Minute)}}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan redcap.ChangeEvent)
+	done := make(chan error, 1)
+	go func() { done <- watcher.Watch(ctx, events) }()
+
+	if _, err := client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger"}}, false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-events:
+		if event.Kind != redcap.ChangeRecordCreated || event.Record != "3" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event from the watcher")
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the watch to end with the context, got %v", err)
+	}
+}
+
+func TestWatchRetriesTransientFailures(t *testing.T) {
+	server := redcaptest.NewServer()
+	defer server.Close()
+	token := server.AddProject(newTestProject())
+	client := server.Client(token)
+	if _, err := client.ImportRecords([]redcap.Record{{"record_id": "3", "redcap_event_name": "event_1_arm_1", "name": "Edsger"}}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first polls fail: one with a server error, one with a dropped
+	// connection.
+	var requests atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch requests.Add(1) {
+		case 1:
+			http.Error(w, `{"error": "unavailable"}`, http.StatusServiceUnavailable)
+		case 2:
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+		default:
+			server.Config.Handler.ServeHTTP(w, r)
+		}
+	}))
+	defer flaky.Close()
+
+	var logged bytes.Buffer
+	flakyClient := redcap.RedCapClient{URL: flaky.URL + "/api/", Token: token, ResponseFormat: redcap.JSON}
+	watcher := redcap.Watcher{Client: &flakyClient, Interval: 5 * time.Millisecond, ErrorLog: log.New(&logged, "", 0)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan redcap.ChangeEvent)
+	done := make(chan error, 1)
+	go func() { done <- watcher.Watch(ctx, events) }()
+
+	select {
+	case event := <-events:
+		if event.Kind != redcap.ChangeRecordCreated || event.Record != "3" {
+			t.Errorf("unexpected event %+v", event)
+		}
+	case err := <-done:
+		t.Fatalf("expected the watch to retry, it ended with %v", err)
+	}
+	cancel()
+	<-done
+	if requests.Load() < 3 || strings.Count(logged.String(), "retrying") != 2 {
+		t.Errorf("expected two logged retries over %d requests, got %q", requests.Load(), logged.String())
+	}
+
+	// A rejected token cannot succeed on retry.
+	rejected := server.Client(strings.Repeat("0", 32))
+	watcher = redcap.Watcher{Client: &rejected, Interval: 5 * time.Millisecond, ErrorLog: log.New(&logged, "", 0)}
+	var apiErr *redcap.APIError
+	if err := watcher.Watch(context.Background(), events); !errors.As(err, &apiErr) {
+		t.Errorf("expected the watch to end with the API error, got %v", err)
+	}
+}
+
+func TestParseLogDetails(t *testing.T) {
+	got := redcap.ParseLogDetails("name = 'Lovelace, Ada', colour(1) = checked, colour(2) = unchecked, age = '36'")
+	want := map[string]string{"name": "Lovelace, Ada", "colour___1": "1", "colour___2": "0", "age": "36"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := redcap.ParseLogDetails("Record ID changed from 3"); len(got) != 0 {
+		t.Errorf("expected no values, got %v", got)
+	}
+}